@@ -6,11 +6,14 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/eientei/cookiejarx"
 	"github.com/eientei/jaroid/discordbot/bot"
 	botConfig "github.com/eientei/jaroid/discordbot/config"
+	"github.com/eientei/jaroid/discordbot/model"
 	"github.com/eientei/jaroid/discordbot/modules/auth"
 	"github.com/eientei/jaroid/discordbot/modules/cleanup"
 	"github.com/eientei/jaroid/discordbot/modules/color"
@@ -24,12 +27,20 @@ import (
 	"github.com/eientei/jaroid/discordbot/modules/reply"
 	"github.com/eientei/jaroid/discordbot/modules/rolereact"
 	"github.com/eientei/jaroid/integration/nicovideo"
+	"github.com/eientei/jaroid/integration/youtubedl"
 	"github.com/eientei/jaroid/mediaservice"
+	"github.com/eientei/jaroid/mediaservice/filestore"
+	"github.com/eientei/jaroid/mediaservice/hls"
 	"github.com/eientei/jaroid/util/httputil/middleware"
 	redis "github.com/go-redis/redis/v7"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// defaultHLSTimeout limits single playlist or segment request of HLS downloaders
+	defaultHLSTimeout = time.Minute
+)
+
 func readConfig(log *logrus.Logger, configPath string) *botConfig.Root {
 	configFile, err := os.OpenFile(configPath, os.O_CREATE|os.O_RDONLY, 0644)
 	if err != nil {
@@ -52,6 +63,138 @@ func readConfig(log *logrus.Logger, configPath string) *botConfig.Root {
 	return c
 }
 
+func newStorage(log *logrus.Logger, configRoot *botConfig.Root) model.Storage {
+	switch configRoot.Private.Storage.Type {
+	case "", "redis":
+		return model.NewRedisStorage(redis.NewClient(&redis.Options{
+			Addr:     configRoot.Private.Redis.Address,
+			Password: configRoot.Private.Redis.Password,
+			DB:       configRoot.Private.Redis.DB,
+		}))
+	case "memory":
+		return model.NewMemoryStorage()
+	case "file":
+		storage, err := model.NewFileStorage(configRoot.Private.Storage.Path)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return storage
+	default:
+		log.Fatalf("Unknown storage type: %s", configRoot.Private.Storage.Type)
+	}
+
+	return nil
+}
+
+func newMedia(
+	log *logrus.Logger,
+	configRoot *botConfig.Root,
+	nicovideoClient *nicovideo.Client,
+) *mediaservice.Registry {
+	registry := mediaservice.NewRegistry()
+	registry.Register("nicovideo", nicovideo.URLPattern, nicovideoClient)
+
+	for _, d := range configRoot.Private.Downloaders {
+		pattern, err := regexp.Compile(d.Pattern)
+		if err != nil {
+			log.Fatalf("Invalid downloader %s pattern: %v", d.Name, err)
+		}
+
+		switch d.Type {
+		case "", "youtubedl":
+			executable := d.Executable
+			if executable == "" {
+				executable = "yt-dlp"
+			}
+
+			registry.Register(d.Name, pattern, &youtubedl.Downloader{
+				ExecutablePath: executable,
+				FormatRegexp:   d.FormatRegexp,
+				CommonArgs:     d.Args,
+			})
+		case "nicovideo":
+			registry.Register(d.Name, pattern, nicovideoClient)
+		case "hls":
+			registry.Register(d.Name, pattern, newHLS(d))
+		default:
+			log.Fatalf("Unknown downloader %s type: %s", d.Name, d.Type)
+		}
+	}
+
+	return registry
+}
+
+// newHLS provides HLS downloader fetching playlists and segments with request timeout
+func newHLS(d botConfig.Downloader) *hls.Client {
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = defaultHLSTimeout
+	}
+
+	return hls.New(&hls.Config{
+		HTTPClient: &http.Client{
+			Timeout: timeout,
+		},
+	})
+}
+
+// newFilesClient provides remote file store HTTP client with request timeout
+func newFilesClient(store botConfig.FileStore) *http.Client {
+	timeout := store.Timeout
+	if timeout <= 0 {
+		timeout = filestore.DefaultTimeout
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+	}
+}
+
+func newFiles(log *logrus.Logger, configRoot *botConfig.Root) filestore.Store {
+	nicovideoConfig := configRoot.Private.Nicovideo
+	store := nicovideoConfig.Store
+
+	switch store.Type {
+	case "", "local":
+		directory, public := store.Directory, store.Public
+		if directory == "" {
+			directory = nicovideoConfig.Directory
+		}
+
+		if public == "" {
+			public = nicovideoConfig.Public
+		}
+
+		return &filestore.Local{
+			Directory: directory,
+			Public:    public,
+		}
+	case "s3":
+		return &filestore.S3{
+			HTTPClient: newFilesClient(store),
+			Endpoint:   store.Endpoint,
+			Region:     store.Region,
+			Bucket:     store.Bucket,
+			AccessKey:  store.AccessKey,
+			SecretKey:  store.SecretKey,
+			Public:     store.Public,
+		}
+	case "webdav":
+		return &filestore.WebDAV{
+			HTTPClient: newFilesClient(store),
+			Endpoint:   store.Endpoint,
+			Username:   store.Username,
+			Password:   store.Password,
+			Public:     store.Public,
+		}
+	default:
+		log.Fatalf("Unknown file store type: %s", store.Type)
+	}
+
+	return nil
+}
+
 func main() {
 	log := logrus.New()
 
@@ -73,11 +216,7 @@ func main() {
 
 	dg.Identify.Intents = discordgo.MakeIntent(discordgo.IntentsAll)
 
-	client := redis.NewClient(&redis.Options{
-		Addr:     configRoot.Private.Redis.Address,
-		Password: configRoot.Private.Redis.Password,
-		DB:       configRoot.Private.Redis.DB,
-	})
+	repositoryStorage := newStorage(log, configRoot)
 
 	var nicovideoAuth *nicovideo.Auth
 
@@ -122,15 +261,19 @@ func main() {
 		},
 	}
 
+	nicovideoAPI := nicovideo.New(&nicovideo.Config{
+		HTTPClient: nicovideoClient,
+		Auth:       nicovideoAuth,
+	})
+
 	b, err := bot.NewBot(bot.Options{
-		Discord: dg,
-		Client:  client,
-		Config:  configRoot,
-		Log:     log,
-		Nicovideo: nicovideo.New(&nicovideo.Config{
-			HTTPClient: nicovideoClient,
-			Auth:       nicovideoAuth,
-		}),
+		Discord:   dg,
+		Storage:   repositoryStorage,
+		Config:    configRoot,
+		Log:       log,
+		Nicovideo: nicovideoAPI,
+		Media:     newMedia(log, configRoot, nicovideoAPI),
+		Files:     newFiles(log, configRoot),
 		Modules: []bot.Module{
 			cleanup.New(),
 			reply.New(),
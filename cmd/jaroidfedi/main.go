@@ -14,6 +14,7 @@ import (
 	"github.com/eientei/jaroid/fedipost/app"
 	"github.com/eientei/jaroid/fedipost/config"
 	"github.com/eientei/jaroid/fedipost/statuses"
+	"github.com/eientei/jaroid/integration/ffmpeg"
 	"github.com/eientei/jaroid/mediaservice"
 	"github.com/eientei/jaroid/nicopost"
 	flags "github.com/jessevdk/go-flags"
@@ -27,12 +28,21 @@ var opts struct {
 	Config    *string `short:"c" long:"config" description:"Config file location (~/.config/jaroid/fedipost.yml)"`
 	CookieJar *string `short:"j" long:"cookie-jar" description:"Cookie jar file (~/.config/jaroid/cookie.jar)"`
 	Listen    *string `long:"listen" optional:"true" optional-value:":0" description:"Listen for authorization code"`
+	FFmpeg    *string `long:"ffmpeg" description:"ffmpeg executable for re-encoding into size of formats like 50m!"`
 
 	NicovideoUsername *string `short:"u" long:"username" description:"Nicovideo username"`
 	NicovideoPassword *string `short:"p" long:"password" description:"Nicovideo password"`
 	Acccount          struct {
 		Code string `long:"code" description:"OAuth2 code"`
 	} `command:"account"`
+	Trim struct {
+		Start string `long:"start" description:"Clip start as seconds or [hh:]mm:ss[.ms]"`
+		End   string `long:"end" description:"Clip end as seconds or [hh:]mm:ss[.ms], end of video by default"`
+	} `command:"trim" description:"Cut a clip from nicovideo video or local mp4 file without re-encoding"`
+	Concat struct {
+	} `command:"concat" description:"Concatenate mp4 files with the same codec parameters into output file"`
+	Faststart struct {
+	} `command:"faststart" description:"Move mp4 index to the front of file for progressive playback"`
 	NicovideoLogin bool `short:"n" long:"nicologin" description:"Nicovideo login and exit"`
 	Quiet          bool `short:"q" long:"quiet" description:"Suppress extra output"`
 	Default        bool `long:"default" description:"Set specifid url/login/args as default"`
@@ -52,8 +62,10 @@ type binconfig struct {
 	format     string
 	subs       string
 	redirect   string
+	args       []string
 	post       bool
 	preview    bool
+	mux        bool
 	list       bool
 	nicologin  bool
 }
@@ -64,6 +76,8 @@ func parseRest(p *flags.Parser, rest []string, c *binconfig) error {
 		case a == "account":
 		case a == "list":
 			c.list = true
+		case a == "mux":
+			c.mux = true
 		case a == "post":
 			c.post = true
 		case a == "preview":
@@ -71,10 +85,15 @@ func parseRest(p *flags.Parser, rest []string, c *binconfig) error {
 			c.preview = true
 		case strings.HasPrefix(a, "sub"):
 			c.subs = a
-		case c.videourl == "":
-			c.videourl = a
-		case c.format == "":
-			c.format = a
+		default:
+			c.args = append(c.args, a)
+
+			switch {
+			case c.videourl == "":
+				c.videourl = a
+			case c.format == "":
+				c.format = a
+			}
 		}
 	}
 
@@ -116,7 +135,7 @@ func parseConfig() (c binconfig) {
 
 	p := flags.NewParser(&opts, flags.Default)
 	p.SubcommandsOptional = true
-	p.Usage = "https://www.nicovideo.jp/watch/sm0000000 <size[!]|formatid|max|list> [post]"
+	p.Usage = "https://www.nicovideo.jp/watch/sm0000000 <size[!]|formatid|max|list> [sub[:lang] [mux]] [post]"
 
 	rest, err := p.ParseArgs(preargs)
 
@@ -166,7 +185,16 @@ func parseConfig() (c binconfig) {
 					"To post a video, add 'post'\n"+
 					"%s https://www.nicovideo.jp/watch/sm0000000 <size[!]|formatid|max> post\n\n"+
 					"To provide authentication for nicoideo\n"+
-					"%s https://www.nicovideo.jp/watch/sm0000000 -u nicovideologin -p nicovideopassword\n\n",
+					"%s https://www.nicovideo.jp/watch/sm0000000 -u nicovideologin -p nicovideopassword\n\n"+
+					"To post a clip instead of whole video, cut it with 'trim'\n"+
+					"%s trim --start 1:30 --end 2:00 https://www.nicovideo.jp/watch/sm0000000 <size[!]|formatid|max> "+
+					"post\n\n"+
+					"To concatenate or prepare for progressive playback local mp4 files\n"+
+					"%s concat -o output.mp4 first.mp4 second.mp4\n"+
+					"%s faststart -o output.mp4 input.mp4\n\n",
+				os.Args[0],
+				os.Args[0],
+				os.Args[0],
 				os.Args[0],
 				os.Args[0],
 				os.Args[0],
@@ -382,6 +410,10 @@ func overrides(f *app.Fedipost) {
 	if opts.Dir != nil {
 		f.Config.Mediaservice.SaveDir = *opts.Dir
 	}
+
+	if opts.FFmpeg != nil {
+		f.Config.Mediaservice.FFmpeg = *opts.FFmpeg
+	}
 }
 
 func main() {
@@ -419,6 +451,10 @@ func main() {
 		handleAccount(ctx, &c, fedipost)
 	}
 
+	if c.command == "concat" || c.command == "faststart" {
+		handleRemux(c)
+	}
+
 	mediaservicecopy := fedipost.Config.Mediaservice
 
 	if c.list {
@@ -427,14 +463,21 @@ func main() {
 
 	var match string
 
-	if c.preview {
+	switch {
+	case c.preview:
 		match = "path/to/file.mp4"
-	} else {
+	case c.command == "trim" && isLocalFile(c.videourl):
+		match = c.videourl
+	default:
 		match = handleDownload(ctx, c, &mediaservicecopy, fedipost.Client)
+
+		if !opts.Quiet {
+			_, _ = fmt.Fprintln(os.Stderr, "Downloaded", c.format, "to", match)
+		}
 	}
 
-	if !opts.Quiet {
-		_, _ = fmt.Fprintln(os.Stderr, "Downloaded", c.format, "to", match)
+	if c.command == "trim" && !c.preview {
+		match = handleTrim(c, match)
 	}
 
 	if c.post {
@@ -459,6 +502,16 @@ func handleDownload(
 	mediaservicecopy *config.Mediaservice,
 	downloader mediaservice.Downloader,
 ) string {
+	if c.command == "trim" {
+		validateVideoURL(c.videourl)
+	}
+
+	// output of trim command is the clip, not the downloaded video
+	output := opts.Output
+	if c.command == "trim" {
+		output = nil
+	}
+
 	fid := nicopost.FormatFileID(path.Base(c.videourl), c.format)
 
 	match, err := nicopost.GlobFind(mediaservicecopy.SaveDir, fid)
@@ -466,7 +519,7 @@ func handleDownload(
 		panic(err)
 	}
 
-	if match != "" && opts.Output == nil {
+	if match != "" && output == nil {
 		return match
 	}
 
@@ -507,12 +560,13 @@ func handleDownload(
 		}
 
 		downopts.Subtitles = append(downopts.Subtitles, c.subs)
+		downopts.MuxSubtitles = c.mux
 	}
 
 	reuse := true
 
-	if opts.Output != nil {
-		match = *opts.Output
+	if output != nil {
+		match = *output
 		reuse = false
 	}
 
@@ -521,9 +575,39 @@ func handleDownload(
 		panic(err)
 	}
 
+	if mediaservicecopy.FFmpeg != "" {
+		match = handleFit(ctx, c, mediaservicecopy.FFmpeg, match)
+	}
+
 	return match
 }
 
+func handleFit(
+	ctx context.Context,
+	c binconfig,
+	executable string,
+	fname string,
+) string {
+	reporter := mediaservice.NewDummyReporter()
+
+	if !opts.Quiet {
+		reporter = startReporter()
+	}
+
+	defer reporter.Close()
+
+	transcoder := &ffmpeg.Transcoder{
+		ExecutablePath: executable,
+	}
+
+	fname, err := transcoder.Fit(ctx, c.format, fname, reporter)
+	if err != nil {
+		panic(err)
+	}
+
+	return fname
+}
+
 func handleList(ctx context.Context, c binconfig, downloader mediaservice.Downloader) {
 	reporter := mediaservice.NewDummyReporter()
 
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eientei/jaroid/mediaservice/remux"
+)
+
+// parseTimestamp parses time as seconds, [hh:]mm:ss[.ms] or go duration
+func parseTimestamp(s string) (d time.Duration, err error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	if d, err = time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+
+	for _, p := range parts {
+		var v float64
+
+		v, err = strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid time: %s", s)
+		}
+
+		d = d*60 + time.Duration(v*float64(time.Second))
+	}
+
+	return d, nil
+}
+
+func isLocalFile(name string) bool {
+	finfo, err := os.Stat(name)
+
+	return err == nil && finfo.Mode().IsRegular()
+}
+
+func exitError(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, err.Error())
+
+	os.Exit(1)
+}
+
+// writeOutput writes result of remux operation to output file, removing it on error
+func writeOutput(output string, op func(dst *os.File) error) {
+	dst, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		exitError(err)
+	}
+
+	err = op(dst)
+
+	cerr := dst.Close()
+	if err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		_ = os.Remove(output)
+
+		exitError(err)
+	}
+}
+
+func openInputs(names []string) (files []*os.File) {
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			exitError(err)
+		}
+
+		files = append(files, f)
+	}
+
+	return
+}
+
+// handleTrim cuts clip from video file, returning clip path
+func handleTrim(c binconfig, input string) string {
+	if c.post && isLocalFile(c.videourl) {
+		exitError(fmt.Errorf("posting requires video URL"))
+	}
+
+	start, err := parseTimestamp(opts.Trim.Start)
+	if err != nil {
+		exitError(err)
+	}
+
+	end, err := parseTimestamp(opts.Trim.End)
+	if err != nil {
+		exitError(err)
+	}
+
+	output := strings.TrimSuffix(input, filepath.Ext(input)) + "-" + start.String() + "-"
+
+	if end > 0 {
+		output += end.String() + ".mp4"
+	} else {
+		output += "end.mp4"
+	}
+
+	if opts.Output != nil {
+		output = *opts.Output
+	}
+
+	src := openInputs([]string{input})[0]
+
+	defer func() {
+		_ = src.Close()
+	}()
+
+	writeOutput(output, func(dst *os.File) error {
+		return remux.Trim(src, dst, start, end)
+	})
+
+	if !opts.Quiet {
+		_, _ = fmt.Fprintln(os.Stderr, "Trimmed", input, "to", output)
+	}
+
+	return output
+}
+
+// handleRemux performs concat and faststart commands and exits
+func handleRemux(c binconfig) {
+	switch {
+	case c.command == "concat" && (opts.Output == nil || len(c.args) < 2):
+		exitError(fmt.Errorf("concat requires output file and at least two input files"))
+	case c.command == "faststart" && len(c.args) != 1:
+		exitError(fmt.Errorf("faststart requires single input file"))
+	}
+
+	srcs := openInputs(c.args)
+
+	output := c.args[0] + ".faststart"
+
+	if opts.Output != nil {
+		output = *opts.Output
+	}
+
+	writeOutput(output, func(dst *os.File) error {
+		if c.command == "concat" {
+			return remux.Concat(dst, srcs...)
+		}
+
+		return remux.Faststart(srcs[0], dst)
+	})
+
+	for _, src := range srcs {
+		_ = src.Close()
+	}
+
+	if opts.Output == nil {
+		err := os.Rename(output, c.args[0])
+		if err != nil {
+			exitError(err)
+		}
+
+		output = c.args[0]
+	}
+
+	if !opts.Quiet {
+		_, _ = fmt.Fprintln(os.Stderr, "Written", output)
+	}
+
+	os.Exit(0)
+}
@@ -3,6 +3,7 @@ package bot
 
 import (
 	"errors"
+	"net/http"
 	"sync"
 	"time"
 
@@ -10,9 +11,10 @@ import (
 	"github.com/eientei/jaroid/discordbot/model"
 	"github.com/eientei/jaroid/discordbot/router"
 	"github.com/eientei/jaroid/integration/nicovideo"
+	"github.com/eientei/jaroid/mediaservice"
+	"github.com/eientei/jaroid/mediaservice/filestore"
 
 	"github.com/bwmarrin/discordgo"
-	redis "github.com/go-redis/redis/v7"
 	"github.com/sirupsen/logrus"
 )
 
@@ -22,22 +24,29 @@ var ErrNoReply = errors.New("noreply")
 // Options provide configuration options for bot
 type Options struct {
 	Discord   *discordgo.Session
-	Client    *redis.Client
+	Storage   model.Storage
 	Config    *config.Root
 	Log       *logrus.Logger
 	Nicovideo *nicovideo.Client
+	Media     *mediaservice.Registry
+	Files     filestore.Store
 	Modules   []Module
 }
 
 // Configuration store configuration for bot
 type Configuration struct {
 	Discord    *discordgo.Session
-	Client     *redis.Client
+	Storage    model.Storage
 	Config     *config.Root
 	Log        *logrus.Logger
 	Router     *router.Router
 	Repository *model.Repository
 	Nicovideo  *nicovideo.Client
+	Media      *mediaservice.Registry
+	Files      filestore.Store
+	HTTP       *http.ServeMux
+	Progress   *Progress
+	Scheduler  *Scheduler
 	bot        *Bot
 	Modules    []Module
 }
@@ -145,6 +154,36 @@ func (conf *Configuration) HasMembers(guildID, roleID string) bool {
 	return guild.hasMembers(roleID)
 }
 
+// RegisterConfigValidator registers validator of guild config key, e.g. nico.template, called by ValidateConfig.
+// Validators are expected to be registered on module initialization.
+func (conf *Configuration) RegisterConfigValidator(key string, validate func(value string) error) {
+	conf.bot.validators[key] = validate
+}
+
+// ValidateConfig validates guild config value with validator registered for key, if any
+func (conf *Configuration) ValidateConfig(key, value string) error {
+	validate, ok := conf.bot.validators[key]
+	if !ok {
+		return nil
+	}
+
+	return validate(value)
+}
+
+// TaskCancelled notifies modules about waiting task given as scope.name removed from queue
+func (conf *Configuration) TaskCancelled(name string, m *model.StreamMessage) {
+	for _, tm := range conf.bot.taskModules {
+		tm.TaskCancelled(name, m)
+	}
+}
+
+// TaskMoved notifies modules about waiting task given as scope.name requeued under new id
+func (conf *Configuration) TaskMoved(name string, m *model.StreamMessage, newID string) {
+	for _, tm := range conf.bot.taskModules {
+		tm.TaskMoved(name, m, newID)
+	}
+}
+
 // Reload provides config reloading interface to modules
 func (conf *Configuration) Reload() {
 	conf.bot.Reload()
@@ -193,18 +232,40 @@ func (bot *Bot) configure(s *server, guild *discordgo.Guild) {
 
 // Reload performs reload of all configuration values in configured modules
 func (bot *Bot) Reload() {
+	bot.m.RLock()
+
+	guildIDs := make([]string, 0, len(bot.servers))
+
 	for k := range bot.servers {
+		guildIDs = append(guildIDs, k)
+	}
+
+	bot.m.RUnlock()
+
+	for _, k := range guildIDs {
 		guild, err := bot.Discord.Guild(k)
 		if err != nil {
 			bot.Log.WithError(err).Error("Getting guild", k)
 			continue
 		}
 
-		bot.configure(bot.guild(guild.ID), guild)
+		bot.configureGuild(guild)
+	}
+}
+
+// configureGuild configures server and modules for guild, one guild at a time
+func (bot *Bot) configureGuild(guild *discordgo.Guild) {
+	s := bot.guild(guild.ID)
 
-		for _, m := range bot.Modules {
-			m.Configure(&bot.Configuration, guild)
-		}
+	bot.cm.Lock()
+	defer bot.cm.Unlock()
+
+	bot.m.Lock()
+	bot.configure(s, guild)
+	bot.m.Unlock()
+
+	for _, m := range bot.Modules {
+		m.Configure(&bot.Configuration, guild)
 	}
 }
 
@@ -220,39 +281,80 @@ type RoleModule interface {
 	RolesChanged(guildID, userID string, added, removed []string)
 }
 
+// TaskModule interface marks modules interested in waiting tasks cancelled or moved by config commands
+type TaskModule interface {
+	TaskCancelled(name string, m *model.StreamMessage)
+	TaskMoved(name string, m *model.StreamMessage, newID string)
+}
+
 // NewBot provides new instance of bot
 func NewBot(options Options) (*Bot, error) {
 	if options.Log == nil {
 		options.Log = logrus.New()
 	}
 
-	var roleModules []RoleModule
+	if options.Media == nil {
+		options.Media = mediaservice.NewRegistry()
+
+		if options.Nicovideo != nil {
+			options.Media.Register("nicovideo", nicovideo.URLPattern, options.Nicovideo)
+		}
+	}
+
+	if options.Files == nil && options.Config != nil {
+		options.Files = &filestore.Local{
+			Directory: options.Config.Private.Nicovideo.Directory,
+			Public:    options.Config.Private.Nicovideo.Public,
+		}
+	}
+
+	var (
+		roleModules []RoleModule
+		taskModules []TaskModule
+	)
 
 	for _, m := range options.Modules {
 		rm, ok := m.(RoleModule)
 		if ok {
 			roleModules = append(roleModules, rm)
 		}
+
+		tm, ok := m.(TaskModule)
+		if ok {
+			taskModules = append(taskModules, tm)
+		}
 	}
 
+	repository := model.NewRepository(options.Storage)
+
 	bot := &Bot{
 		Configuration: Configuration{
 			Discord:    options.Discord,
-			Client:     options.Client,
+			Storage:    options.Storage,
 			Config:     options.Config,
 			Log:        options.Log,
 			Router:     router.NewRouter(),
-			Repository: model.NewRepository(options.Client),
+			Repository: repository,
 			Modules:    options.Modules,
 			Nicovideo:  options.Nicovideo,
+			Media:      options.Media,
+			Files:      options.Files,
+			HTTP:       http.NewServeMux(),
+			Progress:   NewProgress(),
+			Scheduler:  NewScheduler(repository, options.Log),
 		},
 		m:           &sync.RWMutex{},
+		cm:          &sync.Mutex{},
 		roleModules: roleModules,
+		taskModules: taskModules,
 		servers:     make(map[string]*server),
+		validators:  make(map[string]func(value string) error),
 	}
 
 	bot.Configuration.bot = bot
 
+	bot.registerHTTP()
+
 	for _, m := range bot.Modules {
 		err := m.Initialize(&bot.Configuration)
 		if err != nil {
@@ -267,6 +369,10 @@ func NewBot(options Options) (*Bot, error) {
 	bot.Discord.AddHandler(bot.handlerMembersChunk)
 	bot.Discord.AddHandler(bot.handlerMemberRemove)
 	bot.Discord.AddHandler(bot.handlerMemberUpdate)
+	bot.Discord.AddHandler(bot.handlerReady)
+	bot.Discord.AddHandler(bot.handlerInteractionCreate)
+	bot.Discord.AddHandler(bot.handlerResumed)
+	bot.Discord.AddHandler(bot.handlerDisconnect)
 
 	return bot, nil
 }
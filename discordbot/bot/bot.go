@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
@@ -10,9 +11,15 @@ import (
 // Bot is a main implementation of bot
 type Bot struct {
 	Configuration
-	m           *sync.RWMutex
-	servers     map[string]*server
-	roleModules []RoleModule
+	m                  *sync.RWMutex
+	cm                 *sync.Mutex // serializes guild configuration by discord handlers and reloads
+	servers            map[string]*server
+	validators         map[string]func(value string) error
+	roleModules        []RoleModule
+	taskModules        []TaskModule
+	httpServer         *http.Server
+	ready              int32
+	commandsRegistered int32
 }
 
 // Serve starts bot serving loop and blocks until exit
@@ -22,12 +29,21 @@ func (bot *Bot) Serve() error {
 		return err
 	}
 
+	bot.serveHTTP()
+
 	bot.Log.Info("Running")
 
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	<-sc
 
+	bot.shutdownHTTP()
+
+	err = bot.Scheduler.Shutdown(schedulerShutdownTimeout)
+	if err != nil {
+		bot.Log.WithError(err).Error("Shutting down scheduler")
+	}
+
 	for _, m := range bot.Modules {
 		m.Shutdown(&bot.Configuration)
 	}
@@ -1,8 +1,11 @@
 package bot
 
 import (
+	"sync/atomic"
 	"time"
 
+	"github.com/eientei/jaroid/discordbot/router"
+
 	"github.com/bwmarrin/discordgo"
 )
 
@@ -36,18 +39,43 @@ func (bot *Bot) handlerMessageUpdate(session *discordgo.Session, messageUpdate *
 }
 
 func (bot *Bot) handlerGuildCreate(_ *discordgo.Session, guildCreate *discordgo.GuildCreate) {
-	s := bot.guild(guildCreate.ID)
+	bot.configureGuild(guildCreate.Guild)
+
+	err := bot.Discord.RequestGuildMembers(guildCreate.ID, "", 0, "", false)
+	if err != nil {
+		bot.Log.WithError(err).Error("requesting members", guildCreate)
+	}
+}
 
-	bot.m.Lock()
-	bot.configure(s, guildCreate.Guild)
-	bot.m.Unlock()
+func (bot *Bot) handlerReady(session *discordgo.Session, ready *discordgo.Ready) {
+	atomic.StoreInt32(&bot.ready, 1)
 
-	for _, m := range bot.Modules {
-		m.Configure(&bot.Configuration, guildCreate.Guild)
+	// commands do not change while running, so they are registered on first ready only, not on reconnects
+	if !atomic.CompareAndSwapInt32(&bot.commandsRegistered, 0, 1) {
+		return
 	}
 
-	err := bot.Discord.RequestGuildMembers(guildCreate.ID, "", 0, "", false)
+	commands := bot.Router.ApplicationCommands()
+
+	_, err := session.ApplicationCommandBulkOverwrite(ready.User.ID, "", commands)
 	if err != nil {
-		bot.Log.WithError(err).Error("requesting members", guildCreate)
+		bot.Log.WithError(err).Error("Registering application commands")
+
+		atomic.StoreInt32(&bot.commandsRegistered, 0)
+	}
+}
+
+func (bot *Bot) handlerInteractionCreate(session *discordgo.Session, interactionCreate *discordgo.InteractionCreate) {
+	err := bot.Router.DispatchInteraction(session, interactionCreate.Interaction)
+	if err != nil && err != router.ErrNotMatched {
+		bot.Log.WithError(err).Error("Dispatching interaction", interactionCreate.ID)
 	}
 }
+
+func (bot *Bot) handlerResumed(*discordgo.Session, *discordgo.Resumed) {
+	atomic.StoreInt32(&bot.ready, 1)
+}
+
+func (bot *Bot) handlerDisconnect(*discordgo.Session, *discordgo.Disconnect) {
+	atomic.StoreInt32(&bot.ready, 0)
+}
@@ -0,0 +1,219 @@
+package bot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/eientei/jaroid/util/metrics"
+)
+
+const httpShutdownTimeout = time.Second * 5
+
+// snowflakeRegexp matches discord IDs, keeping storage key patterns out of guild config lookups
+var snowflakeRegexp = regexp.MustCompile(`^[0-9]+$`)
+
+// QueueStats describes state of single task stream
+type QueueStats struct {
+	Pending int64 `json:"pending"`
+	Running int   `json:"running"`
+	Dead    int64 `json:"dead"`
+}
+
+// HandleAPI registers handler on bot HTTP server, requiring configured bearer token.
+// Handler is not registered if no token is configured.
+func (conf *Configuration) HandleAPI(pattern string, handler http.HandlerFunc) {
+	token := conf.Config.Private.HTTP.Token
+	if token == "" {
+		return
+	}
+
+	conf.HTTP.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+			return
+		}
+
+		handler(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (bot *Bot) registerHTTP() {
+	bot.HTTP.HandleFunc("/healthz", bot.httpHealth)
+	bot.HTTP.HandleFunc("/readyz", bot.httpReady)
+	// metrics carry no guild or user labels, so they are served without token for scrapers, like probes
+	bot.HTTP.Handle("/metrics", metrics.Default)
+	bot.HandleAPI("/api/queues", bot.httpQueues)
+	bot.HandleAPI("/api/downloads", bot.httpDownloads)
+	bot.HandleAPI("/api/guilds/", bot.httpGuildConfig)
+	bot.HandleAPI("/api/reload", bot.httpReload)
+}
+
+func (bot *Bot) serveHTTP() {
+	if bot.Config.Private.HTTP.Listen == "" {
+		return
+	}
+
+	bot.httpServer = &http.Server{
+		Addr:    bot.Config.Private.HTTP.Listen,
+		Handler: bot.HTTP,
+	}
+
+	go func() {
+		err := bot.httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			bot.Log.WithError(err).Error("Serving HTTP")
+		}
+	}()
+}
+
+func (bot *Bot) shutdownHTTP() {
+	if bot.httpServer == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+
+	err := bot.httpServer.Shutdown(ctx)
+	if err != nil {
+		bot.Log.WithError(err).Error("Shutting down HTTP")
+	}
+}
+
+func (bot *Bot) httpHealth(w http.ResponseWriter, _ *http.Request) {
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (bot *Bot) httpReady(w http.ResponseWriter, _ *http.Request) {
+	if atomic.LoadInt32(&bot.ready) == 0 {
+		http.Error(w, "discord not connected", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	_, err := bot.Storage.Exists("ready")
+	if err != nil {
+		http.Error(w, "storage: "+err.Error(), http.StatusServiceUnavailable)
+
+		return
+	}
+
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (bot *Bot) httpQueues(w http.ResponseWriter, _ *http.Request) {
+	keys, err := bot.Storage.Keys("task.*")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	dead, err := bot.Storage.Keys("dead.*")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	for _, k := range dead {
+		keys = append(keys, "task."+strings.TrimPrefix(k, "dead."))
+	}
+
+	stats := make(map[string]*QueueStats)
+
+	for _, k := range keys {
+		name := strings.TrimPrefix(k, "task.")
+
+		if _, ok := stats[name]; ok {
+			continue
+		}
+
+		s := &QueueStats{}
+
+		s.Pending, err = bot.Storage.StreamLen(k)
+		if err == nil {
+			s.Dead, err = bot.Storage.StreamLen("dead." + name)
+		}
+
+		if err == nil {
+			var running map[string]time.Time
+
+			running, err = bot.Repository.TaskRunning(name)
+			s.Running = len(running)
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		stats[name] = s
+	}
+
+	writeJSON(w, stats)
+}
+
+func (bot *Bot) httpDownloads(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, bot.Progress.List())
+}
+
+func (bot *Bot) httpGuildConfig(w http.ResponseWriter, r *http.Request) {
+	guildID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/guilds/"), "/config")
+	if !snowflakeRegexp.MatchString(guildID) {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	prefix := guildID + "."
+
+	keys, err := bot.Storage.Keys(prefix + "*")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	values := make(map[string]string, len(keys))
+
+	for _, k := range keys {
+		values[strings.TrimPrefix(k, prefix)], err = bot.Storage.Get(k)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+	}
+
+	writeJSON(w, values)
+}
+
+func (bot *Bot) httpReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	bot.Reload()
+
+	w.WriteHeader(http.StatusNoContent)
+}
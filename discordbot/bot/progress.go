@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ProgressEntry describes state of long-running task, such as download
+type ProgressEntry struct {
+	Started     time.Time `json:"started"`
+	Updated     time.Time `json:"updated"`
+	Task        string    `json:"task"`
+	ID          string    `json:"id"`
+	GuildID     string    `json:"guild_id"`
+	Description string    `json:"description"`
+	Status      string    `json:"status"`
+}
+
+// Progress keeps track of long-running tasks progress reported by modules
+type Progress struct {
+	entries map[string]*ProgressEntry
+	m       sync.RWMutex
+}
+
+// NewProgress provides new empty progress registry
+func NewProgress() *Progress {
+	return &Progress{
+		entries: make(map[string]*ProgressEntry),
+	}
+}
+
+// Start registers task with given id
+func (progress *Progress) Start(task, id, guildID, description string) {
+	progress.m.Lock()
+	defer progress.m.Unlock()
+
+	now := time.Now()
+
+	progress.entries[task+"."+id] = &ProgressEntry{
+		Started:     now,
+		Updated:     now,
+		Task:        task,
+		ID:          id,
+		GuildID:     guildID,
+		Description: description,
+	}
+}
+
+// Update sets current status of registered task
+func (progress *Progress) Update(task, id, status string) {
+	progress.m.Lock()
+	defer progress.m.Unlock()
+
+	e, ok := progress.entries[task+"."+id]
+	if !ok {
+		return
+	}
+
+	e.Status = status
+	e.Updated = time.Now()
+}
+
+// Finish removes task from registry
+func (progress *Progress) Finish(task, id string) {
+	progress.m.Lock()
+	defer progress.m.Unlock()
+
+	delete(progress.entries, task+"."+id)
+}
+
+// List returns copy of all registered tasks ordered by start time
+func (progress *Progress) List() (entries []ProgressEntry) {
+	progress.m.RLock()
+	defer progress.m.RUnlock()
+
+	entries = make([]ProgressEntry, 0, len(progress.entries))
+
+	for _, e := range progress.entries {
+		entries = append(entries, *e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Started.Before(entries[j].Started)
+	})
+
+	return
+}
@@ -0,0 +1,180 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidCron is returned for malformed cron expressions
+	ErrInvalidCron = errors.New("invalid cron expression")
+	// ErrInvalidInterval is returned for non-positive schedule intervals
+	ErrInvalidInterval = errors.New("schedule interval must be positive")
+)
+
+// cronHorizon is how far ahead cron schedule is searched for matching time
+const cronHorizon = 5
+
+// cronDescriptors are shorthands for common cron expressions
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Schedule provides run times of scheduled job
+type Schedule interface {
+	// Next returns first run time after given time, zero time if there is none
+	Next(after time.Time) time.Time
+}
+
+type intervalSchedule time.Duration
+
+func (interval intervalSchedule) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(interval))
+}
+
+// Every returns schedule running job with fixed interval
+func Every(interval time.Duration) (Schedule, error) {
+	if interval <= 0 {
+		return nil, ErrInvalidInterval
+	}
+
+	return intervalSchedule(interval), nil
+}
+
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+// Cron parses cron expression of five fields: minute, hour, day of month, month and day of week, each being
+// list of values, ranges a-b or * with optional /step, or one of @hourly, @daily, @weekly, @monthly and @yearly.
+// Times are matched in location of time passed to Next.
+func Cron(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if s, ok := cronDescriptors[spec]; ok {
+		spec = s
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: %s, expected 5 fields", ErrInvalidCron, spec)
+	}
+
+	c := &cronSchedule{}
+
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	masks := [5]*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+
+	for i, f := range fields {
+		mask, err := parseCronField(f, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidCron, f, err)
+		}
+
+		*masks[i] = mask
+	}
+
+	// 7 is sunday as well as 0
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	c.domAny = strings.HasPrefix(fields[2], "*")
+	c.dowAny = strings.HasPrefix(fields[4], "*")
+
+	return c, nil
+}
+
+func parseCronField(field string, min, max int) (mask uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			step, err = strconv.Atoi(part[idx+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %s", part[idx+1:])
+			}
+
+			part = part[:idx]
+		}
+
+		lo, hi := min, max
+
+		switch idx := strings.Index(part, "-"); {
+		case part == "*":
+		case idx >= 0:
+			lo, err = strconv.Atoi(part[:idx])
+			if err == nil {
+				hi, err = strconv.Atoi(part[idx+1:])
+			}
+		default:
+			lo, err = strconv.Atoi(part)
+			hi = lo
+
+			if step > 1 {
+				hi = max
+			}
+		}
+
+		if err != nil || lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("invalid range %s", part)
+		}
+
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+
+	return mask, nil
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		// either of restricted day fields matches, as in standard cron
+		return dom || dow
+	}
+}
+
+func (c *cronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronHorizon, 0, 0)
+
+	for t.Before(limit) {
+		y, m, d := t.Date()
+
+		switch {
+		case c.month&(1<<uint(m)) == 0:
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,295 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/eientei/jaroid/discordbot/model"
+	"github.com/sirupsen/logrus"
+)
+
+// schedulerShutdownTimeout is how long shutdown waits for running jobs and workers to stop
+const schedulerShutdownTimeout = time.Second * 10
+
+var (
+	// ErrSchedulerStopped is returned when adding jobs to scheduler after shutdown
+	ErrSchedulerStopped = errors.New("scheduler is stopped")
+	// ErrInvalidJob is returned when job has no name, schedule or run function
+	ErrInvalidJob = errors.New("job must have name, schedule and run function")
+	// ErrShutdownTimeout is returned when jobs or workers did not stop in time
+	ErrShutdownTimeout = errors.New("timed out waiting for jobs to stop")
+)
+
+// Job describes scheduled job
+type Job struct {
+	// Schedule provides run times of job
+	Schedule Schedule
+	// First is first run time used when there is no persisted next run time, taken from schedule if zero
+	First time.Time
+	// Run is called on each scheduled run, context is cancelled on job removal or scheduler shutdown
+	Run func(ctx context.Context) error
+	// Name identifies job, persisted next run time is stored under this name
+	Name string
+	// Jitter is maximum random delay added to each run time
+	Jitter time.Duration
+	// Concurrency is maximum number of simultaneous runs, runs due above the limit are skipped, 1 if zero
+	Concurrency int
+}
+
+type scheduledJob struct {
+	job       *Job
+	runCtx    context.Context
+	cancel    context.CancelFunc
+	runCancel context.CancelFunc
+	slots     chan struct{}
+}
+
+// Scheduler runs interval and cron jobs with next run times persisted in repository, as well as long-running
+// workers, stopping all of them on shutdown
+type Scheduler struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	repository *model.Repository
+	log        *logrus.Logger
+	jobs       map[string]*scheduledJob
+	wg         sync.WaitGroup
+	m          sync.Mutex
+}
+
+// NewScheduler provides new scheduler instance
+func NewScheduler(repository *model.Repository, log *logrus.Logger) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Scheduler{
+		ctx:        ctx,
+		cancel:     cancel,
+		repository: repository,
+		log:        log,
+		jobs:       make(map[string]*scheduledJob),
+	}
+}
+
+// Add schedules job, replacing job with the same name. Replaced job keeps its persisted next run time, its runs
+// still in progress are not cancelled and count towards concurrency limit of the new one.
+func (scheduler *Scheduler) Add(job *Job) error {
+	if job.Name == "" || job.Schedule == nil || job.Run == nil {
+		return ErrInvalidJob
+	}
+
+	scheduler.m.Lock()
+	defer scheduler.m.Unlock()
+
+	if scheduler.ctx.Err() != nil {
+		return ErrSchedulerStopped
+	}
+
+	n := job.Concurrency
+	if n <= 0 {
+		n = 1
+	}
+
+	sj := &scheduledJob{
+		job: job,
+	}
+
+	if old, ok := scheduler.jobs[job.Name]; ok {
+		old.cancel()
+
+		sj.slots, sj.runCtx, sj.runCancel = old.slots, old.runCtx, old.runCancel
+	} else {
+		sj.runCtx, sj.runCancel = context.WithCancel(scheduler.ctx)
+	}
+
+	if cap(sj.slots) != n {
+		sj.slots = make(chan struct{}, n)
+	}
+
+	var ctx context.Context
+
+	ctx, sj.cancel = context.WithCancel(scheduler.ctx)
+
+	scheduler.jobs[job.Name] = sj
+
+	scheduler.wg.Add(1)
+
+	go scheduler.loop(ctx, sj)
+
+	return nil
+}
+
+// Remove stops job, cancelling its runs in progress, and removes its persisted next run time
+func (scheduler *Scheduler) Remove(name string) {
+	scheduler.m.Lock()
+	defer scheduler.m.Unlock()
+
+	if sj, ok := scheduler.jobs[name]; ok {
+		sj.cancel()
+		sj.runCancel()
+
+		delete(scheduler.jobs, name)
+	}
+
+	err := scheduler.repository.ScheduleDel(name)
+	if err != nil {
+		scheduler.log.WithError(err).Error("Removing job schedule", name)
+	}
+}
+
+// Next returns next run time of job, zero time if it is not scheduled
+func (scheduler *Scheduler) Next(name string) time.Time {
+	scheduler.m.Lock()
+	_, ok := scheduler.jobs[name]
+	scheduler.m.Unlock()
+
+	if !ok {
+		return time.Time{}
+	}
+
+	next, _ := scheduler.repository.ScheduleGet(name)
+
+	return next
+}
+
+// Go starts long-running worker, context is cancelled on scheduler shutdown
+func (scheduler *Scheduler) Go(name string, worker func(ctx context.Context)) {
+	scheduler.m.Lock()
+	defer scheduler.m.Unlock()
+
+	if scheduler.ctx.Err() != nil {
+		return
+	}
+
+	scheduler.wg.Add(1)
+
+	go func() {
+		defer scheduler.wg.Done()
+
+		worker(scheduler.ctx)
+
+		scheduler.log.Debug("Worker stopped ", name)
+	}()
+}
+
+// Stopped returns true once scheduler is shut down
+func (scheduler *Scheduler) Stopped() bool {
+	return scheduler.ctx.Err() != nil
+}
+
+// Shutdown cancels all jobs and workers, waiting for them to stop up to given timeout
+func (scheduler *Scheduler) Shutdown(timeout time.Duration) error {
+	scheduler.m.Lock()
+	scheduler.cancel()
+	scheduler.m.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		scheduler.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return ErrShutdownTimeout
+	}
+}
+
+// next returns run time of job after given time with jitter applied
+func (scheduler *Scheduler) next(job *Job, after time.Time) time.Time {
+	t := job.Schedule.Next(after)
+
+	if !t.IsZero() && job.Jitter > 0 {
+		t = t.Add(time.Duration(rand.Int63n(int64(job.Jitter))))
+	}
+
+	return t
+}
+
+// first returns persisted next run time of job, which is in the past if run was missed while bot was down
+func (scheduler *Scheduler) first(job *Job) time.Time {
+	t, err := scheduler.repository.ScheduleGet(job.Name)
+	if err != nil {
+		scheduler.log.WithError(err).Error("Getting job schedule", job.Name)
+	}
+
+	switch {
+	case !t.IsZero():
+		return t
+	case !job.First.IsZero():
+		return job.First
+	default:
+		return scheduler.next(job, time.Now())
+	}
+}
+
+func (scheduler *Scheduler) loop(ctx context.Context, sj *scheduledJob) {
+	defer scheduler.wg.Done()
+
+	next := scheduler.first(sj.job)
+
+	for !next.IsZero() {
+		scheduler.persist(ctx, sj.job.Name, next)
+
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return
+		case <-timer.C:
+		}
+
+		scheduler.start(sj)
+
+		next = scheduler.next(sj.job, time.Now())
+	}
+
+	scheduler.log.Warn("Job has no more runs ", sj.job.Name)
+}
+
+// persist stores next run time of job unless it was removed or replaced meanwhile
+func (scheduler *Scheduler) persist(ctx context.Context, name string, next time.Time) {
+	scheduler.m.Lock()
+	defer scheduler.m.Unlock()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	err := scheduler.repository.ScheduleSet(name, next)
+	if err != nil {
+		scheduler.log.WithError(err).Error("Saving job schedule", name)
+	}
+}
+
+// start runs job in background if concurrency limit allows
+func (scheduler *Scheduler) start(sj *scheduledJob) {
+	select {
+	case sj.slots <- struct{}{}:
+	default:
+		scheduler.log.Warn("Skipping job run, previous run is still in progress ", sj.job.Name)
+
+		return
+	}
+
+	scheduler.wg.Add(1)
+
+	go func() {
+		defer func() {
+			<-sj.slots
+
+			scheduler.wg.Done()
+		}()
+
+		err := sj.job.Run(sj.runCtx)
+		if err != nil && sj.runCtx.Err() == nil {
+			scheduler.log.WithError(err).Error("Running job ", sj.job.Name)
+		}
+	}()
+}
@@ -11,20 +11,69 @@ type Redis struct {
 	DB       int    `yaml:"db"`
 }
 
+// Storage backend part of configuration
+type Storage struct {
+	Type string `yaml:"type"`
+	Path string `yaml:"path"`
+}
+
+// HTTP admin/status server configuration
+type HTTP struct {
+	Listen string `yaml:"listen"`
+	Token  string `yaml:"token"`
+}
+
 // NicovideoAuth authentication details
 type NicovideoAuth struct {
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
 }
 
-// Nicovideo download configuration
-type Nicovideo struct {
+// FileStore configures storage target of downloaded files, download directory by default
+type FileStore struct {
+	Type      string        `yaml:"type"`
+	Endpoint  string        `yaml:"endpoint"`
+	Region    string        `yaml:"region"`
+	Bucket    string        `yaml:"bucket"`
+	AccessKey string        `yaml:"access_key"`
+	SecretKey string        `yaml:"secret_key"`
+	Username  string        `yaml:"username"`
+	Password  string        `yaml:"password"`
 	Directory string        `yaml:"directory"`
 	Public    string        `yaml:"public"`
-	Auth      NicovideoAuth `yaml:"auth"`
-	Period    time.Duration `yaml:"period"`
-	Backoff   time.Duration `yaml:"backoff"`
-	Limit     int           `yaml:"limit"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+// Nicovideo download configuration
+type Nicovideo struct {
+	Directory    string        `yaml:"directory"`
+	Public       string        `yaml:"public"`
+	Secret       string        `yaml:"secret"`
+	Quota        string        `yaml:"quota"`
+	Auth         NicovideoAuth `yaml:"auth"`
+	Store        FileStore     `yaml:"store"`
+	Period       time.Duration `yaml:"period"`
+	Backoff      time.Duration `yaml:"backoff"`
+	Limit        int           `yaml:"limit"`
+	Workers      int           `yaml:"workers"`
+	GuildWorkers int           `yaml:"guild_workers"`
+}
+
+// Downloader maps URL pattern to media downloader
+type Downloader struct {
+	Name         string        `yaml:"name"`
+	Pattern      string        `yaml:"pattern"`
+	Type         string        `yaml:"type"`
+	Executable   string        `yaml:"executable"`
+	FormatRegexp string        `yaml:"format_regexp"`
+	Args         []string      `yaml:"args"`
+	Timeout      time.Duration `yaml:"timeout"`
+}
+
+// FFmpeg re-encoding configuration, used to fit downloads into size constraints like 50m!
+type FFmpeg struct {
+	Executable string   `yaml:"executable"`
+	Args       []string `yaml:"args"`
 }
 
 // Pleroma nicomodule configuration
@@ -41,7 +90,11 @@ type Private struct {
 	ModulePrefix map[string]string `yaml:"module_prefix"`
 	Data         string            `yaml:"data"`
 	Redis        Redis             `yaml:"redis"`
+	Storage      Storage           `yaml:"storage"`
+	HTTP         HTTP              `yaml:"http"`
 	Nicovideo    Nicovideo         `yaml:"nicovideo"`
+	FFmpeg       FFmpeg            `yaml:"ffmpeg"`
+	Downloaders  []Downloader      `yaml:"downloaders"`
 }
 
 // Server specific part of configuration
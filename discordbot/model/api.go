@@ -1,10 +1,6 @@
 // Package model provides model and task repositories
 package model
 
-import (
-	redis "github.com/go-redis/redis/v7"
-)
-
 // Task provides interface for persistable tasks
 type Task interface {
 	Scope() string
@@ -12,9 +8,8 @@ type Task interface {
 }
 
 // NewRepository provides Repository instance
-func NewRepository(client *redis.Client) *Repository {
+func NewRepository(storage Storage) *Repository {
 	return &Repository{
-		Client: client,
-		Groups: make(map[string]bool),
+		Storage: storage,
 	}
 }
@@ -0,0 +1,438 @@
+package model
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewMemoryStorage provides Storage keeping everything in process memory
+func NewMemoryStorage() Storage {
+	return newMemoryStorage()
+}
+
+// NewFileStorage provides in-memory Storage persisted as JSON snapshot to given file after every change
+func NewFileStorage(filename string) (Storage, error) {
+	storage := newMemoryStorage()
+	storage.filename = filename
+
+	bs, err := ioutil.ReadFile(filename)
+
+	switch {
+	case os.IsNotExist(err):
+		return storage, nil
+	case err != nil:
+		return nil, err
+	}
+
+	err = json.Unmarshal(bs, &storage.snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	if storage.Values == nil {
+		storage.Values = make(map[string]*memoryValue)
+	}
+
+	if storage.Streams == nil {
+		storage.Streams = make(map[string]*memoryStream)
+	}
+
+	return storage, nil
+}
+
+type memoryValue struct {
+	Value   string    `json:"value"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+func (v *memoryValue) expired(now time.Time) bool {
+	return !v.Expires.IsZero() && now.After(v.Expires)
+}
+
+type memoryEntry struct {
+	StreamMessage
+	Delivered bool `json:"delivered"`
+}
+
+type memoryStream struct {
+	Entries []*memoryEntry `json:"entries"`
+	Last    string         `json:"last"`
+}
+
+type snapshot struct {
+	Values  map[string]*memoryValue  `json:"values"`
+	Streams map[string]*memoryStream `json:"streams"`
+}
+
+type memoryStorage struct {
+	snapshot
+	filename string
+	notify   chan struct{}
+	m        sync.Mutex
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		snapshot: snapshot{
+			Values:  make(map[string]*memoryValue),
+			Streams: make(map[string]*memoryStream),
+		},
+		notify: make(chan struct{}),
+	}
+}
+
+// save writes snapshot to file, must be called with lock held
+func (storage *memoryStorage) save() error {
+	if storage.filename == "" {
+		return nil
+	}
+
+	bs, err := json.Marshal(&storage.snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(storage.filename), filepath.Base(storage.filename)+".*")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(bs)
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+
+		return err
+	}
+
+	return os.Rename(tmp.Name(), storage.filename)
+}
+
+// value returns non-expired value, must be called with lock held
+func (storage *memoryStorage) value(key string) *memoryValue {
+	v, ok := storage.Values[key]
+	if !ok {
+		return nil
+	}
+
+	if v.expired(time.Now()) {
+		delete(storage.Values, key)
+
+		return nil
+	}
+
+	return v
+}
+
+func (storage *memoryStorage) Get(key string) (string, error) {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	if v := storage.value(key); v != nil {
+		return v.Value, nil
+	}
+
+	return "", nil
+}
+
+func (storage *memoryStorage) Set(key, value string, expire time.Duration) error {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	v := &memoryValue{
+		Value: value,
+	}
+
+	if expire > 0 {
+		v.Expires = time.Now().Add(expire)
+	}
+
+	storage.Values[key] = v
+
+	return storage.save()
+}
+
+func (storage *memoryStorage) Del(keys ...string) error {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	for _, k := range keys {
+		delete(storage.Values, k)
+		delete(storage.Streams, k)
+	}
+
+	return storage.save()
+}
+
+func (storage *memoryStorage) Keys(pattern string) (keys []string, err error) {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	now := time.Now()
+
+	for k, v := range storage.Values {
+		if v.expired(now) {
+			continue
+		}
+
+		if ok, _ := path.Match(pattern, k); ok {
+			keys = append(keys, k)
+		}
+	}
+
+	for k := range storage.Streams {
+		if ok, _ := path.Match(pattern, k); ok {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return
+}
+
+func (storage *memoryStorage) Exists(key string) (bool, error) {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	if _, ok := storage.Streams[key]; ok {
+		return true, nil
+	}
+
+	return storage.value(key) != nil, nil
+}
+
+func (storage *memoryStorage) Incr(key string) (n int64, err error) {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	v := storage.value(key)
+	if v == nil {
+		v = &memoryValue{}
+		storage.Values[key] = v
+	}
+
+	if v.Value != "" {
+		n, err = strconv.ParseInt(v.Value, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n++
+
+	v.Value = strconv.FormatInt(n, 10)
+
+	return n, storage.save()
+}
+
+func (storage *memoryStorage) Expire(key string, expire time.Duration) error {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	v := storage.value(key)
+	if v == nil {
+		return nil
+	}
+
+	v.Expires = time.Now().Add(expire)
+
+	return storage.save()
+}
+
+func parseStreamID(id string) (ms, seq uint64) {
+	parts := strings.SplitN(id, "-", 2)
+
+	ms, _ = strconv.ParseUint(parts[0], 10, 64)
+
+	if len(parts) > 1 {
+		seq, _ = strconv.ParseUint(parts[1], 10, 64)
+	}
+
+	return
+}
+
+func compareStreamID(a, b string) int {
+	ams, aseq := parseStreamID(a)
+	bms, bseq := parseStreamID(b)
+
+	switch {
+	case ams < bms, ams == bms && aseq < bseq:
+		return -1
+	case ams == bms && aseq == bseq:
+		return 0
+	default:
+		return 1
+	}
+}
+
+func (storage *memoryStorage) StreamAdd(stream string, values map[string]string) (string, error) {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	s, ok := storage.Streams[stream]
+	if !ok {
+		s = &memoryStream{}
+		storage.Streams[stream] = s
+	}
+
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+
+	var seq uint64
+
+	if lastms, lastseq := parseStreamID(s.Last); lastms >= ms {
+		ms, seq = lastms, lastseq+1
+	}
+
+	vs := make(map[string]string, len(values))
+
+	for k, v := range values {
+		vs[k] = v
+	}
+
+	s.Last = strconv.FormatUint(ms, 10) + "-" + strconv.FormatUint(seq, 10)
+	s.Entries = append(s.Entries, &memoryEntry{
+		StreamMessage: StreamMessage{
+			ID:     s.Last,
+			Values: vs,
+		},
+	})
+
+	close(storage.notify)
+	storage.notify = make(chan struct{})
+
+	return s.Last, storage.save()
+}
+
+func (storage *memoryStorage) StreamRange(stream, start, end string) (res []StreamMessage, err error) {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	s, ok := storage.Streams[stream]
+	if !ok {
+		return nil, nil
+	}
+
+	for _, e := range s.Entries {
+		if start != "-" && compareStreamID(e.ID, start) < 0 {
+			continue
+		}
+
+		if end != "+" && compareStreamID(e.ID, end) > 0 {
+			continue
+		}
+
+		res = append(res, e.StreamMessage)
+	}
+
+	return
+}
+
+func (storage *memoryStorage) read(stream string, pending bool) (res []StreamMessage, notify chan struct{}, err error) {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	s, ok := storage.Streams[stream]
+	if !ok {
+		return nil, storage.notify, nil
+	}
+
+	for _, e := range s.Entries {
+		if e.Delivered != pending {
+			continue
+		}
+
+		e.Delivered = true
+
+		res = append(res, e.StreamMessage)
+	}
+
+	if !pending && len(res) > 0 {
+		err = storage.save()
+	}
+
+	return res, storage.notify, err
+}
+
+func (storage *memoryStorage) StreamRead(stream string, pending bool, block time.Duration) ([]StreamMessage, error) {
+	var timeout <-chan time.Time
+
+	if block > 0 {
+		timer := time.NewTimer(block)
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	for {
+		res, notify, err := storage.read(stream, pending)
+		if err != nil || len(res) > 0 || pending {
+			return res, err
+		}
+
+		select {
+		case <-notify:
+		case <-timeout:
+			return nil, nil
+		}
+	}
+}
+
+func (storage *memoryStorage) StreamAck(stream string, ids ...string) error {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	s, ok := storage.Streams[stream]
+	if !ok {
+		return nil
+	}
+
+	entries := s.Entries[:0]
+
+	for _, e := range s.Entries {
+		var acked bool
+
+		for _, id := range ids {
+			if e.ID == id {
+				acked = true
+
+				break
+			}
+		}
+
+		if !acked {
+			entries = append(entries, e)
+		}
+	}
+
+	s.Entries = entries
+
+	return storage.save()
+}
+
+func (storage *memoryStorage) StreamLen(stream string) (int64, error) {
+	storage.m.Lock()
+	defer storage.m.Unlock()
+
+	s, ok := storage.Streams[stream]
+	if !ok {
+		return 0, nil
+	}
+
+	return int64(len(s.Entries)), nil
+}
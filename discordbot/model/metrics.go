@@ -0,0 +1,31 @@
+package model
+
+import (
+	"strings"
+
+	"github.com/eientei/jaroid/util/metrics"
+)
+
+var (
+	metricTaskOperations = metrics.NewCounter(
+		"jaroid_task_operations_total",
+		"Number of task operations by stream",
+		"stream", "operation",
+	)
+	metricTaskDuration = metrics.NewHistogram(
+		"jaroid_task_operation_duration_seconds",
+		"Duration of task storage operations by stream",
+		metrics.DefaultBuckets,
+		"stream", "operation",
+	)
+	metricTaskWait = metrics.NewHistogram(
+		"jaroid_task_wait_seconds",
+		"Time tasks spent waiting in queue after becoming due, by stream",
+		metrics.DefaultBuckets,
+		"stream",
+	)
+)
+
+func streamLabel(fkey string) string {
+	return strings.TrimPrefix(fkey, "task.")
+}
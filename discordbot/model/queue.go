@@ -0,0 +1,148 @@
+package model
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runningExpire limits lifetime of running task marker, in case task was never acknowledged
+const runningExpire = time.Hour * 24
+
+var (
+	// ErrTaskRunning is returned when operation can not be applied to task being executed
+	ErrTaskRunning = errors.New("task is running")
+)
+
+func runningKey(fkey, id string) string {
+	return "running." + strings.TrimPrefix(fkey, "task.") + "." + id
+}
+
+func entryPriority(m *StreamMessage) int64 {
+	priority, _ := strconv.ParseInt(m.Values["priority"], 10, 64)
+
+	return priority
+}
+
+// sortQueue orders stream entries in execution order, tasks moved to front first
+func sortQueue(ms []StreamMessage) {
+	sort.SliceStable(ms, func(i, j int) bool {
+		return entryPriority(&ms[i]) > entryPriority(&ms[j])
+	})
+}
+
+// ack acknowledges and removes stream entries along with their running markers
+func (repo *Repository) ack(fkey string, ids ...string) error {
+	err := repo.Storage.StreamAck(fkey, ids...)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(ids))
+
+	for _, id := range ids {
+		keys = append(keys, runningKey(fkey, id))
+	}
+
+	return repo.Storage.Del(keys...)
+}
+
+// TaskQueue returns all not yet acknowledged tasks given as scope.name, in execution order
+func (repo *Repository) TaskQueue(name string) ([]StreamMessage, error) {
+	ms, err := repo.Storage.StreamRange("task."+name, "-", "+")
+	if err != nil {
+		return nil, err
+	}
+
+	sortQueue(ms)
+
+	return ms, nil
+}
+
+// TaskRunning returns ids of tasks given as scope.name being executed, along with their start time
+func (repo *Repository) TaskRunning(name string) (running map[string]time.Time, err error) {
+	prefix := runningKey("task."+name, "")
+
+	keys, err := repo.Storage.Keys(prefix + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	running = make(map[string]time.Time, len(keys))
+
+	for _, k := range keys {
+		var raw string
+
+		raw, err = repo.Storage.Get(k)
+		if err != nil {
+			return nil, err
+		}
+
+		started, _ := strconv.ParseInt(raw, 10, 64)
+
+		running[strings.TrimPrefix(k, prefix)] = time.Unix(started, 0)
+	}
+
+	return
+}
+
+func (repo *Repository) isRunning(fkey, id string) (bool, error) {
+	return repo.Storage.Exists(runningKey(fkey, id))
+}
+
+// TaskCancel removes waiting task given as scope.name from queue
+func (repo *Repository) TaskCancel(name, id string) error {
+	fkey := "task." + name
+
+	_, err := repo.taskEntry(fkey, id)
+	if err != nil {
+		return err
+	}
+
+	running, err := repo.isRunning(fkey, id)
+	if err != nil {
+		return err
+	}
+
+	if running {
+		return ErrTaskRunning
+	}
+
+	return repo.ack(fkey, id)
+}
+
+// TaskFront moves waiting task given as scope.name to the front of queue, returning its new id
+func (repo *Repository) TaskFront(name, id string) (newid string, err error) {
+	fkey := "task." + name
+
+	m, err := repo.taskEntry(fkey, id)
+	if err != nil {
+		return "", err
+	}
+
+	running, err := repo.isRunning(fkey, id)
+	if err != nil {
+		return "", err
+	}
+
+	if running {
+		return "", ErrTaskRunning
+	}
+
+	values := make(map[string]string, len(m.Values)+1)
+
+	for k, v := range m.Values {
+		values[k] = v
+	}
+
+	values["priority"] = strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	newid, err = repo.Storage.StreamAdd(fkey, values)
+	if err != nil {
+		return "", err
+	}
+
+	return newid, repo.ack(fkey, id)
+}
@@ -0,0 +1,165 @@
+package model
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	redis "github.com/go-redis/redis/v7"
+)
+
+const streamGroup = "tasks"
+
+// NewRedisStorage provides Storage backed by redis server
+func NewRedisStorage(client *redis.Client) Storage {
+	return &redisStorage{
+		client: client,
+		groups: make(map[string]bool),
+	}
+}
+
+type redisStorage struct {
+	client *redis.Client
+	groups map[string]bool
+	m      sync.RWMutex
+}
+
+func (storage *redisStorage) Get(key string) (s string, err error) {
+	s, err = storage.client.Get(key).Result()
+	if err == redis.Nil {
+		err = nil
+	}
+
+	return
+}
+
+func (storage *redisStorage) Set(key, value string, expire time.Duration) error {
+	return storage.client.Set(key, value, expire).Err()
+}
+
+func (storage *redisStorage) Del(keys ...string) error {
+	return storage.client.Del(keys...).Err()
+}
+
+func (storage *redisStorage) Keys(pattern string) ([]string, error) {
+	return storage.client.Keys(pattern).Result()
+}
+
+func (storage *redisStorage) Exists(key string) (bool, error) {
+	n, err := storage.client.Exists(key).Result()
+
+	return n != 0, err
+}
+
+func (storage *redisStorage) Incr(key string) (int64, error) {
+	return storage.client.Incr(key).Result()
+}
+
+func (storage *redisStorage) Expire(key string, expire time.Duration) error {
+	return storage.client.Expire(key, expire).Err()
+}
+
+func (storage *redisStorage) StreamAdd(stream string, values map[string]string) (string, error) {
+	vs := make(map[string]interface{}, len(values))
+
+	for k, v := range values {
+		vs[k] = v
+	}
+
+	return storage.client.XAdd(&redis.XAddArgs{
+		Stream: stream,
+		Values: vs,
+	}).Result()
+}
+
+func (storage *redisStorage) StreamRange(stream, start, end string) ([]StreamMessage, error) {
+	ms, err := storage.client.XRange(stream, start, end).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return redisMessages(ms), nil
+}
+
+func (storage *redisStorage) ensureGroup(stream string) {
+	storage.m.RLock()
+
+	_, ok := storage.groups[stream]
+
+	storage.m.RUnlock()
+
+	if !ok {
+		storage.m.Lock()
+		defer storage.m.Unlock()
+
+		_, ok = storage.groups[stream]
+		if ok {
+			return
+		}
+
+		storage.client.XGroupCreateMkStream(stream, streamGroup, "0")
+
+		storage.groups[stream] = true
+	}
+}
+
+func (storage *redisStorage) StreamRead(stream string, pending bool, block time.Duration) ([]StreamMessage, error) {
+	storage.ensureGroup(stream)
+
+	start := ">"
+	if pending {
+		start = "0"
+	}
+
+	res, err := storage.client.XReadGroup(&redis.XReadGroupArgs{
+		Group:    streamGroup,
+		Consumer: "dequeue",
+		Streams:  []string{stream, start},
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		err = nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var ms []StreamMessage
+
+	for _, s := range res {
+		ms = append(ms, redisMessages(s.Messages)...)
+	}
+
+	return ms, nil
+}
+
+func (storage *redisStorage) StreamAck(stream string, ids ...string) (err error) {
+	tx := storage.client.TxPipeline()
+	tx.XAck(stream, streamGroup, ids...)
+	tx.XDel(stream, ids...)
+	_, err = tx.Exec()
+
+	return
+}
+
+func (storage *redisStorage) StreamLen(stream string) (int64, error) {
+	return storage.client.XLen(stream).Result()
+}
+
+func redisMessages(ms []redis.XMessage) (res []StreamMessage) {
+	for _, m := range ms {
+		values := make(map[string]string, len(m.Values))
+
+		for k, v := range m.Values {
+			values[k] = fmt.Sprint(v)
+		}
+
+		res = append(res, StreamMessage{
+			ID:     m.ID,
+			Values: values,
+		})
+	}
+
+	return
+}
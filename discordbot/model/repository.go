@@ -5,47 +5,47 @@ import (
 	"fmt"
 	"math"
 	"strconv"
-	"sync"
 	"time"
-
-	redis "github.com/go-redis/redis/v7"
 )
 
 // Repository provides methods to get and set configuration, enqueue and dequeue tasks
 type Repository struct {
-	Client *redis.Client
-	Groups map[string]bool
-	m      sync.RWMutex
+	Storage Storage
 }
 
 // ConfigSet sets config value for given guild
 func (repo *Repository) ConfigSet(guildID, scope, key, value string) error {
 	fullkey := fmt.Sprintf("%s.%s.%s", guildID, scope, key)
-	cmd := repo.Client.Set(fullkey, value, 0)
 
-	return cmd.Err()
+	return repo.Storage.Set(fullkey, value, 0)
 }
 
 // ConfigGet returns config value for given guild
 func (repo *Repository) ConfigGet(guildID, scope, key string) (s string, err error) {
 	fullkey := fmt.Sprintf("%s.%s.%s", guildID, scope, key)
-	s, err = repo.Client.Get(fullkey).Result()
 
-	if err == redis.Nil {
-		err = nil
-	}
+	return repo.Storage.Get(fullkey)
+}
 
-	return
+func taskKey(task Task) string {
+	return fmt.Sprintf("task.%s.%s", task.Scope(), task.Name())
 }
 
 // TaskEnqueue schedules task for execution
 func (repo *Repository) TaskEnqueue(
 	task Task,
 	delay, timeout time.Duration,
-) (id string, pending []redis.XMessage, err error) {
-	fkey := fmt.Sprintf("task.%s.%s", task.Scope(), task.Name())
+) (id string, pending []StreamMessage, err error) {
+	fkey := taskKey(task)
 
-	pending, err = repo.Client.XRange(fkey, "-", "+").Result()
+	start := time.Now()
+
+	defer func() {
+		metricTaskDuration.Since(start, streamLabel(fkey), "enqueue")
+		metricTaskOperations.Inc(streamLabel(fkey), "enqueue")
+	}()
+
+	pending, err = repo.Storage.StreamRange(fkey, "-", "+")
 	if err != nil {
 		return
 	}
@@ -55,15 +55,23 @@ func (repo *Repository) TaskEnqueue(
 		return
 	}
 
-	id, err = repo.Client.XAdd(&redis.XAddArgs{
-		Stream: fkey,
-		Values: map[string]interface{}{
-			"created": time.Now().Unix(),
-			"delay":   int64(delay),
-			"timeout": int64(timeout),
-			"data":    bs,
-		},
-	}).Result()
+	values := map[string]string{
+		"created": strconv.FormatInt(time.Now().Unix(), 10),
+		"delay":   strconv.FormatInt(int64(delay), 10),
+		"timeout": strconv.FormatInt(int64(timeout), 10),
+		"data":    string(bs),
+	}
+
+	if retryable, ok := task.(RetryableTask); ok {
+		bs, err = json.Marshal(retryable.RetryPolicy())
+		if err != nil {
+			return
+		}
+
+		values["retry"] = string(bs)
+	}
+
+	id, err = repo.Storage.StreamAdd(fkey, values)
 	if err != nil {
 		return
 	}
@@ -73,28 +81,29 @@ func (repo *Repository) TaskEnqueue(
 
 func (repo *Repository) processMessage(
 	fkey string,
-	m *redis.XMessage,
+	m *StreamMessage,
 	task Task,
+	accept func(id string) bool,
 	inwait int64,
 ) (minwait int64, id string, err error) {
 	minwait = inwait
 
 	var created, delay, timeout int64
-	if raw, ok := m.Values["created"].(string); ok {
+	if raw, ok := m.Values["created"]; ok {
 		created, err = strconv.ParseInt(raw, 10, 64)
 		if err != nil {
 			return minwait, "", nil
 		}
 	}
 
-	if raw, ok := m.Values["delay"].(string); ok {
+	if raw, ok := m.Values["delay"]; ok {
 		delay, err = strconv.ParseInt(raw, 10, 64)
 		if err != nil {
 			return minwait, "", nil
 		}
 	}
 
-	if raw, ok := m.Values["timeout"].(string); ok {
+	if raw, ok := m.Values["timeout"]; ok {
 		timeout, err = strconv.ParseInt(raw, 10, 64)
 		if err != nil {
 			return minwait, "", nil
@@ -112,11 +121,7 @@ func (repo *Repository) processMessage(
 
 	passed -= delay
 	if timeout > 0 && passed > timeout {
-		tx := repo.Client.TxPipeline()
-		tx.XAck(fkey, "tasks", m.ID)
-		tx.XDel(fkey, m.ID)
-
-		_, err = tx.Exec()
+		err = repo.deadLetter(fkey, m, "timeout")
 		if err != nil {
 			return 0, "", err
 		}
@@ -124,7 +129,7 @@ func (repo *Repository) processMessage(
 		return minwait, "", nil
 	}
 
-	bs, ok := m.Values["data"].(string)
+	bs, ok := m.Values["data"]
 	if !ok {
 		return minwait, "", nil
 	}
@@ -134,22 +139,29 @@ func (repo *Repository) processMessage(
 		return minwait, "", nil
 	}
 
+	if accept != nil && !accept(m.ID) {
+		return minwait, "", nil
+	}
+
+	metricTaskWait.Observe(float64(passed)/float64(time.Second), streamLabel(fkey))
+
 	return minwait, m.ID, nil
 }
 
 func (repo *Repository) processMessages(
 	fkey string,
-	res []redis.XStream,
+	ms []StreamMessage,
 	task Task,
+	accept func(id string) bool,
 ) (minwait int64, id string, err error) {
 	minwait = int64(math.MaxInt64)
 
-	for _, s := range res {
-		for _, m := range s.Messages {
-			minwait, id, err = repo.processMessage(fkey, &m, task, minwait)
-			if err != nil || id != "" {
-				return
-			}
+	sortQueue(ms)
+
+	for _, m := range ms {
+		minwait, id, err = repo.processMessage(fkey, &m, task, accept, minwait)
+		if err != nil || id != "" {
+			return
 		}
 	}
 
@@ -157,58 +169,52 @@ func (repo *Repository) processMessages(
 }
 
 func (repo *Repository) readMessages(
-	fkey, start string,
+	fkey string,
+	pending bool,
 	task Task,
+	accept func(id string) bool,
 	block time.Duration,
 ) (minwait int64, id string, err error) {
-	res, err := repo.Client.XReadGroup(&redis.XReadGroupArgs{
-		Group:    "tasks",
-		Consumer: "dequeue",
-		Streams:  []string{fkey, start},
-		Block:    block,
-	}).Result()
-	if err == redis.Nil {
-		err = nil
-	}
-
+	ms, err := repo.Storage.StreamRead(fkey, pending, block)
 	if err != nil {
 		return minwait, "", err
 	}
 
-	return repo.processMessages(fkey, res, task)
+	return repo.processMessages(fkey, ms, task, accept)
 }
 
-func (repo *Repository) ensureGroup(fkey string) {
-	repo.m.RLock()
-
-	_, ok := repo.Groups[fkey]
-
-	repo.m.RUnlock()
-
-	if !ok {
-		repo.m.Lock()
-		defer repo.m.Unlock()
-
-		_, ok = repo.Groups[fkey]
-		if ok {
-			return
-		}
+// TaskDequeue retreives next task
+func (repo *Repository) TaskDequeue(task Task, block time.Duration) (id string, err error) {
+	return repo.TaskDequeueFunc(task, block, nil)
+}
 
-		repo.Client.XGroupCreateMkStream(fkey, "tasks", "0")
+// TaskDequeueFunc retreives next task accepted by given function, skipped tasks are kept for later dequeues
+func (repo *Repository) TaskDequeueFunc(
+	task Task,
+	block time.Duration,
+	accept func(id string) bool,
+) (id string, err error) {
+	fkey := taskKey(task)
 
-		repo.Groups[fkey] = true
+	id, err = repo.dequeue(fkey, task, block, accept)
+	if err != nil || id == "" {
+		return
 	}
-}
 
-// TaskDequeue retreives next task
-func (repo *Repository) TaskDequeue(task Task, block time.Duration) (id string, err error) {
-	fkey := fmt.Sprintf("task.%s.%s", task.Scope(), task.Name())
+	metricTaskOperations.Inc(streamLabel(fkey), "dequeue")
 
-	repo.ensureGroup(fkey)
+	return id, repo.markRunning(fkey, id)
+}
 
+func (repo *Repository) dequeue(
+	fkey string,
+	task Task,
+	block time.Duration,
+	accept func(id string) bool,
+) (id string, err error) {
 	var minwait int64
 
-	minwait, id, err = repo.readMessages(fkey, "0", task, block)
+	minwait, id, err = repo.readMessages(fkey, true, task, accept, block)
 	if err != nil || id != "" {
 		return
 	}
@@ -220,7 +226,7 @@ func (repo *Repository) TaskDequeue(task Task, block time.Duration) (id string,
 		pending = true
 	}
 
-	minwait, id, err = repo.readMessages(fkey, ">", task, block)
+	minwait, id, err = repo.readMessages(fkey, false, task, accept, block)
 	if err != nil || id != "" {
 		return
 	}
@@ -232,35 +238,56 @@ func (repo *Repository) TaskDequeue(task Task, block time.Duration) (id string,
 	}
 
 	if pending {
-		_, id, err = repo.readMessages(fkey, "0", task, 0)
+		_, id, err = repo.readMessages(fkey, true, task, accept, 0)
 	}
 
 	return
 }
 
+// markRunning records start of task execution
+func (repo *Repository) markRunning(fkey, id string) error {
+	return repo.Storage.Set(runningKey(fkey, id), strconv.FormatInt(time.Now().Unix(), 10), runningExpire)
+}
+
 // TaskAck confirms task as successfully executed
 func (repo *Repository) TaskAck(task Task, id string) (err error) {
-	fkey := fmt.Sprintf("task.%s.%s", task.Scope(), task.Name())
+	fkey := taskKey(task)
 
-	tx := repo.Client.TxPipeline()
-	tx.XAck(fkey, "tasks", id)
-	tx.XDel(fkey, id)
-	_, err = tx.Exec()
+	start := time.Now()
 
-	return
+	defer func() {
+		metricTaskDuration.Since(start, streamLabel(fkey), "ack")
+		metricTaskOperations.Inc(streamLabel(fkey), "ack")
+	}()
+
+	return repo.ack(fkey, id)
+}
+
+// TaskPending returns all tasks of given type not yet acknowledged, in queue order
+func (repo *Repository) TaskPending(task Task) ([]StreamMessage, error) {
+	fkey := taskKey(task)
+
+	ms, err := repo.Storage.StreamRange(fkey, "-", "+")
+	if err != nil {
+		return nil, err
+	}
+
+	sortQueue(ms)
+
+	return ms, nil
 }
 
 // TaskGet retrieves task by id
 func (repo *Repository) TaskGet(task Task, id string) (err error) {
-	fkey := fmt.Sprintf("task.%s.%s", task.Scope(), task.Name())
+	fkey := taskKey(task)
 
-	ms, err := repo.Client.XRange(fkey, id, id).Result()
+	ms, err := repo.Storage.StreamRange(fkey, id, id)
 	if err != nil {
 		return
 	}
 
 	for _, m := range ms {
-		bs, ok := m.Values["data"].(string)
+		bs, ok := m.Values["data"]
 		if !ok {
 			return nil
 		}
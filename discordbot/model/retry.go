@@ -0,0 +1,228 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrTaskNotFound is returned when task with given id is not present in stream
+	ErrTaskNotFound = errors.New("task not found")
+)
+
+// RetryPolicy describes how failed tasks are retried
+type RetryPolicy struct {
+	MaxAttempts int           `json:"max_attempts"`
+	Backoff     time.Duration `json:"backoff"`
+	MaxBackoff  time.Duration `json:"max_backoff"`
+	Jitter      float64       `json:"jitter"`
+}
+
+// Delay returns exponential backoff with jitter to wait before given attempt, counting from 1
+func (policy *RetryPolicy) Delay(attempt int) time.Duration {
+	delay := policy.Backoff
+
+	for i := 1; i < attempt && (policy.MaxBackoff == 0 || delay < policy.MaxBackoff); i++ {
+		delay *= 2
+	}
+
+	if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+
+	if policy.Jitter > 0 {
+		delay += time.Duration(float64(delay) * policy.Jitter * (rand.Float64()*2 - 1))
+	}
+
+	return delay
+}
+
+// RetryableTask is implemented by tasks having retry policy
+type RetryableTask interface {
+	Task
+	RetryPolicy() *RetryPolicy
+}
+
+func deadKey(key string) string {
+	return "dead." + strings.TrimPrefix(key, "task.")
+}
+
+func (repo *Repository) taskEntry(fkey, id string) (*StreamMessage, error) {
+	ms, err := repo.Storage.StreamRange(fkey, id, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ms) == 0 {
+		return nil, ErrTaskNotFound
+	}
+
+	return &ms[0], nil
+}
+
+// deadLetter moves stream entry to dead-letter stream with given reason
+func (repo *Repository) deadLetter(fkey string, m *StreamMessage, reason string) error {
+	values := make(map[string]string, len(m.Values)+2)
+
+	for k, v := range m.Values {
+		values[k] = v
+	}
+
+	values["error"] = reason
+	values["failed"] = strconv.FormatInt(time.Now().Unix(), 10)
+
+	_, err := repo.Storage.StreamAdd(deadKey(fkey), values)
+	if err != nil {
+		return err
+	}
+
+	metricTaskOperations.Inc(streamLabel(fkey), "dead")
+
+	return repo.ack(fkey, m.ID)
+}
+
+// TaskAttempt returns attempt number of task, counting from 1, and its maximum number of attempts
+func (repo *Repository) TaskAttempt(task Task, id string) (attempt, max int, err error) {
+	m, err := repo.taskEntry(taskKey(task), id)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	attempt, max = entryAttempt(m)
+
+	return
+}
+
+func entryAttempt(m *StreamMessage) (attempt, max int) {
+	attempt, _ = strconv.Atoi(m.Values["attempt"])
+	attempt++
+
+	max = 1
+
+	var policy RetryPolicy
+
+	if json.Unmarshal([]byte(m.Values["retry"]), &policy) == nil && policy.MaxAttempts > 0 {
+		max = policy.MaxAttempts
+	}
+
+	return
+}
+
+// TaskFail marks task execution as failed, requeueing it with backoff delay according to its retry policy,
+// or moving it to dead-letter stream once attempts are exhausted. Returns id of requeued task, if any.
+func (repo *Repository) TaskFail(task Task, id string, cause error) (newid string, err error) {
+	fkey := taskKey(task)
+
+	m, err := repo.taskEntry(fkey, id)
+	if err != nil {
+		return "", err
+	}
+
+	attempt, max := entryAttempt(m)
+
+	var policy RetryPolicy
+
+	if attempt >= max || json.Unmarshal([]byte(m.Values["retry"]), &policy) != nil {
+		return "", repo.deadLetter(fkey, m, cause.Error())
+	}
+
+	values := make(map[string]string, len(m.Values))
+
+	for k, v := range m.Values {
+		values[k] = v
+	}
+
+	values["created"] = strconv.FormatInt(time.Now().Unix(), 10)
+	values["delay"] = strconv.FormatInt(int64(policy.Delay(attempt)), 10)
+	values["attempt"] = strconv.Itoa(attempt)
+	values["error"] = cause.Error()
+
+	newid, err = repo.Storage.StreamAdd(fkey, values)
+	if err != nil {
+		return "", err
+	}
+
+	metricTaskOperations.Inc(streamLabel(fkey), "retry")
+
+	return newid, repo.ack(fkey, id)
+}
+
+// TaskDead moves task to dead-letter stream without further retries
+func (repo *Repository) TaskDead(task Task, id string, cause error) error {
+	fkey := taskKey(task)
+
+	m, err := repo.taskEntry(fkey, id)
+	if err != nil {
+		return err
+	}
+
+	return repo.deadLetter(fkey, m, cause.Error())
+}
+
+// DeadLetterStreams returns names of tasks having dead-lettered entries, as scope.name
+func (repo *Repository) DeadLetterStreams() (names []string, err error) {
+	keys, err := repo.Storage.Keys("dead.*")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range keys {
+		names = append(names, strings.TrimPrefix(k, "dead."))
+	}
+
+	sort.Strings(names)
+
+	return
+}
+
+// DeadLetterList returns dead-lettered entries of task given as scope.name
+func (repo *Repository) DeadLetterList(name string) ([]StreamMessage, error) {
+	return repo.Storage.StreamRange("dead."+name, "-", "+")
+}
+
+// DeadLetterGet returns dead-lettered entry of task given as scope.name
+func (repo *Repository) DeadLetterGet(name, id string) (*StreamMessage, error) {
+	return repo.taskEntry("dead."+name, id)
+}
+
+// DeadLetterRequeue moves dead-lettered entry back to task stream with attempts reset, returning new task id
+func (repo *Repository) DeadLetterRequeue(name, id string) (newid string, err error) {
+	m, err := repo.taskEntry("dead."+name, id)
+	if err != nil {
+		return "", err
+	}
+
+	values := make(map[string]string, len(m.Values))
+
+	for k, v := range m.Values {
+		values[k] = v
+	}
+
+	delete(values, "error")
+	delete(values, "failed")
+
+	values["created"] = strconv.FormatInt(time.Now().Unix(), 10)
+	values["delay"] = "0"
+	values["attempt"] = "0"
+
+	newid, err = repo.Storage.StreamAdd("task."+name, values)
+	if err != nil {
+		return "", err
+	}
+
+	return newid, repo.Storage.StreamAck("dead."+name, id)
+}
+
+// DeadLetterPurge removes given dead-lettered entries, or all entries of task if none given
+func (repo *Repository) DeadLetterPurge(name string, ids ...string) error {
+	if len(ids) == 0 {
+		return repo.Storage.Del("dead." + name)
+	}
+
+	return repo.Storage.StreamAck("dead."+name, ids...)
+}
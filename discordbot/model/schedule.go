@@ -0,0 +1,35 @@
+package model
+
+import (
+	"strconv"
+	"time"
+)
+
+func scheduleKey(name string) string {
+	return "schedule." + name
+}
+
+// ScheduleGet returns persisted next run time of scheduled job, zero time if there is none
+func (repo *Repository) ScheduleGet(name string) (next time.Time, err error) {
+	s, err := repo.Storage.Get(scheduleKey(name))
+	if err != nil || s == "" {
+		return time.Time{}, err
+	}
+
+	ts, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(ts, 0), nil
+}
+
+// ScheduleSet persists next run time of scheduled job
+func (repo *Repository) ScheduleSet(name string, next time.Time) error {
+	return repo.Storage.Set(scheduleKey(name), strconv.FormatInt(next.Unix(), 10), 0)
+}
+
+// ScheduleDel removes persisted next run time of scheduled job
+func (repo *Repository) ScheduleDel(name string) error {
+	return repo.Storage.Del(scheduleKey(name))
+}
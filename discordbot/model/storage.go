@@ -0,0 +1,41 @@
+package model
+
+import (
+	"time"
+)
+
+// StreamMessage is a single stream entry
+type StreamMessage struct {
+	ID     string
+	Values map[string]string
+}
+
+// Storage provides key-value and stream persistence behind Repository
+type Storage interface {
+	// Get returns value by key, empty string is returned for missing keys
+	Get(key string) (string, error)
+	// Set sets value by key, expiring after given duration if it is positive
+	Set(key, value string, expire time.Duration) error
+	// Del removes given keys
+	Del(keys ...string) error
+	// Keys returns keys matching glob pattern
+	Keys(pattern string) ([]string, error)
+	// Exists returns true if key is present
+	Exists(key string) (bool, error)
+	// Incr increments integer value by key, returning new value
+	Incr(key string) (int64, error)
+	// Expire sets key expiration
+	Expire(key string, expire time.Duration) error
+
+	// StreamAdd appends entry to stream, returning its id
+	StreamAdd(stream string, values map[string]string) (string, error)
+	// StreamRange returns stream entries in inclusive id range, "-" and "+" denote stream bounds
+	StreamRange(stream, start, end string) ([]StreamMessage, error)
+	// StreamRead returns entries delivered earlier but not yet acknowledged if pending is set,
+	// otherwise waits up to block duration (forever if zero) for entries not yet delivered
+	StreamRead(stream string, pending bool, block time.Duration) ([]StreamMessage, error)
+	// StreamAck acknowledges and removes entries from stream
+	StreamAck(stream string, ids ...string) error
+	// StreamLen returns number of entries in stream
+	StreamLen(stream string) (int64, error)
+}
@@ -26,7 +26,7 @@ func (mod *module) Initialize(config *bot.Configuration) error {
 	mod.config = config
 	config.Router.AppendMiddleware(mod.middlewareCleanup)
 
-	go mod.start()
+	config.Scheduler.Go("cleanup", mod.start)
 
 	return nil
 }
@@ -2,6 +2,7 @@
 package cleanup
 
 import (
+	"context"
 	"time"
 
 	"github.com/eientei/jaroid/discordbot/model"
@@ -26,6 +27,15 @@ func (Task) Name() string {
 	return "message"
 }
 
+// RetryPolicy returns task retry policy
+func (Task) RetryPolicy() *model.RetryPolicy {
+	return &model.RetryPolicy{
+		MaxAttempts: 5,
+		Backoff:     time.Minute,
+		MaxBackoff:  time.Hour,
+	}
+}
+
 func (mod *module) ackTask(task model.Task, id string, err error) {
 	if err != nil {
 		mod.config.Log.WithError(err).Error("Dequeuing")
@@ -35,18 +45,25 @@ func (mod *module) ackTask(task model.Task, id string, err error) {
 		}
 	}
 
-	if err == nil {
-		err = mod.config.Repository.TaskAck(task, id)
+	if err != nil {
+		_, err = mod.config.Repository.TaskFail(task, id, err)
 		if err != nil {
-			mod.config.Log.WithError(err).Error("Acking task", id)
+			mod.config.Log.WithError(err).Error("Failing task", id)
 		}
+
+		return
+	}
+
+	err = mod.config.Repository.TaskAck(task, id)
+	if err != nil {
+		mod.config.Log.WithError(err).Error("Acking task", id)
 	}
 }
 
-func (mod *module) start() {
+func (mod *module) start(ctx context.Context) {
 	task := &Task{}
 
-	for {
+	for ctx.Err() == nil {
 		id, err := mod.config.Repository.TaskDequeue(task, time.Second)
 		if err != nil {
 			mod.config.Log.WithError(err).Error("Dequeuing")
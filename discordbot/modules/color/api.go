@@ -2,7 +2,6 @@
 package color
 
 import (
-	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -14,8 +13,8 @@ import (
 )
 
 var (
-	// ErrInvalidArgumentNumber is returned on invalid argument number
-	ErrInvalidArgumentNumber = errors.New("invalid argument number, use color.help")
+	lightnessMinValue float64
+	hueMinValue       float64
 )
 
 type server struct {
@@ -38,9 +37,26 @@ func (mod *module) Initialize(config *bot.Configuration) error {
 	mod.config = config
 
 	group := config.Router.Group("color").SetDescription("color roles")
-	group.OnAlias("color.set", "sets colored role", []string{"color"}, true, mod.commandSet)
-	group.On("color.remove", "removes colored role", mod.commandRemove)
-	group.On("color.help", "provides documentation", mod.commandHelp)
+	group.OnAlias("color.set", "sets colored role", []string{"color"}, true, mod.commandSet).SetArguments(
+		&router.Argument{
+			Name:        "lightness",
+			Description: "HSL lightness, 0 to 100",
+			Type:        router.ArgumentInt,
+			Required:    true,
+			MinValue:    &lightnessMinValue,
+			MaxValue:    100,
+		},
+		&router.Argument{
+			Name:        "hue",
+			Description: "HSL hue degree, 0 to 360",
+			Type:        router.ArgumentInt,
+			Required:    true,
+			MinValue:    &hueMinValue,
+			MaxValue:    360,
+		},
+	).SetCommand()
+	group.On("color.remove", "removes colored role", mod.commandRemove).SetCommand()
+	group.On("color.help", "provides documentation", mod.commandHelp).SetCommand()
 
 	return nil
 }
@@ -196,17 +212,7 @@ func (mod *module) commandRemove(ctx *router.Context) error {
 }
 
 func (mod *module) commandSet(ctx *router.Context) error {
-	if len(ctx.Args) < 3 {
-		return ErrInvalidArgumentNumber
-	}
-
-	lightnessRaw := ctx.Args.Get(1)
-	hueRaw := ctx.Args.Get(2)
-
-	lightness, err := strconv.ParseInt(lightnessRaw, 10, 64)
-	if err != nil {
-		return fmt.Errorf("invalid lightness percentage: %w", err)
-	}
+	lightness, hue := ctx.Values.Int("lightness"), ctx.Values.Int("hue")
 
 	lightnessMin, err := mod.config.Repository.ConfigGet(ctx.Message.GuildID, "color", "lightness.min")
 	if err == nil && lightnessMin != "" {
@@ -219,11 +225,6 @@ func (mod *module) commandSet(ctx *router.Context) error {
 		}
 	}
 
-	hue, err := strconv.ParseInt(hueRaw, 10, 64)
-	if err != nil {
-		return fmt.Errorf("invalid hue degree: %w", err)
-	}
-
 	c := colorful.Hsl(float64(hue), 1.0, float64(lightness)/100.0)
 
 	return mod.setcolor(ctx.Session, ctx.Message.GuildID, ctx.Message.Author.ID, c)
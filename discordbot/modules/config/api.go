@@ -2,7 +2,6 @@
 package config
 
 import (
-	"errors"
 	"strconv"
 	"strings"
 
@@ -10,12 +9,24 @@ import (
 	"github.com/eientei/jaroid/discordbot/bot"
 	"github.com/eientei/jaroid/discordbot/modules/auth"
 	"github.com/eientei/jaroid/discordbot/router"
-	redis "github.com/go-redis/redis/v7"
 )
 
 var (
-	// ErrInvalidArgumentNumber is retuned when invalid number of arguments is supplied
-	ErrInvalidArgumentNumber = errors.New("invalid argument number")
+	argumentKey = &router.Argument{
+		Name:        "key",
+		Description: "config key",
+		Required:    true,
+	}
+	argumentTask = &router.Argument{
+		Name:        "task",
+		Description: "task name as scope.name, e.g. nico.download",
+		Required:    true,
+	}
+	argumentID = &router.Argument{
+		Name:        "id",
+		Description: "task id",
+		Required:    true,
+	}
 )
 
 // New provides module instacne
@@ -35,11 +46,54 @@ func (mod *module) Initialize(config *bot.Configuration) error {
 		Permissions: discordgo.PermissionAdministrator,
 	})
 
-	group.On("config.get", "gets config value", mod.configGet)
-	group.On("config.set", "sets config value", mod.configSet)
-	group.On("config.del", "deletes config value", mod.configDel)
-	group.On("config.list", "lists config values", mod.configList)
+	group.On("config.get", "gets config value", mod.configGet).SetArguments(argumentKey)
+	group.On("config.set", "sets config value", mod.configSet).SetArguments(argumentKey, &router.Argument{
+		Name:        "value",
+		Description: "config value",
+		Required:    true,
+		Rest:        true,
+	})
+	group.On("config.del", "deletes config value", mod.configDel).SetArguments(argumentKey)
+	group.On("config.list", "lists config values", mod.configList).SetArguments(&router.Argument{
+		Name:        "mask",
+		Description: "key mask",
+	})
 	group.On("config.tasks", "lists task stats", mod.configTasks)
+	group.On("config.tasks.list", "lists queued tasks", mod.configTasksList).SetArguments(argumentTask)
+	group.On("config.tasks.active", "lists running tasks", mod.configTasksActive).SetArguments(&router.Argument{
+		Name:        "task",
+		Description: "task name as scope.name, e.g. nico.download",
+	})
+	group.On("config.tasks.cancel", "cancels queued task", mod.configTasksCancel).SetArguments(
+		argumentTask,
+		argumentID,
+	)
+	group.On("config.tasks.front", "moves queued task to the front", mod.configTasksFront).SetArguments(
+		argumentTask,
+		argumentID,
+	)
+	group.On("config.dead", "lists dead-lettered tasks", mod.configDead).SetArguments(&router.Argument{
+		Name:        "task",
+		Description: "task name as scope.name, e.g. nico.download",
+	})
+	group.On("config.dead.show", "shows dead-lettered task", mod.configDeadShow).SetArguments(
+		argumentTask,
+		argumentID,
+	)
+	group.On("config.dead.requeue", "requeues dead-lettered task", mod.configDeadRequeue).SetArguments(
+		argumentTask,
+		&router.Argument{
+			Name:        "id",
+			Description: "task id, all tasks if omitted",
+		},
+	)
+	group.On("config.dead.purge", "purges dead-lettered task", mod.configDeadPurge).SetArguments(
+		argumentTask,
+		&router.Argument{
+			Name:        "id",
+			Description: "task id, all tasks if omitted",
+		},
+	)
 
 	return nil
 }
@@ -53,17 +107,9 @@ func (mod *module) Shutdown(*bot.Configuration) {
 }
 
 func (mod *module) configGet(ctx *router.Context) error {
-	if len(ctx.Args) < 2 {
-		return ErrInvalidArgumentNumber
-	}
-
-	key := ctx.Message.GuildID + "." + ctx.Args.Get(1)
-
-	value, err := mod.config.Client.Get(key).Result()
-	if err == redis.Nil {
-		err = nil
-	}
+	key := ctx.Message.GuildID + "." + ctx.Values.String("key")
 
+	value, err := mod.config.Storage.Get(key)
 	if err != nil {
 		return err
 	}
@@ -72,14 +118,15 @@ func (mod *module) configGet(ctx *router.Context) error {
 }
 
 func (mod *module) configSet(ctx *router.Context) error {
-	if len(ctx.Args) < 3 {
-		return ErrInvalidArgumentNumber
-	}
+	key := ctx.Message.GuildID + "." + ctx.Values.String("key")
+	value := ctx.Values.String("value")
 
-	key := ctx.Message.GuildID + "." + ctx.Args.Get(1)
-	value := ctx.Args.Join(2)
+	err := mod.config.ValidateConfig(ctx.Values.String("key"), value)
+	if err != nil {
+		return err
+	}
 
-	err := mod.config.Client.Set(key, value, 0).Err()
+	err = mod.config.Storage.Set(key, value, 0)
 	if err != nil {
 		return err
 	}
@@ -90,13 +137,9 @@ func (mod *module) configSet(ctx *router.Context) error {
 }
 
 func (mod *module) configDel(ctx *router.Context) error {
-	if len(ctx.Args) < 2 {
-		return ErrInvalidArgumentNumber
-	}
-
-	key := ctx.Message.GuildID + "." + ctx.Args.Get(1)
+	key := ctx.Message.GuildID + "." + ctx.Values.String("key")
 
-	err := mod.config.Client.Del(key).Err()
+	err := mod.config.Storage.Del(key)
 	if err != nil {
 		return err
 	}
@@ -109,13 +152,13 @@ func (mod *module) configDel(ctx *router.Context) error {
 func (mod *module) configList(ctx *router.Context) error {
 	prefix := ctx.Message.GuildID + "."
 	key := ctx.Message.GuildID + ".*"
-	mask := ctx.Args.Get(1)
+	mask := ctx.Values.String("mask")
 
 	if mask != "" {
 		key += mask
 	}
 
-	slice, err := mod.config.Client.Keys(key).Result()
+	slice, err := mod.config.Storage.Keys(key)
 	if err != nil {
 		return err
 	}
@@ -153,11 +196,7 @@ func (mod *module) configList(ctx *router.Context) error {
 
 		var v string
 
-		v, err = mod.config.Client.Get(raw).Result()
-		if err == redis.Nil {
-			err = nil
-		}
-
+		v, err = mod.config.Storage.Get(raw)
 		if err != nil {
 			return err
 		}
@@ -172,11 +211,18 @@ func (mod *module) configList(ctx *router.Context) error {
 }
 
 func (mod *module) configTasks(ctx *router.Context) error {
-	slice, err := mod.config.Client.Keys("task.*").Result()
+	slice, err := mod.config.Storage.Keys("task.*")
 	if err != nil {
 		return err
 	}
 
+	dead, err := mod.config.Storage.Keys("dead.*")
+	if err != nil {
+		return err
+	}
+
+	slice = append(slice, dead...)
+
 	maxlen := 0
 
 	for _, s := range slice {
@@ -196,11 +242,7 @@ func (mod *module) configTasks(ctx *router.Context) error {
 
 		var v int64
 
-		v, err = mod.config.Client.XLen(s).Result()
-		if err == redis.Nil {
-			err = nil
-		}
-
+		v, err = mod.config.Storage.StreamLen(s)
 		if err != nil {
 			return err
 		}
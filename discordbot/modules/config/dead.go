@@ -0,0 +1,259 @@
+package config
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eientei/jaroid/discordbot/model"
+	"github.com/eientei/jaroid/discordbot/router"
+)
+
+const (
+	emojiPositive  = "\xE2\x9C\x85"
+	deadErrorLimit = 80
+)
+
+// credentialFields are substrings of task data field names redacted when dead-lettered task is shown
+var credentialFields = []string{"auth", "token", "password", "secret"}
+
+// redactData replaces credential fields of task data JSON, data which is not an object is returned as is
+func redactData(raw string) string {
+	var data map[string]interface{}
+
+	if json.Unmarshal([]byte(raw), &data) != nil {
+		return raw
+	}
+
+	for k := range data {
+		for _, c := range credentialFields {
+			if strings.Contains(strings.ToLower(k), c) {
+				data[k] = "<redacted>"
+			}
+		}
+	}
+
+	bs, err := json.Marshal(data)
+	if err != nil {
+		return raw
+	}
+
+	return string(bs)
+}
+
+// guildDead returns dead-lettered tasks of given type belonging to context guild
+func (mod *module) guildDead(ctx *router.Context, name string) (ms []model.StreamMessage, err error) {
+	all, err := mod.config.Repository.DeadLetterList(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range all {
+		if parseTaskData(&m).GuildID == ctx.Message.GuildID {
+			ms = append(ms, m)
+		}
+	}
+
+	return
+}
+
+// guildDeadTask returns dead-lettered task with given id belonging to context guild
+func (mod *module) guildDeadTask(ctx *router.Context, name, id string) (*model.StreamMessage, error) {
+	m, err := mod.config.Repository.DeadLetterGet(name, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if parseTaskData(m).GuildID != ctx.Message.GuildID {
+		return nil, model.ErrTaskNotFound
+	}
+
+	return m, nil
+}
+
+func formatUnix(raw string) string {
+	ts, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return raw
+	}
+
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
+func (mod *module) configDead(ctx *router.Context) error {
+	name := ctx.Values.String("task")
+	if name == "" {
+		return mod.configDeadStreams(ctx)
+	}
+
+	ms, err := mod.guildDead(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if len(ms) == 0 {
+		return ctx.ReplyEmbed("No dead-lettered tasks")
+	}
+
+	buf := &strings.Builder{}
+
+	buf.WriteString("```\n")
+
+	for _, m := range ms {
+		reason := m.Values["error"]
+		if len(reason) > deadErrorLimit {
+			reason = reason[:deadErrorLimit] + "..."
+		}
+
+		attempt, _ := strconv.Atoi(m.Values["attempt"])
+
+		_, _ = buf.WriteString(m.ID)
+		_, _ = buf.WriteString(" ")
+		_, _ = buf.WriteString(formatUnix(m.Values["failed"]))
+		_, _ = buf.WriteString(" attempts: ")
+		_, _ = buf.WriteString(strconv.Itoa(attempt + 1))
+		_, _ = buf.WriteString(" ")
+		_, _ = buf.WriteString(reason)
+		_, _ = buf.WriteString("\n")
+	}
+
+	buf.WriteString("```")
+
+	return ctx.ReplyEmbed(buf.String())
+}
+
+func (mod *module) configDeadStreams(ctx *router.Context) error {
+	names, err := mod.config.Repository.DeadLetterStreams()
+	if err != nil {
+		return err
+	}
+
+	buf := &strings.Builder{}
+
+	for _, name := range names {
+		var ms []model.StreamMessage
+
+		ms, err = mod.guildDead(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		if len(ms) == 0 {
+			continue
+		}
+
+		_, _ = buf.WriteString(name)
+		_, _ = buf.WriteString(": ")
+		_, _ = buf.WriteString(strconv.Itoa(len(ms)))
+		_, _ = buf.WriteString("\n")
+	}
+
+	if buf.Len() == 0 {
+		return ctx.ReplyEmbed("No dead-lettered tasks")
+	}
+
+	return ctx.ReplyEmbed("```\n" + buf.String() + "```")
+}
+
+func (mod *module) configDeadShow(ctx *router.Context) error {
+	m, err := mod.guildDeadTask(ctx, ctx.Values.String("task"), ctx.Values.String("id"))
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(m.Values))
+
+	for k := range m.Values {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	buf := &strings.Builder{}
+
+	buf.WriteString("```\n")
+
+	for _, k := range keys {
+		v := m.Values[k]
+
+		switch k {
+		case "data":
+			v = redactData(v)
+		case "created", "failed":
+			v = formatUnix(v)
+		case "delay", "timeout":
+			if d, err := strconv.ParseInt(v, 10, 64); err == nil {
+				v = time.Duration(d).String()
+			}
+		}
+
+		_, _ = buf.WriteString(k)
+		_, _ = buf.WriteString(": ")
+		_, _ = buf.WriteString(v)
+		_, _ = buf.WriteString("\n")
+	}
+
+	buf.WriteString("```")
+
+	return ctx.ReplyEmbed(buf.String())
+}
+
+func (mod *module) deadIDs(ctx *router.Context) (name string, ids []string, err error) {
+	name = ctx.Values.String("task")
+
+	if id := ctx.Values.String("id"); id != "" {
+		if _, err = mod.guildDeadTask(ctx, name, id); err != nil {
+			return "", nil, err
+		}
+
+		return name, []string{id}, nil
+	}
+
+	ms, err := mod.guildDead(ctx, name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, m := range ms {
+		ids = append(ids, m.ID)
+	}
+
+	return
+}
+
+func (mod *module) configDeadRequeue(ctx *router.Context) error {
+	name, ids, err := mod.deadIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		_, err = mod.config.Repository.DeadLetterRequeue(name, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return ctx.ReplyEmbed("Requeued " + strconv.Itoa(len(ids)) + " tasks")
+}
+
+func (mod *module) configDeadPurge(ctx *router.Context) error {
+	name, ids, err := mod.deadIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	// purging without ids would remove entries of every guild
+	if len(ids) == 0 {
+		return ctx.React(emojiPositive)
+	}
+
+	err = mod.config.Repository.DeadLetterPurge(name, ids...)
+	if err != nil {
+		return err
+	}
+
+	return ctx.React(emojiPositive)
+}
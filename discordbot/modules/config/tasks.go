@@ -0,0 +1,215 @@
+package config
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eientei/jaroid/discordbot/model"
+	"github.com/eientei/jaroid/discordbot/router"
+)
+
+// taskData contains commonly used task fields
+type taskData struct {
+	GuildID  string `json:"guild_id"`
+	UserID   string `json:"user_id"`
+	VideoURL string `json:"video_url"`
+	Format   string `json:"format"`
+}
+
+func parseTaskData(m *model.StreamMessage) (data taskData) {
+	_ = json.Unmarshal([]byte(m.Values["data"]), &data)
+
+	return
+}
+
+func parseTaskDuration(raw string) time.Duration {
+	d, _ := strconv.ParseInt(raw, 10, 64)
+
+	return time.Duration(d)
+}
+
+func parseTaskAge(raw string) time.Duration {
+	created, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return time.Since(time.Unix(created, 0)).Truncate(time.Second)
+}
+
+// guildTasks returns queued tasks of given type belonging to context guild
+func (mod *module) guildTasks(ctx *router.Context, name string) (ms []model.StreamMessage, err error) {
+	all, err := mod.config.Repository.TaskQueue(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range all {
+		if parseTaskData(&m).GuildID == ctx.Message.GuildID {
+			ms = append(ms, m)
+		}
+	}
+
+	return
+}
+
+// guildTask returns queued task with given id belonging to context guild
+func (mod *module) guildTask(ctx *router.Context, name, id string) (*model.StreamMessage, error) {
+	ms, err := mod.guildTasks(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range ms {
+		if ms[i].ID == id {
+			return &ms[i], nil
+		}
+	}
+
+	return nil, model.ErrTaskNotFound
+}
+
+func writeTask(buf *strings.Builder, m *model.StreamMessage, started time.Time, running bool) {
+	data := parseTaskData(m)
+
+	_, _ = buf.WriteString("`" + m.ID + "`")
+
+	if running {
+		_, _ = buf.WriteString(" running for " + time.Since(started).Truncate(time.Second).String())
+	} else {
+		_, _ = buf.WriteString(" waiting for " + parseTaskAge(m.Values["created"]).String())
+	}
+
+	if delay := parseTaskDuration(m.Values["delay"]); delay > 0 {
+		_, _ = buf.WriteString(", delay " + delay.String())
+	}
+
+	if data.UserID != "" {
+		_, _ = buf.WriteString(", by <@" + data.UserID + ">")
+	}
+
+	if data.Format != "" {
+		_, _ = buf.WriteString(", format " + data.Format)
+	}
+
+	if data.VideoURL != "" {
+		_, _ = buf.WriteString(", <" + data.VideoURL + ">")
+	}
+
+	_, _ = buf.WriteString("\n")
+}
+
+func (mod *module) configTasksList(ctx *router.Context) error {
+	name := ctx.Values.String("task")
+
+	ms, err := mod.guildTasks(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if len(ms) == 0 {
+		return ctx.ReplyEmbed("No queued tasks")
+	}
+
+	running, err := mod.config.Repository.TaskRunning(name)
+	if err != nil {
+		return err
+	}
+
+	buf := &strings.Builder{}
+
+	for _, m := range ms {
+		started, ok := running[m.ID]
+
+		writeTask(buf, &m, started, ok)
+	}
+
+	return ctx.ReplyEmbed(buf.String())
+}
+
+func (mod *module) configTasksActive(ctx *router.Context) error {
+	names := []string{ctx.Values.String("task")}
+
+	if names[0] == "" {
+		keys, err := mod.config.Storage.Keys("task.*")
+		if err != nil {
+			return err
+		}
+
+		names = names[:0]
+
+		for _, k := range keys {
+			names = append(names, strings.TrimPrefix(k, "task."))
+		}
+	}
+
+	buf := &strings.Builder{}
+
+	for _, name := range names {
+		running, err := mod.config.Repository.TaskRunning(name)
+		if err != nil {
+			return err
+		}
+
+		if len(running) == 0 {
+			continue
+		}
+
+		ms, err := mod.guildTasks(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range ms {
+			if started, ok := running[m.ID]; ok {
+				_, _ = buf.WriteString(name + " ")
+
+				writeTask(buf, &m, started, true)
+			}
+		}
+	}
+
+	if buf.Len() == 0 {
+		return ctx.ReplyEmbed("No running tasks")
+	}
+
+	return ctx.ReplyEmbed(buf.String())
+}
+
+func (mod *module) configTasksCancel(ctx *router.Context) error {
+	name, id := ctx.Values.String("task"), ctx.Values.String("id")
+
+	m, err := mod.guildTask(ctx, name, id)
+	if err != nil {
+		return err
+	}
+
+	err = mod.config.Repository.TaskCancel(name, id)
+	if err != nil {
+		return err
+	}
+
+	mod.config.TaskCancelled(name, m)
+
+	return ctx.React(emojiPositive)
+}
+
+func (mod *module) configTasksFront(ctx *router.Context) error {
+	name, id := ctx.Values.String("task"), ctx.Values.String("id")
+
+	m, err := mod.guildTask(ctx, name, id)
+	if err != nil {
+		return err
+	}
+
+	newid, err := mod.config.Repository.TaskFront(name, id)
+	if err != nil {
+		return err
+	}
+
+	mod.config.TaskMoved(name, m, newid)
+
+	return ctx.ReplyEmbed("Moved to front as `" + newid + "`")
+}
@@ -21,7 +21,7 @@ type module struct {
 func (mod *module) Initialize(config *bot.Configuration) error {
 	group := config.Router.Group("help").SetDescription("help & status")
 
-	group.On("help", "prints help", mod.commandHelp)
+	group.On("help", "prints help", mod.commandHelp).SetCommand()
 
 	return nil
 }
@@ -82,6 +82,12 @@ func (mod *module) commandHelp(ctx *router.Context) error {
 			_, _ = buf.WriteString(": ")
 			_, _ = buf.WriteString(v.Description)
 			buf.WriteString("\n")
+
+			if len(v.Arguments) > 0 {
+				_, _ = buf.WriteString(strings.Repeat(" ", maxname+2))
+				_, _ = buf.WriteString(v.Usage())
+				_, _ = buf.WriteString("\n")
+			}
 		}
 	}
 
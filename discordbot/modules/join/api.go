@@ -48,7 +48,7 @@ func (mod *module) Initialize(config *bot.Configuration) error {
 func (mod *module) loadIgnorePatterns(guildID string) (m map[string]*regexp.Regexp) {
 	prefix := guildID + ".join.ignore."
 
-	rs, err := mod.config.Client.Keys(prefix + "*").Result()
+	rs, err := mod.config.Storage.Keys(prefix + "*")
 	if err != nil {
 		mod.config.Log.WithError(err).Error("fetching ignored join patterns")
 	}
@@ -56,7 +56,7 @@ func (mod *module) loadIgnorePatterns(guildID string) (m map[string]*regexp.Rege
 	m = make(map[string]*regexp.Regexp)
 
 	for _, k := range rs {
-		v, err := mod.config.Client.Get(k).Result()
+		v, err := mod.config.Storage.Get(k)
 		if err != nil {
 			mod.config.Log.WithError(err).Errorf("getting key %s", k)
 			continue
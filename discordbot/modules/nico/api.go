@@ -7,13 +7,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/url"
-	"path"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/eientei/jaroid/discordbot/bot"
@@ -21,18 +17,18 @@ import (
 	"github.com/eientei/jaroid/discordbot/router"
 	"github.com/eientei/jaroid/integration/nicovideo"
 	"github.com/eientei/jaroid/mediaservice"
+	"github.com/eientei/jaroid/mediaservice/filestore"
 	"github.com/eientei/jaroid/nicopost"
-	"github.com/go-redis/redis/v7"
-	"github.com/sirupsen/logrus"
+	"github.com/eientei/jaroid/util/httputil/signurl"
 )
 
 var (
 	// ErrNothingFound is returned when no content found
 	ErrNothingFound = errors.New("nothing found")
-	// ErrInvalidArgumentNumber is returned on invalid argument number
-	ErrInvalidArgumentNumber = errors.New("invalid argument number")
 	// ErrInvalidURL is returned when invalid url submitted to download
 	ErrInvalidURL = errors.New("invalid url")
+	// ErrUnknownService is returned when task refers to media service no longer configured
+	ErrUnknownService = errors.New("unknown media service")
 )
 
 // Used emojis
@@ -50,47 +46,230 @@ const (
 	emojiArrowUp  = "\xE2\xAC\x86"
 )
 
+var downloadFormats = []string{"list", "inf", "8m!", "25m!", "50m!", "100m!", "500m!"}
+
+var argumentQuery = &router.Argument{
+	Name:        "query",
+	Description: "search query and filters, see nico.help",
+	Rest:        true,
+}
+
+var argumentFeedName = &router.Argument{
+	Name:        "name",
+	Description: "feed name",
+	Required:    true,
+}
+
+var feedRouteConfig = &auth.RouteConfig{
+	Permissions: discordgo.PermissionAdministrator,
+}
+
 type server struct {
 	pleromaHost string
 	pleromaAuth string
+	workers     int
+	uploadLimit uint64
+	upload      bool
 }
 
 // New provides module instacne
 func New() bot.Module {
 	return &module{
-		servers: make(map[string]*server),
-		m:       &sync.Mutex{},
+		servers:   make(map[string]*server),
+		sm:        &sync.RWMutex{},
+		m:         &sync.Mutex{},
+		downloads: make(map[string]*download),
+		im:        &sync.Mutex{},
+		fm:        &sync.Mutex{},
+		qm:        &sync.Mutex{},
 	}
 }
 
 type module struct {
-	config  *bot.Configuration
-	servers map[string]*server
-	m       *sync.Mutex
-	task    *TaskDownload
-	cancel  context.CancelFunc
+	config    *bot.Configuration
+	servers   map[string]*server
+	sm        *sync.RWMutex
+	m         *sync.Mutex
+	im        *sync.Mutex
+	fm        *sync.Mutex
+	qm        *sync.Mutex
+	usage     *quotaUsage
+	downloads map[string]*download
+	signer    *signurl.Signer
+	files     *filestore.Local
 }
 
 func (mod *module) Initialize(config *bot.Configuration) error {
 	mod.config = config
 
+	if config.Config.Private.Nicovideo.Workers <= 0 {
+		config.Config.Private.Nicovideo.Workers = 1
+	}
+
+	if config.Config.Private.Nicovideo.GuildWorkers <= 0 {
+		config.Config.Private.Nicovideo.GuildWorkers = 1
+	}
+
 	config.Discord.AddHandler(mod.handlerReactionAdd)
+	config.RegisterConfigValidator("nico.template", validateTemplate)
 
-	group := config.Router.Group("nico").SetDescription("nicovideo API")
+	mod.registerFiles()
 
-	group.OnAlias("nico.search", "search for video", []string{"nico"}, true, mod.commandSearch)
-	group.On("nico.list", "search videos list", mod.commandList)
-	group.On("nico.feed", "start nico feed", mod.commandFeed).Set(auth.RouteConfigKey, &auth.RouteConfig{
-		Permissions: discordgo.PermissionAdministrator,
-	})
-	group.On("nico.download", "download video", mod.commandDownload)
-	group.On("nico.help", "prints nico help", mod.commandHelp)
+	group := config.Router.Group("nico").SetDescription("nicovideo API")
 
-	go mod.backgroundFeed()
-	go mod.startDownload()
-	go mod.startList()
-	go mod.startCleanup()
-	go mod.startPleromaPost()
+	group.OnAlias("nico.search", "search for video", []string{"nico"}, true, mod.commandSearch).
+		SetArguments(argumentQuery).
+		SetCommand()
+	group.On("nico.list", "search videos list", mod.commandList).
+		SetArguments(argumentQuery).
+		SetCommand()
+	group.On("nico.feed", "start nico feed", mod.commandFeed).Set(auth.RouteConfigKey, feedRouteConfig).SetArguments(
+		argumentFeedName,
+		&router.Argument{Name: "period", Description: "feed period", Type: router.ArgumentDuration, Required: true},
+		&router.Argument{Name: "channel", Description: "feed channel", Type: router.ArgumentChannel, Required: true},
+		argumentQuery,
+	)
+	group.On("nico.feed.list", "list nico feeds", mod.commandFeedList).Set(auth.RouteConfigKey, feedRouteConfig)
+	group.On("nico.feed.show", "show nico feed", mod.commandFeedShow).Set(auth.RouteConfigKey, feedRouteConfig).
+		SetArguments(argumentFeedName)
+	group.On("nico.feed.edit", "edit nico feed", mod.commandFeedEdit).Set(auth.RouteConfigKey, feedRouteConfig).
+		SetArguments(
+			argumentFeedName,
+			&router.Argument{Name: "period", Description: "feed period", Type: router.ArgumentDuration, Named: true},
+			&router.Argument{Name: "channel", Description: "feed channel", Type: router.ArgumentChannel, Named: true},
+			&router.Argument{Name: "cron", Description: "feed cron schedule, overrides period", Named: true},
+			&router.Argument{Name: "template", Description: "feed embed template, see nico.help", Named: true},
+			argumentQuery,
+		)
+	group.On("nico.feed.filter", "set nico feed post filters", mod.commandFeedFilter).
+		Set(auth.RouteConfigKey, feedRouteConfig).
+		SetArguments(
+			argumentFeedName,
+			&router.Argument{
+				Name:        "views",
+				Description: "minimum views",
+				Type:        router.ArgumentInt,
+				Named:       true,
+				Default:     "0",
+			},
+			&router.Argument{
+				Name:        "mylists",
+				Description: "minimum mylists",
+				Type:        router.ArgumentInt,
+				Named:       true,
+				Default:     "0",
+			},
+			&router.Argument{
+				Name:        "age",
+				Description: "video age counters are checked at",
+				Type:        router.ArgumentDuration,
+				Named:       true,
+				Default:     "0",
+			},
+			&router.Argument{
+				Name:        "length.min",
+				Description: "minimum video length",
+				Type:        router.ArgumentDuration,
+				Named:       true,
+				Default:     "0",
+			},
+			&router.Argument{
+				Name:        "length.max",
+				Description: "maximum video length",
+				Type:        router.ArgumentDuration,
+				Named:       true,
+				Default:     "0",
+			},
+			&router.Argument{Name: "tags", Description: "comma-separated blocked tags", Named: true},
+			&router.Argument{Name: "users", Description: "comma-separated blocked user and ch IDs", Named: true},
+			&router.Argument{
+				Name:        "seen",
+				Description: "seen videos retention",
+				Type:        router.ArgumentDuration,
+				Named:       true,
+				Default:     "0",
+			},
+		)
+	group.On("nico.feed.rising", "set nico feed rising mode", mod.commandFeedRising).
+		Set(auth.RouteConfigKey, feedRouteConfig).
+		SetArguments(
+			argumentFeedName,
+			&router.Argument{
+				Name:        "delay",
+				Description: "candidate recheck delay, 0 disables rising mode",
+				Type:        router.ArgumentDuration,
+				Named:       true,
+				Default:     "0",
+			},
+			&router.Argument{
+				Name:        "views",
+				Description: "views threshold",
+				Type:        router.ArgumentInt,
+				Named:       true,
+				Default:     "0",
+			},
+			&router.Argument{
+				Name:        "mylists",
+				Description: "mylists threshold",
+				Type:        router.ArgumentInt,
+				Named:       true,
+				Default:     "0",
+			},
+			&router.Argument{
+				Name:        "comments",
+				Description: "comments threshold",
+				Type:        router.ArgumentInt,
+				Named:       true,
+				Default:     "0",
+			},
+			&router.Argument{
+				Name:        "views.rate",
+				Description: "views growth per hour",
+				Type:        router.ArgumentInt,
+				Named:       true,
+				Default:     "0",
+			},
+			&router.Argument{
+				Name:        "mylists.rate",
+				Description: "mylists growth per hour",
+				Type:        router.ArgumentInt,
+				Named:       true,
+				Default:     "0",
+			},
+			&router.Argument{
+				Name:        "comments.rate",
+				Description: "comments growth per hour",
+				Type:        router.ArgumentInt,
+				Named:       true,
+				Default:     "0",
+			},
+		)
+	group.On("nico.feed.pause", "pause nico feed", mod.commandFeedPause).Set(auth.RouteConfigKey, feedRouteConfig).
+		SetArguments(argumentFeedName)
+	group.On("nico.feed.resume", "resume nico feed", mod.commandFeedResume).Set(auth.RouteConfigKey, feedRouteConfig).
+		SetArguments(argumentFeedName)
+	group.On("nico.feed.delete", "delete nico feed", mod.commandFeedDelete).Set(auth.RouteConfigKey, feedRouteConfig).
+		SetArguments(argumentFeedName)
+	group.On("nico.feed.run", "preview nico feed", mod.commandFeedRun).Set(auth.RouteConfigKey, feedRouteConfig).
+		SetArguments(argumentFeedName)
+	group.OnAlias("nico.download", "download video", []string{"dl"}, true, mod.commandDownload).
+		SetArguments(
+			&router.Argument{Name: "url", Description: "video URL", Type: router.ArgumentURL, Required: true},
+			&router.Argument{Name: "format", Description: "format code, size[!], inf or list", Autocomplete: true},
+			&router.Argument{Name: "sub", Description: "comment subtitles language", Named: true, Default: "jpn"},
+			&router.Argument{Name: "mux", Description: "embed subtitles into video", Type: router.ArgumentFlag},
+			&router.Argument{Name: "post", Description: "post to fediverse (admin only)", Type: router.ArgumentFlag},
+			&router.Argument{Name: "preview", Description: "preview fediverse post (admin only)", Type: router.ArgumentFlag},
+		).
+		SetCommand().
+		SetAutocomplete(mod.autocompleteDownload)
+	group.On("nico.usage", "shows download directory usage", mod.commandUsage).SetCommand()
+	group.On("nico.help", "prints nico help", mod.commandHelp).SetCommand()
+
+	config.Scheduler.Go("nico.download", mod.startDownload)
+	config.Scheduler.Go("nico.list", mod.startList)
+	config.Scheduler.Go("nico.cleanup", mod.startCleanup)
+	config.Scheduler.Go("nico.pleroma.post", mod.startPleromaPost)
 
 	return nil
 }
@@ -109,183 +288,50 @@ func (mod *module) Configure(config *bot.Configuration, guild *discordgo.Guild)
 
 	s := &server{}
 
-	for _, c := range config.Config.Servers {
-		if c.GuildID == guild.ID {
-			s.pleromaHost = c.Pleroma.Host
-			s.pleromaAuth = c.Pleroma.Auth
+	if workers, _ := config.Repository.ConfigGet(guild.ID, "nico", "workers"); workers != "" {
+		s.workers, err = strconv.Atoi(workers)
+		if err != nil {
+			config.Log.WithError(err).Error("Parsing nico workers", guild.ID)
 		}
 	}
 
-	mod.servers[guild.ID] = s
-}
-
-func (mod *module) Shutdown(*bot.Configuration) {
-
-}
-
-func (mod *module) backgroundServer(ctx context.Context, s, name string) {
-	fd := &feed{}
-
-	t, err := mod.config.Repository.ConfigGet(s, "nico", name)
-	if err != nil {
-		mod.config.Log.WithError(err).Error("Getting nico feed key", s, name)
-		return
-	}
-
-	if t == "" {
-		mod.config.Log.WithError(err).Error("Empty nico feed key", s, name)
-		return
-	}
-
-	err = json.Unmarshal([]byte(t), fd)
-	if err != nil {
-		mod.config.Log.WithError(err).Error("Unmarshaling nico feed key", s, name)
-		return
-	}
-
-	err = mod.executeFeed(ctx, fd)
-	if err != nil {
-		mod.config.Log.WithError(err).Error("Executing nico feed key", s, name)
-		return
+	if upload, _ := config.Repository.ConfigGet(guild.ID, "nico", "upload"); upload != "" {
+		s.upload, err = strconv.ParseBool(upload)
+		if err != nil {
+			config.Log.WithError(err).Error("Parsing nico upload", guild.ID)
+		}
 	}
 
-	bs, err := json.Marshal(fd)
-	if err != nil {
-		mod.config.Log.WithError(err).Error("Marshaling nico feed key", s, name)
-		return
+	if limit, _ := config.Repository.ConfigGet(guild.ID, "nico", "upload.limit"); limit != "" {
+		s.uploadLimit = parseUploadLimit(limit)
 	}
 
-	err = mod.config.Repository.ConfigSet(s, "nico", name, string(bs))
-	if err != nil {
-		mod.config.Log.WithError(err).Error("Setting nico feed key", s, name)
-		return
+	for _, c := range config.Config.Servers {
+		if c.GuildID == guild.ID {
+			s.pleromaHost = c.Pleroma.Host
+			s.pleromaAuth = c.Pleroma.Auth
+		}
 	}
-}
-
-func (mod *module) backgroundFeed() {
-	for {
-		for s := range mod.servers {
-			prefix := s + ".nico."
-
-			rs, err := mod.config.Client.Keys(prefix + "*").Result()
-			if err != nil {
-				continue
-			}
 
-			for _, name := range rs {
-				name = strings.TrimPrefix(name, prefix)
-				mod.backgroundServer(context.Background(), s, name)
-			}
-		}
+	mod.sm.Lock()
+	mod.servers[guild.ID] = s
+	mod.sm.Unlock()
 
-		time.Sleep(time.Minute)
-	}
+	mod.scheduleFeeds(guild.ID)
 }
 
-type feed struct {
-	ChannelID string             `json:"channel_id"`
-	Query     string             `json:"query"`
-	Executed  time.Time          `json:"executed"`
-	Last      time.Time          `json:"last"`
-	Targets   []nicovideo.Field  `json:"targets"`
-	Filters   []nicovideo.Filter `json:"filters"`
-	Period    time.Duration      `json:"period"`
-}
+// server returns configuration of guild, servers are replaced as whole on configure and not modified afterwards
+func (mod *module) server(guildID string) (s *server, ok bool) {
+	mod.sm.RLock()
+	defer mod.sm.RUnlock()
 
-func (mod *module) executeFeedSearch(feed *feed) (s *nicovideo.Search) {
-	s = &nicovideo.Search{}
-
-	s.Query = feed.Query
-	s.Targets = feed.Targets
-	s.Fields = []nicovideo.Field{
-		nicovideo.FieldContentID,
-		nicovideo.FieldTags,
-		nicovideo.FieldStartTime,
-		nicovideo.FieldLengthSeconds,
-		nicovideo.FieldViewCounter,
-		nicovideo.FieldMylistCounter,
-	}
-	s.Filters = feed.Filters
-	s.SortField = nicovideo.FieldStartTime
-	s.SortDirection = nicovideo.SortDesc
-	s.Limit = mod.config.Config.Private.Nicovideo.Limit
-
-	if !feed.Last.IsZero() {
-		s.Filters = append(s.Filters, nicovideo.Filter{
-			Field:    nicovideo.FieldStartTime,
-			Operator: nicovideo.OperatorGTE,
-			Values:   []string{feed.Last.Add(time.Second).Format(time.RFC3339)},
-		})
-	}
+	s, ok = mod.servers[guildID]
 
 	return
 }
 
-func (mod *module) executeFeed(ctx context.Context, feed *feed) error {
-	if time.Since(feed.Executed) < feed.Period {
-		return nil
-	}
-
-	nicobackoff, _ := mod.config.Client.Get("nico_backoff").Result()
-	backoff, _ := time.ParseDuration(nicobackoff)
-
-	nicobacked, _ := mod.config.Client.Get("nico_backed").Result()
-	backed, _ := time.Parse(time.RFC3339, nicobacked)
-
-	if time.Since(backed) < backoff {
-		mod.config.Log.WithFields(logrus.Fields{
-			"backoff": backoff,
-			"until":   backed.Add(backoff),
-			"feed":    *feed,
-		}).Warn("awaiting backoff")
-
-		return nil
-	}
-
-	res, err := mod.config.Nicovideo.Search(ctx, mod.executeFeedSearch(feed))
-	if err != nil {
-		backed = time.Now()
-
-		if backoff == 0 {
-			backoff = mod.config.Config.Private.Nicovideo.Backoff
-		} else {
-			backoff <<= 1
-		}
-
-		mod.config.Log.WithFields(logrus.Fields{
-			"backoff": backoff,
-			"feed":    *feed,
-		}).Error("backing off")
-
-		_ = mod.config.Client.Set("nico_backoff", backoff.String(), 0)
-		_ = mod.config.Client.Set("nico_backed", backed.Format(time.RFC3339), 0)
-
-		return err
-	}
-
-	for i := len(res.Data) - 1; i >= 0; i-- {
-		r := res.Data[i]
-
-		_, err = mod.config.Discord.ChannelMessageSend(feed.ChannelID, mod.singleRender(r))
-		if err != nil {
-			return err
-		}
-
-		feed.Last = r.StartTime
-
-		time.Sleep(time.Second * 30)
-	}
-
-	if feed.Last.IsZero() {
-		feed.Last = time.Now()
-	}
-
-	feed.Executed = time.Now()
-
-	_ = mod.config.Client.Del("nico_backoff")
-	_ = mod.config.Client.Del("nico_backed")
+func (mod *module) Shutdown(*bot.Configuration) {
 
-	return nil
 }
 
 func (mod *module) commandDownload(ctx *router.Context) error {
@@ -293,25 +339,10 @@ func (mod *module) commandDownload(ctx *router.Context) error {
 		return nil
 	}
 
-	if len(ctx.Args) < 2 {
-		return ErrInvalidArgumentNumber
-	}
-
-	urlraw := ctx.Args[1]
+	urlraw := ctx.Values.URL("url").String()
 
-	u, err := url.Parse(urlraw)
+	service, downloader, err := mod.config.Media.Match(urlraw)
 	if err != nil {
-		return err
-	}
-
-	switch u.Hostname() {
-	case "www.nicovideo.jp", "nicovideo.jp":
-		var m bool
-
-		if m, err = regexp.MatchString("^.*/[sn]m[0-9]*$", urlraw); err != nil || !m {
-			return ErrInvalidURL
-		}
-	default:
 		return ErrInvalidURL
 	}
 
@@ -349,33 +380,12 @@ func (mod *module) commandDownload(ctx *router.Context) error {
 			MessageID: msg.ID,
 			VideoURL:  urlraw,
 			UserID:    ctx.Message.Author.ID,
+			Service:   service,
 		}, 0, 0)
 
 		return err
 	}
 
-	apidata, err := mod.config.Nicovideo.QueryFormat(
-		context.Background(),
-		urlraw,
-		format,
-		mediaservice.NewDummyReporter(),
-	)
-	if err != nil {
-		return err
-	}
-
-	data, err := json.Marshal(apidata)
-	if err != nil {
-		return err
-	}
-
-	formats := apidata.ListFormats()
-
-	_, _, idx, _, _, err := mediaservice.SelectFormat(formats, format)
-	if err != nil {
-		return err
-	}
-
 	task := &TaskDownload{
 		GuildID:   ctx.Message.GuildID,
 		ChannelID: msg.ChannelID,
@@ -383,22 +393,32 @@ func (mod *module) commandDownload(ctx *router.Context) error {
 		VideoURL:  urlraw,
 		Format:    format,
 		UserID:    ctx.Message.Author.ID,
-		Subs:      subs,
-		Data:      data,
-		Estimate:  formats[idx].SizeEstimate(),
-		Post:      post,
-		Preview:   preview,
+		Service:   service,
 	}
 
-	basename := path.Base(task.VideoURL)
+	if client, ok := downloader.(*nicovideo.Client); ok {
+		task.Subs, task.Post, task.Preview = subs, post, preview
+		task.Mux = subs != "" && ctx.Values.Flag("mux")
 
-	fileID := nicopost.FormatFileID(basename, format)
+		err = queryNicovideoFormat(client, task)
+		if err != nil {
+			return err
+		}
+	}
 
-	var fpath string
+	err = mod.estimateTask(context.Background(), downloader, task)
+	if err == nil {
+		err = mod.checkQuota(task)
+	}
 
-	if fpath, err = nicopost.GlobFind(mod.config.Config.Private.Nicovideo.Directory, fileID); err == nil &&
-		len(fpath) > 0 && subsExist(task, fpath) {
-		mod.downloadSend(task, fpath)
+	if err != nil {
+		return err
+	}
+
+	fileID := nicopost.FormatFileID(nicopost.MediaID(urlraw), format)
+
+	if fpath := mod.findStored(context.Background(), task, fileID); len(fpath) > 0 {
+		mod.availableSend(task, fpath)
 
 		_ = mod.config.Discord.MessageReactionRemove(msg.ChannelID, msg.ID, emojiStop, "@me")
 
@@ -407,152 +427,99 @@ func (mod *module) commandDownload(ctx *router.Context) error {
 
 	id, q, err := mod.config.Repository.TaskEnqueue(task, 0, 0)
 
-	mod.updateMessage(msg.GuildID, msg.ChannelID, msg.ID, queuedMessage(id, msg.Content, q))
+	mod.updateMessage(msg.GuildID, msg.ChannelID, msg.ID, mod.queuedMessage(id, task, q))
 
 	_ = mod.config.Discord.MessageReactionAdd(msg.ChannelID, msg.ID, emojiStop)
 
 	return err
 }
 
-func queuedMessage(id, content string, gs []redis.XMessage) string {
-	if len(gs) > 0 {
-		msg := fmt.Sprintf("%s queued at position %d", id, len(gs))
-
-		var queue uint64
-
-		for _, g := range gs {
-			var t TaskDownload
-
-			bs, ok := g.Values["data"].(string)
-			if !ok {
-				return msg
-			}
-
-			err := json.Unmarshal([]byte(bs), &t)
-			if err != nil {
-				return msg
-			}
-
-			queue += t.Estimate
-		}
-
-		return fmt.Sprintf(
-			"%s queued at position %d (in front of you: %s)",
-			id,
-			len(gs),
-			mediaservice.HumanSizeFormat(float64(queue)),
-		)
-	}
-
-	return fmt.Sprintf("%s %s", id, content)
-}
-
-func (mod *module) parseNicoDownloadArgs(ctx *router.Context) (format, subs string, post, preview bool) {
-	for i := 2; i < len(ctx.Args); i++ {
-		switch {
-		case (ctx.Args[i] == "post" || ctx.Args[i] == "preview") && mod.config.AuthorHasPermission(
-			ctx.Message,
-			discordgo.PermissionAdministrator,
-			nil,
-			nil,
-		):
-			post = true
-			preview = ctx.Args[i] == "preview"
-		case strings.HasPrefix(ctx.Args[i], "sub"):
-			subs = strings.TrimPrefix(ctx.Args[i], "sub")
-			subs = strings.TrimPrefix(subs, ":")
-
-			if len(subs) == 0 {
-				subs = "jpn"
-			}
-		default:
-			format = strings.TrimSpace(ctx.Args[i])
-		}
-	}
-
-	return
-}
-
-func (mod *module) commandFeed(ctx *router.Context) error {
-	if len(ctx.Args) < 4 {
-		return ErrInvalidArgumentNumber
+// queryNicovideoFormat fills task with nicovideo API data and size estimate of selected format
+func queryNicovideoFormat(client *nicovideo.Client, task *TaskDownload) error {
+	apidata, err := client.QueryFormat(
+		context.Background(),
+		task.VideoURL,
+		task.Format,
+		mediaservice.NewDummyReporter(),
+	)
+	if err != nil {
+		return err
 	}
 
-	name, period, channelID := ctx.Args[1], ctx.Args[2], ctx.Args[3]
-	ctx.Args = ctx.Args[3:]
-
-	channel, err := ctx.Session.Channel(channelID)
+	task.Data, err = json.Marshal(apidata)
 	if err != nil {
 		return err
 	}
 
-	s := mod.parseSearch(ctx.Args, []nicovideo.Field{}, 0, 20)
+	formats := apidata.ListFormats()
 
-	t, err := mod.config.Repository.ConfigGet(ctx.Message.GuildID, "nico", name)
+	_, _, idx, _, _, err := mediaservice.SelectFormat(formats, task.Format)
 	if err != nil {
 		return err
 	}
 
-	fd := &feed{}
+	task.Estimate = formats[idx].SizeEstimate()
 
-	if t != "" {
-		err = json.Unmarshal([]byte(t), fd)
-		if err != nil {
-			return err
-		}
-	}
-
-	fd.ChannelID = channel.ID
-	fd.Targets = s.Targets
-	fd.Query = s.Query
-	fd.Filters = s.Filters
+	return nil
+}
 
-	fd.Period, err = time.ParseDuration(period)
-	if err != nil {
-		return err
+func (mod *module) autocompleteDownload(
+	_ *router.Context,
+	option *discordgo.ApplicationCommandInteractionDataOption,
+) (choices []*discordgo.ApplicationCommandOptionChoice) {
+	if option.Name != "format" {
+		return nil
 	}
 
-	err = mod.executeFeed(context.Background(), fd)
-	if err != nil {
-		return err
+	value := strings.TrimSpace(option.StringValue())
+
+	if value != "" {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  value,
+			Value: value,
+		})
 	}
 
-	bs, err := json.Marshal(fd)
-	if err != nil {
-		return err
+	for _, f := range downloadFormats {
+		if f != value && strings.HasPrefix(f, value) {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+				Name:  f,
+				Value: f,
+			})
+		}
 	}
 
-	return mod.config.Repository.ConfigSet(ctx.Message.GuildID, "nico", name, string(bs))
+	return
 }
 
-func (mod *module) renderSelection(session *discordgo.Session, msg *discordgo.Message, lines []string, n int) {
-	idx := strings.Index(lines[n], "https://www.nicovideo.jp/watch/")
-	if idx < 0 {
-		return
-	}
+func (mod *module) parseNicoDownloadArgs(ctx *router.Context) (format, subs string, post, preview bool) {
+	format = strings.TrimSpace(ctx.Values.String("format"))
+	subs = ctx.Values.String("sub")
 
-	firstparts := strings.SplitN(lines[n][idx:], " ", 3)
-	if len(firstparts) != 3 {
-		return
+	if (ctx.Values.Flag("post") || ctx.Values.Flag("preview")) && mod.config.AuthorHasPermission(
+		ctx.Message,
+		discordgo.PermissionAdministrator,
+		nil,
+		nil,
+	) {
+		post = true
+		preview = ctx.Values.Flag("preview")
 	}
 
-	posted := lines[n+1]
+	return
+}
 
-	postedidx := strings.Index(posted, " ")
-	if postedidx >= 0 {
-		posted = posted[:postedidx]
+// renderSelection leaves only selected video of list message
+func (mod *module) renderSelection(session *discordgo.Session, msg *discordgo.Message, n int) {
+	if n >= len(msg.Embeds) {
+		return
 	}
 
-	tags := lines[n+2]
+	edit := discordgo.NewMessageEdit(msg.ChannelID, msg.ID).
+		SetContent("").
+		SetEmbeds([]*discordgo.MessageEmbed{msg.Embeds[n]})
 
-	sb := &strings.Builder{}
-	_, _ = sb.WriteString(strings.TrimSuffix(firstparts[0], ">") + "\n")
-	_, _ = sb.WriteString("posted: " + posted + "\n")
-	_, _ = sb.WriteString("length: " + firstparts[1] + "\n")
-	_, _ = sb.WriteString("tags: " + tags + "\n")
-	_, _ = sb.WriteString(firstparts[2])
-
-	_, err := session.ChannelMessageEdit(msg.ChannelID, msg.ID, sb.String())
+	_, err := session.ChannelMessageEditComplex(edit)
 	if err != nil {
 		mod.config.Log.WithError(err).Error("Editing message", msg.ChannelID, msg.ID)
 		return
@@ -598,25 +565,16 @@ func (mod *module) handleStopDownload(
 		return
 	}
 
-	var cancel context.CancelFunc
-
-	task = TaskDownload{}
-
-	mod.m.Lock()
-	if mod.task != nil {
-		task = *mod.task
-		cancel = mod.cancel
-	}
-	mod.m.Unlock()
-
-	if cancel != nil && task.MessageID == msg.ID {
-		cancel()
+	if mod.cancelDownload(parts[0]) {
+		return
 	}
 
 	_ = mod.config.Repository.TaskAck(task, parts[0])
 	_ = mod.config.Discord.MessageReactionRemove(msg.ChannelID, msg.ID, emojiStop, "@me")
 
 	mod.updateMessage(msg.GuildID, msg.ChannelID, msg.ID, "Cancelled")
+
+	mod.refreshQueue()
 }
 
 func (mod *module) handlerReactionAddDownload(
@@ -683,7 +641,7 @@ func (mod *module) handlerReactionAddDownload(
 		return
 	}
 
-	id, q, _ := mod.config.Repository.TaskEnqueue(&TaskDownload{
+	task := &TaskDownload{
 		GuildID:   msg.GuildID,
 		ChannelID: msg.ChannelID,
 		MessageID: msg.ID,
@@ -695,9 +653,17 @@ func (mod *module) handlerReactionAddDownload(
 		Estimate:  formats[idx].SizeEstimate(),
 		Post:      false,
 		Preview:   false,
-	}, 0, 0)
+	}
 
-	mod.updateMessage(msg.GuildID, msg.ChannelID, msg.ID, queuedMessage(id, msg.Content, q))
+	if err = mod.checkQuota(task); err != nil {
+		mod.updateMessage(msg.GuildID, msg.ChannelID, msg.ID, err.Error())
+
+		return
+	}
+
+	id, q, _ := mod.config.Repository.TaskEnqueue(task, 0, 0)
+
+	mod.updateMessage(msg.GuildID, msg.ChannelID, msg.ID, mod.queuedMessage(id, task, q))
 
 	_ = mod.config.Discord.MessageReactionAdd(msg.ChannelID, msg.ID, emojiStop)
 }
@@ -742,16 +708,9 @@ func (mod *module) handlerReactionAdd(session *discordgo.Session, messageReactio
 		return
 	}
 
-	lines := strings.Split(content[idx+1:], "\n")
-
 	switch messageReactionAdd.Emoji.Name {
 	case emojiOne, emojiTwo, emojiThree, emojiFour, emojiFive:
-		n := parseNumber(messageReactionAdd.Emoji.Name) * 3
-		if n+2 >= len(lines) {
-			return
-		}
-
-		mod.renderSelection(session, msg, lines, n)
+		mod.renderSelection(session, msg, parseNumber(messageReactionAdd.Emoji.Name))
 
 		return
 	case emojiForward:
@@ -764,40 +723,23 @@ func (mod *module) handlerReactionAdd(session *discordgo.Session, messageReactio
 		s.Offset -= 5
 	}
 
-	content, _, err = mod.listRender(context.Background(), messageReactionAdd.UserID, s)
+	content, embeds, _, err := mod.listRender(context.Background(), msg.GuildID, messageReactionAdd.UserID, s)
 	if err != nil {
 		mod.config.Log.WithError(err).Error("Rendering list", messageReactionAdd.ChannelID, messageReactionAdd.MessageID)
 		return
 	}
 
-	_, err = session.ChannelMessageEdit(msg.ChannelID, msg.ID, content)
+	_, err = session.ChannelMessageEditComplex(
+		discordgo.NewMessageEdit(msg.ChannelID, msg.ID).SetContent(content).SetEmbeds(embeds),
+	)
 	if err != nil {
 		mod.config.Log.WithError(err).Error("Editing message", messageReactionAdd.ChannelID, messageReactionAdd.MessageID)
 		return
 	}
 }
 
-func (mod *module) singleRender(res *nicovideo.Item) string {
-	sb := &strings.Builder{}
-	_, _ = sb.WriteString("https://www.nicovideo.jp/watch/" + res.ContentID)
-	_, _ = sb.WriteString("\nposted: " + res.ItemRaw.StartTime)
-	_, _ = sb.WriteString("\nlength: " + formatLength(res.LengthSeconds))
-	_, _ = sb.WriteString("\ntags: " + formatTags(res.Tags))
-	_, _ = sb.WriteString("\nviews: " + strconv.FormatInt(int64(res.ViewCounter), 10))
-	_, _ = sb.WriteString(" mylists: " + strconv.FormatInt(int64(res.MylistCounter), 10))
-
-	return sb.String()
-}
-
 func (mod *module) commandSearch(ctx *router.Context) error {
-	res, err := mod.config.Nicovideo.Search(context.Background(), mod.parseSearch(ctx.Args, []nicovideo.Field{
-		nicovideo.FieldContentID,
-		nicovideo.FieldTags,
-		nicovideo.FieldStartTime,
-		nicovideo.FieldLengthSeconds,
-		nicovideo.FieldViewCounter,
-		nicovideo.FieldMylistCounter,
-	}, 0, 1))
+	res, err := mod.config.Nicovideo.Search(context.Background(), mod.parseSearch(ctx.Args, renderFields, 0, 1))
 	if err != nil {
 		return err
 	}
@@ -806,41 +748,45 @@ func (mod *module) commandSearch(ctx *router.Context) error {
 		return ErrNothingFound
 	}
 
-	_, err = ctx.Reply(mod.singleRender(res.Data[0]))
+	embed, err := mod.renderEmbed(mod.renderTemplate(ctx.Message.GuildID, ""), res.Data[0])
+	if err != nil {
+		return err
+	}
 
-	return err
+	return ctx.ReplyEmbedCustom(embed)
 }
 
-func (mod *module) listRender(ctx context.Context, authorID string, search *nicovideo.Search) (
+func (mod *module) listRender(ctx context.Context, guildID, authorID string, search *nicovideo.Search) (
 	content string,
+	embeds []*discordgo.MessageEmbed,
 	res *nicovideo.Result,
 	err error,
 ) {
 	res, err = mod.config.Nicovideo.Search(ctx, search)
 	if err != nil {
-		return "", nil, err
+		return "", nil, nil, err
 	}
 
 	if len(res.Data) == 0 {
-		return "", nil, ErrNothingFound
+		return "", nil, nil, ErrNothingFound
 	}
 
 	bs, err := json.Marshal(search)
 	if err != nil {
-		return "", nil, err
+		return "", nil, nil, err
 	}
 
-	query := base64.StdEncoding.EncodeToString(bs)
+	t := mod.renderTemplate(guildID, "")
 
-	sb := &strings.Builder{}
-	_, _ = sb.WriteString("nico:" + authorID + ":" + query + "\n")
+	for _, v := range res.Data {
+		var embed *discordgo.MessageEmbed
 
-	for i, v := range res.Data {
-		_, _ = sb.WriteString(formatNumber(i) + " <https://www.nicovideo.jp/watch/" + v.ContentID + "> ")
-		_, _ = sb.WriteString(formatLength(v.LengthSeconds) + " views " + strconv.FormatInt(int64(v.ViewCounter), 10))
-		_, _ = sb.WriteString(" mylists " + strconv.FormatInt(int64(v.MylistCounter), 10) + "\n")
-		_, _ = sb.WriteString(v.ItemRaw.StartTime + " " + v.Title + "\n")
-		_, _ = sb.WriteString(formatTags(v.Tags) + "\n")
+		embed, err = mod.renderEmbed(t, v)
+		if err != nil {
+			return "", nil, nil, err
+		}
+
+		embeds = append(embeds, embed)
 	}
 
 	pages := res.Meta.TotalCount / 5
@@ -850,30 +796,22 @@ func (mod *module) listRender(ctx context.Context, authorID string, search *nico
 
 	page := search.Offset/5 + 1
 
-	_, _ = sb.WriteString("---\n")
+	sb := &strings.Builder{}
+	_, _ = sb.WriteString("nico:" + authorID + ":" + base64.StdEncoding.EncodeToString(bs) + "\n")
 	_, _ = sb.WriteString(fmt.Sprintf("Page %d of %d (%d results)", page, pages, res.Meta.TotalCount))
 
-	return sb.String(), res, nil
+	return sb.String(), embeds, res, nil
 }
 
 func (mod *module) commandList(ctx *router.Context) error {
-	s := mod.parseSearch(ctx.Args, []nicovideo.Field{
-		nicovideo.FieldContentID,
-		nicovideo.FieldTitle,
-		nicovideo.FieldDescription,
-		nicovideo.FieldTags,
-		nicovideo.FieldStartTime,
-		nicovideo.FieldLengthSeconds,
-		nicovideo.FieldViewCounter,
-		nicovideo.FieldMylistCounter,
-	}, 0, 5)
-
-	content, res, err := mod.listRender(context.Background(), ctx.Message.Author.ID, s)
+	s := mod.parseSearch(ctx.Args, renderFields, 0, 5)
+
+	content, embeds, res, err := mod.listRender(context.Background(), ctx.Message.GuildID, ctx.Message.Author.ID, s)
 	if err != nil {
 		return err
 	}
 
-	msg, err := ctx.Reply(content)
+	msg, err := ctx.ReplyEmbeds(content, embeds)
 	if err != nil {
 		return err
 	}
@@ -909,6 +847,8 @@ const nicoCommandHelp = yaml + `
 
 Download a video from niconico, in given format
 (if specified), or list available formats.
+Videos from other configured sites are downloaded
+with yt-dlp, dl is a shorter alias.
 
 example:
 # download video with default format
@@ -934,6 +874,20 @@ example:
 example:
 # download video with maximum est.size
 > nico.download https://www.nicovideo.jp/watch/sm00 inf
+
+example:
+# download video with comments rendered as ASS subtitles,
+# in japanese (sub) or other thread language (sub:eng)
+> nico.download https://www.nicovideo.jp/watch/sm00 50M sub
+
+example:
+# same, with subtitles also embedded into video file
+> nico.download https://www.nicovideo.jp/watch/sm00 50M sub mux
+
+>>> nico.usage
+
+Show download directory usage and quota, least recently
+requested videos are deleted first when quota is exceeded.
 ` + backticks
 
 const nicoFilterHelp = yaml + `
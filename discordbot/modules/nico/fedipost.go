@@ -2,7 +2,6 @@ package nico
 
 import (
 	"context"
-	"path/filepath"
 	"time"
 
 	"github.com/eientei/jaroid/fedipost"
@@ -12,7 +11,7 @@ import (
 )
 
 func (mod *module) pleromaPostEnqueue(task *TaskDownload, fpath string) {
-	s, ok := mod.servers[task.GuildID]
+	s, ok := mod.server(task.GuildID)
 
 	if !ok || s.pleromaAuth == "" || s.pleromaHost == "" {
 		return
@@ -33,10 +32,10 @@ func (mod *module) pleromaPostEnqueue(task *TaskDownload, fpath string) {
 	}
 }
 
-func (mod *module) startPleromaPost() {
+func (mod *module) startPleromaPost(ctx context.Context) {
 	task := &TaskPleromaPost{}
 
-	for {
+	for ctx.Err() == nil {
 		id, err := mod.config.Repository.TaskDequeue(task, time.Second)
 		if err != nil {
 			mod.config.Log.WithError(err).Error("Dequeuing")
@@ -46,16 +45,20 @@ func (mod *module) startPleromaPost() {
 			continue
 		}
 
-		mod.ackTask(task, id, err)
-
-		err = mod.pleromaPost(context.Background(), task)
-
+		err = mod.pleromaPost(ctx, task)
 		if err != nil {
 			mod.config.Log.WithError(err).Error("Posting pleroma status")
-			_ = mod.config.Discord.MessageReactionAdd(task.ChannelID, task.MessageID, emojiNegative)
-		} else {
-			_ = mod.config.Discord.MessageReactionAdd(task.ChannelID, task.MessageID, emojiArrowUp)
+
+			if mod.failTask(task, id, err) == "" {
+				_ = mod.config.Discord.MessageReactionAdd(task.ChannelID, task.MessageID, emojiNegative)
+			}
+
+			continue
 		}
+
+		mod.ackTask(task, id, nil)
+
+		_ = mod.config.Discord.MessageReactionAdd(task.ChannelID, task.MessageID, emojiArrowUp)
 	}
 }
 
@@ -90,8 +93,7 @@ func (mod *module) pleromaPost(ctx context.Context, task *TaskPleromaPost) error
 	}
 
 	if task.Preview {
-		base := filepath.Base(task.FilePath)
-		uri := mod.config.Config.Private.Nicovideo.Public + "/" + base
+		uri := mod.publicURL(task.FilePath)
 
 		mod.updateMessage(task.GuildID, task.ChannelID, task.MessageID, backticks+status.Status+backticks+"\n"+uri)
 	} else {
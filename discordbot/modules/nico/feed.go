@@ -0,0 +1,820 @@
+package nico
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/eientei/jaroid/discordbot/bot"
+	"github.com/eientei/jaroid/discordbot/router"
+	"github.com/eientei/jaroid/integration/nicovideo"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrFeedNotFound is returned when there is no feed with given name
+	ErrFeedNotFound = errors.New("feed not found")
+	// ErrFeedName is returned when feed name is taken by nico config value
+	ErrFeedName = errors.New("feed name is used by nico config")
+	// ErrFeedBackoff is returned when feed search is skipped awaiting search API backoff
+	ErrFeedBackoff = errors.New("awaiting search backoff")
+	// ErrFeedNoChanges is returned when feed edit does not change anything
+	ErrFeedNoChanges = errors.New("nothing to change, specify period, cron, channel, template or query")
+)
+
+const (
+	// feedPreviewLimit is maximum length of feed dry-run preview
+	feedPreviewLimit = 4000
+
+	// maxFeedJitter is maximum random delay of feed runs
+	maxFeedJitter = time.Minute
+
+	// feedPostInterval is delay between posting feed items
+	feedPostInterval = time.Second * 30
+)
+
+// reservedFeedNames are nico config keys feeds share key space with
+var reservedFeedNames = map[string]bool{
+	"prefix":       true,
+	"workers":      true,
+	"upload":       true,
+	"upload.limit": true,
+	"channels":     true,
+	"template":     true,
+}
+
+type feed struct {
+	ChannelID  string             `json:"channel_id"`
+	Query      string             `json:"query"`
+	Template   string             `json:"template,omitempty"`
+	Cron       string             `json:"cron,omitempty"`
+	Error      string             `json:"error,omitempty"`
+	Executed   time.Time          `json:"executed"`
+	Last       time.Time          `json:"last"`
+	Targets    []nicovideo.Field  `json:"targets"`
+	Filters    []nicovideo.Filter `json:"filters"`
+	PostFilter feedFilter         `json:"post_filter"`
+	Period     time.Duration      `json:"period"`
+	Rising     feedRising         `json:"rising"`
+	SeenTTL    time.Duration      `json:"seen_ttl,omitempty"`
+	Paused     bool               `json:"paused,omitempty"`
+	Tracked    bool               `json:"tracked,omitempty"`
+}
+
+// parseFeed decodes stored feed, returns nil for other nico config values
+func parseFeed(s string) *feed {
+	fd := &feed{}
+
+	if json.Unmarshal([]byte(s), fd) != nil || fd.ChannelID == "" {
+		return nil
+	}
+
+	return fd
+}
+
+// loadFeed returns stored feed of guild
+func (mod *module) loadFeed(guildID, name string) (*feed, error) {
+	s, err := mod.config.Repository.ConfigGet(guildID, "nico", name)
+	if err != nil {
+		return nil, err
+	}
+
+	fd := parseFeed(s)
+	if fd == nil {
+		return nil, fmt.Errorf("%w: %s", ErrFeedNotFound, name)
+	}
+
+	return fd, nil
+}
+
+// saveFeed stores feed of guild
+func (mod *module) saveFeed(guildID, name string, fd *feed) error {
+	bs, err := json.Marshal(fd)
+	if err != nil {
+		return err
+	}
+
+	return mod.config.Repository.ConfigSet(guildID, "nico", name, string(bs))
+}
+
+// updateFeed applies changes to stored feed of guild, serialized with other feed updates, feed is not stored if
+// update returns error
+func (mod *module) updateFeed(guildID, name string, update func(fd *feed) error) (*feed, error) {
+	mod.fm.Lock()
+	defer mod.fm.Unlock()
+
+	fd, err := mod.loadFeed(guildID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = update(fd); err != nil {
+		return nil, err
+	}
+
+	return fd, mod.saveFeed(guildID, name, fd)
+}
+
+// feedNames returns sorted names of guild feeds
+func (mod *module) feedNames(guildID string) (names []string, err error) {
+	prefix := guildID + ".nico."
+
+	keys, err := mod.config.Storage.Keys(prefix + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if s, _ := mod.config.Storage.Get(key); parseFeed(s) != nil {
+			names = append(names, strings.TrimPrefix(key, prefix))
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// feedJob returns scheduler job name of guild feed
+func feedJob(guildID, name string) string {
+	return "nico.feed." + guildID + "." + name
+}
+
+// feedSchedule returns run schedule of feed, cron expression takes precedence over period
+func feedSchedule(fd *feed) (bot.Schedule, error) {
+	if fd.Cron != "" {
+		return bot.Cron(fd.Cron)
+	}
+
+	return bot.Every(fd.Period)
+}
+
+// feedJitter returns run time jitter of feed, spreading feeds with the same period
+func feedJitter(fd *feed) time.Duration {
+	if fd.Cron == "" && fd.Period/10 < maxFeedJitter {
+		return fd.Period / 10
+	}
+
+	return maxFeedJitter
+}
+
+// scheduleFeed adds job running the feed, replacing existing one, paused feeds are unscheduled
+func (mod *module) scheduleFeed(guildID, name string, fd *feed) error {
+	if fd.Paused {
+		mod.config.Scheduler.Remove(feedJob(guildID, name))
+
+		return nil
+	}
+
+	schedule, err := feedSchedule(fd)
+	if err != nil {
+		return err
+	}
+
+	job := &bot.Job{
+		Name:     feedJob(guildID, name),
+		Schedule: schedule,
+		Jitter:   feedJitter(fd),
+		Run: func(ctx context.Context) error {
+			return mod.runFeed(ctx, guildID, name)
+		},
+	}
+
+	if fd.Cron == "" && !fd.Executed.IsZero() {
+		job.First = fd.Executed.Add(fd.Period)
+	}
+
+	return mod.config.Scheduler.Add(job)
+}
+
+// rescheduleFeed schedules feed discarding its persisted next run time, after feed schedule was changed
+func (mod *module) rescheduleFeed(guildID, name string, fd *feed) error {
+	mod.config.Scheduler.Remove(feedJob(guildID, name))
+
+	return mod.scheduleFeed(guildID, name, fd)
+}
+
+// scheduleFeeds schedules all feeds of guild
+func (mod *module) scheduleFeeds(guildID string) {
+	names, err := mod.feedNames(guildID)
+	if err != nil {
+		mod.config.Log.WithError(err).Error("Listing nico feeds", guildID)
+
+		return
+	}
+
+	for _, name := range names {
+		fd, ferr := mod.loadFeed(guildID, name)
+		if ferr == nil {
+			ferr = mod.scheduleFeed(guildID, name, fd)
+		}
+
+		if ferr != nil {
+			mod.config.Log.WithError(ferr).Error("Scheduling nico feed", guildID, name)
+		}
+	}
+}
+
+// runFeed executes feed, storing its progress and error
+func (mod *module) runFeed(ctx context.Context, guildID, name string) error {
+	fd, err := mod.loadFeed(guildID, name)
+	if err != nil || fd.Paused {
+		return err
+	}
+
+	executed := fd.Executed
+
+	execErr := mod.executeFeed(ctx, guildID, name, fd)
+	if execErr == nil && fd.Executed.Equal(executed) {
+		return nil
+	}
+
+	// only progress is stored, feed could have been edited while its items were posted
+	_, err = mod.updateFeed(guildID, name, func(stored *feed) error {
+		stored.Executed, stored.Last, stored.Tracked, stored.Error = fd.Executed, fd.Last, fd.Tracked, ""
+
+		if execErr != nil {
+			stored.Error = execErr.Error()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return execErr
+}
+
+func (mod *module) executeFeedSearch(feed *feed) (s *nicovideo.Search) {
+	s = &nicovideo.Search{}
+
+	s.Query = feed.Query
+	s.Targets = feed.Targets
+	s.Fields = renderFields
+	s.Filters = feed.Filters
+	s.SortField = nicovideo.FieldStartTime
+	s.SortDirection = nicovideo.SortDesc
+	s.Limit = mod.config.Config.Private.Nicovideo.Limit
+
+	if !feed.Last.IsZero() {
+		s.Filters = append(s.Filters, nicovideo.Filter{
+			Field:    nicovideo.FieldStartTime,
+			Operator: nicovideo.OperatorGTE,
+			Values:   []string{feed.Last.Add(-feedSearchOverlap).Format(time.RFC3339)},
+		})
+	}
+
+	// videos are posted once they are old enough to be checked against counter filters
+	if feed.PostFilter.Age > 0 {
+		s.Filters = append(s.Filters, nicovideo.Filter{
+			Field:    nicovideo.FieldStartTime,
+			Operator: nicovideo.OperatorLTE,
+			Values:   []string{time.Now().Add(-feed.PostFilter.Age).Format(time.RFC3339)},
+		})
+	}
+
+	return
+}
+
+// searchFeed searches for new feed items, backing off all feeds on search API errors
+func (mod *module) searchFeed(ctx context.Context, feed *feed) (*nicovideo.Result, error) {
+	return mod.searchBackoff(ctx, feed, mod.executeFeedSearch(feed))
+}
+
+// feedSearchFunc performs feed search
+type feedSearchFunc func(ctx context.Context, s *nicovideo.Search) (*nicovideo.Result, error)
+
+// searchBackoff performs feed search, backing off all feeds on search API errors
+func (mod *module) searchBackoff(ctx context.Context, feed *feed, s *nicovideo.Search) (*nicovideo.Result, error) {
+	nicobackoff, _ := mod.config.Storage.Get("nico_backoff")
+	backoff, _ := time.ParseDuration(nicobackoff)
+
+	nicobacked, _ := mod.config.Storage.Get("nico_backed")
+	backed, _ := time.Parse(time.RFC3339, nicobacked)
+
+	if time.Since(backed) < backoff {
+		mod.config.Log.WithFields(logrus.Fields{
+			"backoff": backoff,
+			"until":   backed.Add(backoff),
+			"feed":    *feed,
+		}).Warn("awaiting backoff")
+
+		metricFeedBackoffSkips.Inc()
+
+		return nil, fmt.Errorf("%w until %s", ErrFeedBackoff, backed.Add(backoff).UTC().Format(time.RFC3339))
+	}
+
+	start := time.Now()
+
+	res, err := mod.config.Nicovideo.Search(ctx, s)
+
+	metricFeedSearchDuration.Since(start)
+
+	if err != nil {
+		metricFeedSearches.Inc("error")
+
+		backed = time.Now()
+
+		if backoff == 0 {
+			backoff = mod.config.Config.Private.Nicovideo.Backoff
+		} else {
+			backoff <<= 1
+		}
+
+		mod.config.Log.WithFields(logrus.Fields{
+			"backoff": backoff,
+			"feed":    *feed,
+		}).Error("backing off")
+
+		_ = mod.config.Storage.Set("nico_backoff", backoff.String(), 0)
+		_ = mod.config.Storage.Set("nico_backed", backed.Format(time.RFC3339), 0)
+
+		metricFeedBackoff.Set(backoff.Seconds())
+
+		return nil, err
+	}
+
+	metricFeedSearches.Inc("ok")
+
+	_ = mod.config.Storage.Del("nico_backoff", "nico_backed")
+
+	metricFeedBackoff.Set(0)
+
+	return res, nil
+}
+
+// feedSearcher returns search of feed backing off all feeds on search API errors
+func (mod *module) feedSearcher(feed *feed) feedSearchFunc {
+	return func(ctx context.Context, s *nicovideo.Search) (*nicovideo.Result, error) {
+		return mod.searchBackoff(ctx, feed, s)
+	}
+}
+
+func (mod *module) executeFeed(ctx context.Context, guildID, name string, feed *feed) error {
+	res, err := mod.searchFeed(ctx, feed)
+
+	switch {
+	case errors.Is(err, ErrFeedBackoff):
+		return nil
+	case err != nil:
+		return err
+	}
+
+	t := mod.renderTemplate(guildID, feed.Template)
+
+	items := mod.feedCandidates(guildID, name, feed, res, false)
+
+	if feed.Rising.Delay > 0 {
+		items, err = mod.riseFeed(ctx, guildID, name, feed, items)
+		if err != nil {
+			return err
+		}
+	}
+
+	var posted bool
+
+	for _, r := range items {
+		// wait between posts, not after the last one
+		if posted {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(feedPostInterval):
+			}
+		}
+
+		posted = feed.PostFilter.match(r)
+
+		if posted {
+			err = mod.postFeedItem(t, feed, r)
+			if err != nil {
+				return err
+			}
+		} else {
+			metricFeedItems.Inc("filtered")
+		}
+
+		if err = mod.markFeedSeen(guildID, name, feed, r.ContentID); err != nil {
+			return err
+		}
+
+		if r.StartTime.After(feed.Last) {
+			feed.Last = r.StartTime
+		}
+	}
+
+	if feed.Last.IsZero() {
+		feed.Last = time.Now().Add(-feed.PostFilter.Age)
+	}
+
+	feed.Tracked = true
+
+	feed.Executed = time.Now()
+
+	return nil
+}
+
+// postFeedItem posts feed item embed
+func (mod *module) postFeedItem(t *template.Template, feed *feed, item *nicovideo.Item) error {
+	embed, err := mod.renderEmbed(t, item)
+	if err != nil {
+		return err
+	}
+
+	_, err = mod.config.Discord.ChannelMessageSendEmbed(feed.ChannelID, embed)
+	if err != nil {
+		return err
+	}
+
+	metricFeedItems.Inc("posted")
+
+	return nil
+}
+
+func (mod *module) commandFeed(ctx *router.Context) error {
+	name := ctx.Values.String("name")
+
+	if reservedFeedNames[name] {
+		return fmt.Errorf("%w: %s", ErrFeedName, name)
+	}
+
+	channel, err := ctx.Session.Channel(ctx.Values.String("channel"))
+	if err != nil {
+		return err
+	}
+
+	s := mod.parseSearch(ctx.Args[3:], []nicovideo.Field{}, 0, 20)
+
+	t, err := mod.config.Repository.ConfigGet(ctx.Message.GuildID, "nico", name)
+	if err != nil {
+		return err
+	}
+
+	fd := &feed{}
+
+	if t != "" {
+		if fd = parseFeed(t); fd == nil {
+			return fmt.Errorf("%w: %s", ErrFeedName, name)
+		}
+	}
+
+	fd.ChannelID = channel.ID
+	fd.Targets = s.Targets
+	fd.Query = s.Query
+	fd.Filters = s.Filters
+
+	fd.Period = ctx.Values.Duration("period")
+
+	if _, err = feedSchedule(fd); err != nil {
+		return err
+	}
+
+	if !fd.Paused && time.Since(fd.Executed) >= fd.Period {
+		err = mod.executeFeed(context.Background(), ctx.Message.GuildID, name, fd)
+		if err != nil {
+			return err
+		}
+	}
+
+	mod.fm.Lock()
+	err = mod.saveFeed(ctx.Message.GuildID, name, fd)
+	mod.fm.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return mod.rescheduleFeed(ctx.Message.GuildID, name, fd)
+}
+
+// formatFeedTime formats feed timestamp, zero time is formatted as never
+func formatFeedTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formatFeedNext formats time feed is scheduled to run next
+func (mod *module) formatFeedNext(guildID, name string, fd *feed) string {
+	if fd.Paused {
+		return "paused"
+	}
+
+	next := mod.config.Scheduler.Next(feedJob(guildID, name))
+
+	switch {
+	case next.IsZero():
+		return "not scheduled"
+	case time.Until(next) <= 0:
+		return "now"
+	default:
+		return formatFeedTime(next)
+	}
+}
+
+// formatFeedSchedule formats feed period or cron expression
+func formatFeedSchedule(fd *feed) string {
+	if fd.Cron != "" {
+		return "cron `" + fd.Cron + "`"
+	}
+
+	return "every " + fd.Period.String()
+}
+
+func (mod *module) commandFeedList(ctx *router.Context) error {
+	names, err := mod.feedNames(ctx.Message.GuildID)
+	if err != nil {
+		return err
+	}
+
+	if len(names) == 0 {
+		return ctx.ReplyEmbed("No feeds")
+	}
+
+	sb := &strings.Builder{}
+
+	for _, name := range names {
+		fd, ferr := mod.loadFeed(ctx.Message.GuildID, name)
+		if ferr != nil {
+			continue
+		}
+
+		_, _ = sb.WriteString("`" + name + "` <#" + fd.ChannelID + "> " + formatFeedSchedule(fd))
+		_, _ = sb.WriteString(", last item: " + formatFeedTime(fd.Last))
+		_, _ = sb.WriteString(", next: " + mod.formatFeedNext(ctx.Message.GuildID, name, fd))
+
+		if fd.Error != "" {
+			_, _ = sb.WriteString(", error: " + fd.Error)
+		}
+
+		_, _ = sb.WriteString("\n")
+	}
+
+	return ctx.ReplyEmbed(sb.String())
+}
+
+func (mod *module) commandFeedShow(ctx *router.Context) error {
+	name := ctx.Values.String("name")
+
+	fd, err := mod.loadFeed(ctx.Message.GuildID, name)
+	if err != nil {
+		return err
+	}
+
+	targets := make([]string, 0, len(fd.Targets))
+
+	for _, t := range fd.Targets {
+		targets = append(targets, "%"+string(t))
+	}
+
+	sb := &strings.Builder{}
+	_, _ = sb.WriteString("feed: `" + name + "`")
+	_, _ = sb.WriteString("\nchannel: <#" + fd.ChannelID + ">")
+	_, _ = sb.WriteString("\nperiod: " + fd.Period.String())
+
+	if fd.Cron != "" {
+		_, _ = sb.WriteString("\ncron: `" + fd.Cron + "`")
+	}
+
+	_, _ = sb.WriteString("\nquery: `" + strings.TrimSpace(fd.Query) + "`")
+	_, _ = sb.WriteString("\ntargets: `" + strings.Join(targets, " ") + "`")
+	_, _ = sb.WriteString("\nfilters: `" + formatSearchFilters(fd.Filters) + "`")
+	_, _ = sb.WriteString("\npost filters: " + fd.PostFilter.String())
+	_, _ = sb.WriteString("\nseen retention: " + feedSeenTTL(fd).String())
+	_, _ = sb.WriteString("\nrising: " + fd.Rising.String())
+	_, _ = sb.WriteString("\npaused: " + strconv.FormatBool(fd.Paused))
+
+	if fd.Template != "" {
+		_, _ = sb.WriteString("\ntemplate: ```\n" + fd.Template + "```")
+	}
+
+	_, _ = sb.WriteString("\nexecuted: " + formatFeedTime(fd.Executed))
+	_, _ = sb.WriteString("\nlast item: " + formatFeedTime(fd.Last))
+	_, _ = sb.WriteString("\nnext: " + mod.formatFeedNext(ctx.Message.GuildID, name, fd))
+
+	if fd.Error != "" {
+		_, _ = sb.WriteString("\nerror: " + fd.Error)
+	}
+
+	return ctx.ReplyEmbed(sb.String())
+}
+
+func (mod *module) commandFeedEdit(ctx *router.Context) error {
+	name := ctx.Values.String("name")
+
+	var channelID string
+
+	if ctx.Values.Has("channel") {
+		channel, err := ctx.Session.Channel(ctx.Values.String("channel"))
+		if err != nil {
+			return err
+		}
+
+		channelID = channel.ID
+	}
+
+	query := ctx.Values.Args("query")
+
+	rescheduled := ctx.Values.Has("period") || ctx.Values.Has("cron")
+
+	if channelID == "" && len(query) == 0 && !rescheduled && !ctx.Values.Has("template") {
+		return ErrFeedNoChanges
+	}
+
+	tmpl := ctx.Values.String("template")
+
+	if err := validateTemplate(tmpl); err != nil {
+		return err
+	}
+
+	cron := strings.TrimSpace(ctx.Values.String("cron"))
+
+	if cron != "" {
+		if _, err := bot.Cron(cron); err != nil {
+			return err
+		}
+	}
+
+	fd, err := mod.updateFeed(ctx.Message.GuildID, name, func(fd *feed) error {
+		if channelID != "" {
+			fd.ChannelID = channelID
+		}
+
+		if ctx.Values.Has("period") {
+			fd.Period = ctx.Values.Duration("period")
+		}
+
+		if ctx.Values.Has("cron") {
+			fd.Cron = cron
+		}
+
+		if ctx.Values.Has("template") {
+			fd.Template = tmpl
+		}
+
+		if len(query) > 0 {
+			s := mod.parseSearch(append(router.Args{name}, query...), []nicovideo.Field{}, 0, 20)
+
+			fd.Targets = s.Targets
+			fd.Query = s.Query
+			fd.Filters = s.Filters
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if rescheduled {
+		if err = mod.rescheduleFeed(ctx.Message.GuildID, name, fd); err != nil {
+			return err
+		}
+	}
+
+	return mod.commandFeedShow(ctx)
+}
+
+// setFeedPaused pauses or resumes feed
+func (mod *module) setFeedPaused(ctx *router.Context, paused bool) error {
+	name := ctx.Values.String("name")
+
+	fd, err := mod.updateFeed(ctx.Message.GuildID, name, func(fd *feed) error {
+		fd.Paused = paused
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = mod.scheduleFeed(ctx.Message.GuildID, name, fd); err != nil {
+		return err
+	}
+
+	if paused {
+		return ctx.ReplyEmbed("Paused feed `" + name + "`")
+	}
+
+	return ctx.ReplyEmbed("Resumed feed `" + name + "`")
+}
+
+func (mod *module) commandFeedPause(ctx *router.Context) error {
+	return mod.setFeedPaused(ctx, true)
+}
+
+func (mod *module) commandFeedResume(ctx *router.Context) error {
+	return mod.setFeedPaused(ctx, false)
+}
+
+func (mod *module) commandFeedDelete(ctx *router.Context) error {
+	name := ctx.Values.String("name")
+
+	mod.fm.Lock()
+	defer mod.fm.Unlock()
+
+	_, err := mod.loadFeed(ctx.Message.GuildID, name)
+	if err != nil {
+		return err
+	}
+
+	err = mod.config.Storage.Del(ctx.Message.GuildID + ".nico." + name)
+	if err != nil {
+		return err
+	}
+
+	mod.config.Scheduler.Remove(feedJob(ctx.Message.GuildID, name))
+
+	err = mod.clearFeedSeen(ctx.Message.GuildID, name)
+	if err != nil {
+		return err
+	}
+
+	err = mod.clearRisingCandidates(ctx.Message.GuildID, name)
+	if err != nil {
+		return err
+	}
+
+	return ctx.ReplyEmbed("Deleted feed `" + name + "`")
+}
+
+// commandFeedRun performs feed search without posting found items or updating the feed
+func (mod *module) commandFeedRun(ctx *router.Context) error {
+	name := ctx.Values.String("name")
+
+	fd, err := mod.loadFeed(ctx.Message.GuildID, name)
+	if err != nil {
+		return err
+	}
+
+	// dry run searches without backoff bookkeeping, so its errors do not pause feeds
+	res, err := mod.config.Nicovideo.Search(context.Background(), mod.executeFeedSearch(fd))
+	if err != nil {
+		return err
+	}
+
+	var items []*nicovideo.Item
+
+	sb := &strings.Builder{}
+
+	candidates := mod.feedCandidates(ctx.Message.GuildID, name, fd, res, true)
+
+	if fd.Rising.Delay > 0 {
+		candidates, err = mod.previewRising(sb, ctx.Message.GuildID, name, fd, candidates)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, r := range candidates {
+		if fd.PostFilter.match(r) {
+			items = append(items, r)
+		}
+	}
+
+	if len(items) == 0 {
+		_, _ = sb.WriteString(fmt.Sprintf(
+			"No new videos since %s, %d filtered out",
+			formatFeedTime(fd.Last),
+			len(candidates),
+		))
+
+		return ctx.ReplyEmbed(sb.String())
+	}
+
+	_, _ = sb.WriteString(fmt.Sprintf(
+		"%d videos would be posted to <#%s>, %d filtered out:\n",
+		len(items),
+		fd.ChannelID,
+		len(candidates)-len(items),
+	))
+
+	for _, r := range items {
+		line := fmt.Sprintf(
+			"https://www.nicovideo.jp/watch/%s %s %s views: %d\n",
+			r.ContentID,
+			r.ItemRaw.StartTime,
+			formatLength(r.LengthSeconds),
+			r.ViewCounter,
+		)
+
+		if sb.Len()+len(line) > feedPreviewLimit {
+			_, _ = sb.WriteString("...")
+
+			break
+		}
+
+		_, _ = sb.WriteString(line)
+	}
+
+	return ctx.ReplyEmbed(sb.String())
+}
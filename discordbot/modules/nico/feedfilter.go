@@ -0,0 +1,261 @@
+package nico
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eientei/jaroid/discordbot/router"
+	"github.com/eientei/jaroid/integration/nicovideo"
+)
+
+var (
+	// ErrFeedSeenTTL is returned when feed seen videos retention is shorter than search overlap
+	ErrFeedSeenTTL = errors.New("seen retention must be at least " + feedSearchOverlap.String())
+	// ErrFeedLengthRange is returned when minimum feed video length exceeds maximum
+	ErrFeedLengthRange = errors.New("minimum length exceeds maximum length")
+)
+
+const (
+	// feedSearchOverlap is how far before last posted item feed searches, catching videos with equal or
+	// edited start times, already posted ones are skipped by seen set
+	feedSearchOverlap = time.Hour
+
+	// defaultFeedSeenTTL is default retention of feed seen videos
+	defaultFeedSeenTTL = time.Hour * 24 * 7
+)
+
+// feedFilter is applied on feed search results before posting
+type feedFilter struct {
+	BlockedTags  []string      `json:"blocked_tags,omitempty"`
+	BlockedUsers []string      `json:"blocked_users,omitempty"`
+	Age          time.Duration `json:"age,omitempty"`
+	MinLength    time.Duration `json:"min_length,omitempty"`
+	MaxLength    time.Duration `json:"max_length,omitempty"`
+	MinViews     int           `json:"min_views,omitempty"`
+	MinMylists   int           `json:"min_mylists,omitempty"`
+}
+
+// match returns true if item passes the filter
+func (filter *feedFilter) match(item *nicovideo.Item) bool {
+	length := time.Duration(item.LengthSeconds) * time.Second
+
+	switch {
+	case item.ViewCounter < filter.MinViews, item.MylistCounter < filter.MinMylists:
+		return false
+	case length < filter.MinLength, filter.MaxLength > 0 && length > filter.MaxLength:
+		return false
+	}
+
+	for _, u := range filter.BlockedUsers {
+		if (item.UserID != 0 && u == strconv.Itoa(item.UserID)) ||
+			(item.ChannelID != 0 && u == "ch"+strconv.Itoa(item.ChannelID)) {
+			return false
+		}
+	}
+
+	for _, blocked := range filter.BlockedTags {
+		for _, tag := range item.Tags {
+			if strings.EqualFold(tag, blocked) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// String formats filter for feed display
+func (filter *feedFilter) String() string {
+	var parts []string
+
+	if filter.MinViews > 0 {
+		parts = append(parts, "views >= "+strconv.Itoa(filter.MinViews))
+	}
+
+	if filter.MinMylists > 0 {
+		parts = append(parts, "mylists >= "+strconv.Itoa(filter.MinMylists))
+	}
+
+	if filter.Age > 0 {
+		parts = append(parts, "checked "+filter.Age.String()+" after upload")
+	}
+
+	if filter.MinLength > 0 {
+		parts = append(parts, "length >= "+filter.MinLength.String())
+	}
+
+	if filter.MaxLength > 0 {
+		parts = append(parts, "length <= "+filter.MaxLength.String())
+	}
+
+	if len(filter.BlockedTags) > 0 {
+		parts = append(parts, "blocked tags: "+formatTags(filter.BlockedTags))
+	}
+
+	if len(filter.BlockedUsers) > 0 {
+		parts = append(parts, "blocked users: "+strings.Join(filter.BlockedUsers, " "))
+	}
+
+	if len(parts) == 0 {
+		return "none"
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// parseFilterList parses comma-separated list of filter values
+func parseFilterList(s string, normalize func(string) string) (values []string) {
+	for _, v := range strings.Split(s, ",") {
+		v = normalize(strings.TrimSpace(v))
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return
+}
+
+// normalizeUploader converts user page path to user ID, channel IDs are kept with ch prefix
+func normalizeUploader(s string) string {
+	return strings.TrimPrefix(s, "user/")
+}
+
+// feedSeenTTL returns retention of feed seen videos
+func feedSeenTTL(fd *feed) time.Duration {
+	if fd.SeenTTL > 0 {
+		return fd.SeenTTL
+	}
+
+	return defaultFeedSeenTTL
+}
+
+func feedSeenPrefix(guildID, name string) string {
+	return "nico.seen." + guildID + "." + name + "."
+}
+
+// feedSeen returns true if video was already processed by the feed
+func (mod *module) feedSeen(guildID, name, contentID string) bool {
+	ok, err := mod.config.Storage.Exists(feedSeenPrefix(guildID, name) + contentID)
+	if err != nil {
+		mod.config.Log.WithError(err).Error("Checking nico feed seen video", guildID, name, contentID)
+	}
+
+	return ok
+}
+
+// markFeedSeen remembers video as processed by the feed
+func (mod *module) markFeedSeen(guildID, name string, fd *feed, contentID string) error {
+	return mod.config.Storage.Set(feedSeenPrefix(guildID, name)+contentID, "1", feedSeenTTL(fd))
+}
+
+// clearFeedSeen forgets videos processed by the feed
+func (mod *module) clearFeedSeen(guildID, name string) error {
+	prefix := feedSeenPrefix(guildID, name)
+
+	keys, err := mod.config.Storage.Keys(prefix + "*")
+	if err != nil {
+		return err
+	}
+
+	var dels []string
+
+	for _, key := range keys {
+		// feed names can contain dots, content IDs do not
+		if !strings.Contains(strings.TrimPrefix(key, prefix), ".") {
+			dels = append(dels, key)
+		}
+	}
+
+	if len(dels) == 0 {
+		return nil
+	}
+
+	return mod.config.Storage.Del(dels...)
+}
+
+// feedCandidates returns search results not yet seen by the feed, oldest first. Preview stores nothing.
+func (mod *module) feedCandidates(
+	guildID, name string,
+	fd *feed,
+	res *nicovideo.Result,
+	preview bool,
+) (items []*nicovideo.Item) {
+	for i := len(res.Data) - 1; i >= 0; i-- {
+		r := res.Data[i]
+
+		switch {
+		case mod.feedSeen(guildID, name, r.ContentID):
+		case !fd.Tracked && !r.StartTime.After(fd.Last):
+			// feed was posting before seen videos were tracked, videos up to its last item are posted already
+			if preview {
+				continue
+			}
+
+			if err := mod.markFeedSeen(guildID, name, fd, r.ContentID); err != nil {
+				mod.config.Log.WithError(err).Error("Marking nico feed seen video", guildID, name, r.ContentID)
+			}
+		default:
+			items = append(items, r)
+		}
+	}
+
+	return
+}
+
+func (mod *module) commandFeedFilter(ctx *router.Context) error {
+	name := ctx.Values.String("name")
+
+	if seen := ctx.Values.Duration("seen"); seen != 0 && seen < feedSearchOverlap {
+		return ErrFeedSeenTTL
+	}
+
+	_, err := mod.updateFeed(ctx.Message.GuildID, name, func(fd *feed) error {
+		filter := &fd.PostFilter
+
+		if ctx.Values.Has("views") {
+			filter.MinViews = int(ctx.Values.Int("views"))
+		}
+
+		if ctx.Values.Has("mylists") {
+			filter.MinMylists = int(ctx.Values.Int("mylists"))
+		}
+
+		if ctx.Values.Has("age") {
+			filter.Age = ctx.Values.Duration("age")
+		}
+
+		if ctx.Values.Has("length.min") {
+			filter.MinLength = ctx.Values.Duration("length.min")
+		}
+
+		if ctx.Values.Has("length.max") {
+			filter.MaxLength = ctx.Values.Duration("length.max")
+		}
+
+		if ctx.Values.Has("tags") {
+			filter.BlockedTags = parseFilterList(ctx.Values.String("tags"), strings.TrimSpace)
+		}
+
+		if ctx.Values.Has("users") {
+			filter.BlockedUsers = parseFilterList(ctx.Values.String("users"), normalizeUploader)
+		}
+
+		if ctx.Values.Has("seen") {
+			fd.SeenTTL = ctx.Values.Duration("seen")
+		}
+
+		if filter.MaxLength > 0 && filter.MinLength > filter.MaxLength {
+			return fmt.Errorf("%w: %s > %s", ErrFeedLengthRange, filter.MinLength, filter.MaxLength)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return mod.commandFeedShow(ctx)
+}
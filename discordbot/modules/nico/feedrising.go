@@ -0,0 +1,324 @@
+package nico
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eientei/jaroid/discordbot/router"
+	"github.com/eientei/jaroid/integration/nicovideo"
+)
+
+var (
+	// ErrFeedRisingThreshold is returned when rising mode is enabled without any threshold
+	ErrFeedRisingThreshold = errors.New("rising feed requires at least one counter threshold or rate")
+)
+
+// risingRecheckBatch is maximum number of candidates rechecked with single search
+const risingRecheckBatch = 100
+
+// feedRising configures rising mode, in which found videos become candidates rechecked after delay and posted
+// only if any of their counters reaches threshold or grows with at least given rate per hour
+type feedRising struct {
+	Delay        time.Duration `json:"delay,omitempty"`
+	Views        int           `json:"views,omitempty"`
+	Mylists      int           `json:"mylists,omitempty"`
+	Comments     int           `json:"comments,omitempty"`
+	ViewsRate    int           `json:"views_rate,omitempty"`
+	MylistsRate  int           `json:"mylists_rate,omitempty"`
+	CommentsRate int           `json:"comments_rate,omitempty"`
+}
+
+// risingCandidate is video awaiting recheck with its counters at the time it was found
+type risingCandidate struct {
+	Found    time.Time `json:"found"`
+	Views    int       `json:"views"`
+	Mylists  int       `json:"mylists"`
+	Comments int       `json:"comments"`
+}
+
+// hasThreshold returns true if any threshold or rate is set
+func (rising *feedRising) hasThreshold() bool {
+	return rising.Views > 0 || rising.Mylists > 0 || rising.Comments > 0 ||
+		rising.ViewsRate > 0 || rising.MylistsRate > 0 || rising.CommentsRate > 0
+}
+
+// match returns true if rechecked item reached any of thresholds or rates since it became a candidate
+func (rising *feedRising) match(item *nicovideo.Item, candidate *risingCandidate, now time.Time) bool {
+	hours := now.Sub(candidate.Found).Hours()
+
+	reached := func(current, found, threshold, rate int) bool {
+		return (threshold > 0 && current >= threshold) ||
+			(rate > 0 && hours > 0 && float64(current-found)/hours >= float64(rate))
+	}
+
+	return reached(item.ViewCounter, candidate.Views, rising.Views, rising.ViewsRate) ||
+		reached(item.MylistCounter, candidate.Mylists, rising.Mylists, rising.MylistsRate) ||
+		reached(item.CommentCounter, candidate.Comments, rising.Comments, rising.CommentsRate)
+}
+
+// String formats rising mode for feed display
+func (rising *feedRising) String() string {
+	if rising.Delay <= 0 {
+		return "off"
+	}
+
+	var parts []string
+
+	for _, c := range []struct {
+		name            string
+		threshold, rate int
+	}{
+		{"views", rising.Views, rising.ViewsRate},
+		{"mylists", rising.Mylists, rising.MylistsRate},
+		{"comments", rising.Comments, rising.CommentsRate},
+	} {
+		if c.threshold > 0 {
+			parts = append(parts, c.name+" >= "+strconv.Itoa(c.threshold))
+		}
+
+		if c.rate > 0 {
+			parts = append(parts, c.name+" +"+strconv.Itoa(c.rate)+"/h")
+		}
+	}
+
+	return "rechecked after " + rising.Delay.String() + ", posted with " + strings.Join(parts, " or ")
+}
+
+func feedRisingKey(guildID, name string) string {
+	return "nico.rising." + guildID + "." + name
+}
+
+// loadRisingCandidates returns rising feed candidates by content ID
+func (mod *module) loadRisingCandidates(guildID, name string) (map[string]*risingCandidate, error) {
+	candidates := make(map[string]*risingCandidate)
+
+	s, err := mod.config.Storage.Get(feedRisingKey(guildID, name))
+	if err != nil || s == "" {
+		return candidates, err
+	}
+
+	return candidates, json.Unmarshal([]byte(s), &candidates)
+}
+
+// saveRisingCandidates stores rising feed candidates
+func (mod *module) saveRisingCandidates(guildID, name string, candidates map[string]*risingCandidate) error {
+	if len(candidates) == 0 {
+		return mod.clearRisingCandidates(guildID, name)
+	}
+
+	bs, err := json.Marshal(candidates)
+	if err != nil {
+		return err
+	}
+
+	return mod.config.Storage.Set(feedRisingKey(guildID, name), string(bs), 0)
+}
+
+// clearRisingCandidates removes rising feed candidates
+func (mod *module) clearRisingCandidates(guildID, name string) error {
+	return mod.config.Storage.Del(feedRisingKey(guildID, name))
+}
+
+// dueRisingCandidates returns content IDs of candidates due for recheck
+func dueRisingCandidates(fd *feed, candidates map[string]*risingCandidate, now time.Time) (ids []string) {
+	for id, c := range candidates {
+		if !c.Found.Add(fd.Rising.Delay).After(now) {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+
+	return
+}
+
+// recheckRising searches current counters of candidates, returning those rising enough, oldest first
+func (mod *module) recheckRising(
+	ctx context.Context,
+	fd *feed,
+	candidates map[string]*risingCandidate,
+	ids []string,
+	search feedSearchFunc,
+) (items []*nicovideo.Item, err error) {
+	now := time.Now()
+
+	for len(ids) > 0 {
+		batch := ids
+		if len(batch) > risingRecheckBatch {
+			batch = batch[:risingRecheckBatch]
+		}
+
+		ids = ids[len(batch):]
+
+		s := &nicovideo.Search{
+			Query:         fd.Query,
+			Targets:       fd.Targets,
+			Fields:        renderFields,
+			SortField:     nicovideo.FieldStartTime,
+			SortDirection: nicovideo.SortAsc,
+			Limit:         len(batch),
+			Filters: []nicovideo.Filter{
+				{
+					Field:    nicovideo.FieldContentID,
+					Operator: nicovideo.OperatorEqual,
+					Values:   batch,
+				},
+			},
+		}
+
+		var res *nicovideo.Result
+
+		res, err = search(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range res.Data {
+			if c, ok := candidates[r.ContentID]; ok && fd.Rising.match(r, c, now) {
+				items = append(items, r)
+			}
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].StartTime.Before(items[j].StartTime)
+	})
+
+	return items, nil
+}
+
+// riseFeed stores new feed items as rising candidates and returns rechecked candidates rising enough to be posted
+func (mod *module) riseFeed(
+	ctx context.Context,
+	guildID, name string,
+	fd *feed,
+	found []*nicovideo.Item,
+) ([]*nicovideo.Item, error) {
+	candidates, err := mod.loadRisingCandidates(guildID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	for _, r := range found {
+		candidates[r.ContentID] = &risingCandidate{
+			Found:    now,
+			Views:    r.ViewCounter,
+			Mylists:  r.MylistCounter,
+			Comments: r.CommentCounter,
+		}
+
+		if err = mod.markFeedSeen(guildID, name, fd, r.ContentID); err != nil {
+			return nil, err
+		}
+
+		if r.StartTime.After(fd.Last) {
+			fd.Last = r.StartTime
+		}
+	}
+
+	if err = mod.saveRisingCandidates(guildID, name, candidates); err != nil {
+		return nil, err
+	}
+
+	ids := dueRisingCandidates(fd, candidates, now)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	items, err := mod.recheckRising(ctx, fd, candidates, ids, mod.feedSearcher(fd))
+
+	switch {
+	case errors.Is(err, ErrFeedBackoff):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	metricFeedItems.Add(float64(len(ids)-len(items)), "dropped")
+
+	// rechecked candidates are dropped whether they rose or not, deleted videos are not found at all
+	for _, id := range ids {
+		delete(candidates, id)
+	}
+
+	return items, mod.saveRisingCandidates(guildID, name, candidates)
+}
+
+// previewRising describes rising candidates to sb and returns due candidates rising enough to be posted,
+// without storing anything or backing off feeds on search errors
+func (mod *module) previewRising(
+	sb *strings.Builder,
+	guildID, name string,
+	fd *feed,
+	found []*nicovideo.Item,
+) ([]*nicovideo.Item, error) {
+	candidates, err := mod.loadRisingCandidates(guildID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := dueRisingCandidates(fd, candidates, time.Now())
+
+	_, _ = sb.WriteString(fmt.Sprintf(
+		"%d new videos would become rising candidates, %d candidates pending, %d due for recheck\n",
+		len(found),
+		len(candidates),
+		len(ids),
+	))
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	return mod.recheckRising(context.Background(), fd, candidates, ids, mod.config.Nicovideo.Search)
+}
+
+func (mod *module) commandFeedRising(ctx *router.Context) error {
+	name := ctx.Values.String("name")
+
+	fd, err := mod.updateFeed(ctx.Message.GuildID, name, func(fd *feed) error {
+		rising := &fd.Rising
+
+		for arg, v := range map[string]*int{
+			"views":         &rising.Views,
+			"mylists":       &rising.Mylists,
+			"comments":      &rising.Comments,
+			"views.rate":    &rising.ViewsRate,
+			"mylists.rate":  &rising.MylistsRate,
+			"comments.rate": &rising.CommentsRate,
+		} {
+			if ctx.Values.Has(arg) {
+				*v = int(ctx.Values.Int(arg))
+			}
+		}
+
+		if ctx.Values.Has("delay") {
+			rising.Delay = ctx.Values.Duration("delay")
+		}
+
+		if rising.Delay > 0 && !rising.hasThreshold() {
+			return ErrFeedRisingThreshold
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if fd.Rising.Delay <= 0 {
+		if err = mod.clearRisingCandidates(ctx.Message.GuildID, name); err != nil {
+			return err
+		}
+	}
+
+	return mod.commandFeedShow(ctx)
+}
@@ -0,0 +1,105 @@
+package nico
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/eientei/jaroid/mediaservice/filestore"
+	"github.com/eientei/jaroid/util/httputil/signurl"
+)
+
+// filesPath is bot HTTP server path serving downloaded files
+const filesPath = "/files/"
+
+// registerFiles starts serving local files store with signed links, if secret is configured
+func (mod *module) registerFiles() {
+	secret := mod.config.Config.Private.Nicovideo.Secret
+	if secret == "" {
+		return
+	}
+
+	local, ok := mod.config.Files.(*filestore.Local)
+	if !ok {
+		return
+	}
+
+	mod.files = local
+
+	mod.signer = &signurl.Signer{
+		Key: []byte(secret),
+	}
+
+	mod.config.HTTP.HandleFunc(filesPath, mod.httpFiles)
+}
+
+// publicURL returns public link to downloaded file, signed until message cleanup when bot serves files itself
+func (mod *module) publicURL(fpath string) string {
+	base := filepath.Base(fpath)
+	uri := mod.config.Files.URL(base)
+
+	if mod.signer == nil {
+		return uri
+	}
+
+	// links expire along with message referencing the file, shared files outlive it
+	return uri + "?" + mod.signer.Sign(base, time.Now().Add(mod.config.Config.Private.Nicovideo.Period))
+}
+
+func (mod *module) httpFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodHead)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, filesPath)
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	err := mod.signer.Verify(name, r.URL.Query(), time.Now())
+
+	switch {
+	case errors.Is(err, signurl.ErrExpired):
+		http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+
+		return
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	f, err := os.Open(filepath.Join(mod.files.Directory, name))
+
+	switch {
+	case os.IsNotExist(err):
+		http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	finfo, err := f.Stat()
+	if err != nil || finfo.IsDir() {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	http.ServeContent(w, r, name, finfo.ModTime(), f)
+}
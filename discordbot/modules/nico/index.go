@@ -0,0 +1,296 @@
+package nico
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/eientei/jaroid/nicopost"
+)
+
+// Download index storage key prefixes
+const (
+	indexKeyPrefix = "nico.index."
+	hashKeyPrefix  = "nico.hash."
+	fileKeyPrefix  = "nico.fileid."
+)
+
+// indexEntry describes stored video shared by all messages referencing it
+type indexEntry struct {
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used"`
+	Name     string    `json:"name"`
+	VideoID  string    `json:"video_id"`
+	Hash     string    `json:"hash"`
+	Formats  []string  `json:"formats"`
+	Subs     []string  `json:"subs"`
+	Refs     []string  `json:"refs"`
+	Size     int64     `json:"size"`
+}
+
+// messageRef returns reference of message to stored file
+func messageRef(guildID, channelID, messageID string) string {
+	return guildID + "/" + channelID + "/" + messageID
+}
+
+func appendUnique(ss []string, s string) []string {
+	for _, v := range ss {
+		if v == s {
+			return ss
+		}
+	}
+
+	return append(ss, s)
+}
+
+func hashFile(fpath string) (string, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	h := sha256.New()
+
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// indexGet returns index entry of stored file name or nil
+func (mod *module) indexGet(name string) (*indexEntry, error) {
+	s, err := mod.config.Storage.Get(indexKeyPrefix + name)
+	if err != nil || s == "" {
+		return nil, err
+	}
+
+	e := &indexEntry{}
+
+	err = json.Unmarshal([]byte(s), e)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (mod *module) indexPut(e *indexEntry) error {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return mod.config.Storage.Set(indexKeyPrefix+e.Name, string(bs), 0)
+}
+
+// indexLookup returns name of stored file previously downloaded for file id or empty string
+func (mod *module) indexLookup(fileID string) string {
+	name, _ := mod.config.Storage.Get(fileKeyPrefix + fileID)
+	if name == "" {
+		return ""
+	}
+
+	if e, _ := mod.indexGet(name); e == nil {
+		return ""
+	}
+
+	return name
+}
+
+// dedupVideo looks up downloaded video in index, returning path of already stored file with identical content
+// instead, in which case downloaded copy is removed. Index is not changed until indexVideo is called with returned
+// entry, once the video is stored.
+func (mod *module) dedupVideo(task *TaskDownload, fpath string) (string, *indexEntry, bool, error) {
+	hash, err := hashFile(fpath)
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	finfo, err := os.Stat(fpath)
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	mod.im.Lock()
+	defer mod.im.Unlock()
+
+	name := filepath.Base(fpath)
+
+	existing, err := mod.config.Storage.Get(hashKeyPrefix + hash)
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	e, err := mod.indexGet(existing)
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	if e == nil || e.Name == name {
+		return fpath, &indexEntry{
+			Created: time.Now(),
+			Name:    name,
+			VideoID: nicopost.MediaID(task.VideoURL),
+			Hash:    hash,
+			Size:    finfo.Size(),
+		}, false, nil
+	}
+
+	mod.config.Log.Info("Deduplicated ", name, " as ", e.Name)
+
+	dedup := mod.workingPath(e.Name)
+
+	if task.Subs != "" {
+		_ = os.Rename(subtitleFilename(fpath, task.Subs), subtitleFilename(dedup, task.Subs))
+	}
+
+	// working copy of shared file may be already released
+	if _, err = os.Stat(dedup); err != nil {
+		err = os.Rename(fpath, dedup)
+	} else {
+		err = os.Remove(fpath)
+	}
+
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	return dedup, e, true, nil
+}
+
+// indexVideo adds stored video entry returned by dedupVideo to index, merging it with entry indexed meanwhile
+func (mod *module) indexVideo(task *TaskDownload, fileID string, e *indexEntry) error {
+	mod.im.Lock()
+	defer mod.im.Unlock()
+
+	current, err := mod.indexGet(e.Name)
+	if err != nil {
+		return err
+	}
+
+	if current != nil {
+		e = current
+	}
+
+	if task.Subs != "" {
+		e.Subs = appendUnique(e.Subs, subtitleFilename(e.Name, task.Subs))
+	}
+
+	e.Formats = appendUnique(e.Formats, fileID)
+	e.LastUsed = time.Now()
+
+	err = mod.indexPut(e)
+	if err == nil {
+		err = mod.config.Storage.Set(hashKeyPrefix+e.Hash, e.Name, 0)
+	}
+
+	if err == nil {
+		err = mod.config.Storage.Set(fileKeyPrefix+fileID, e.Name, 0)
+	}
+
+	return err
+}
+
+// indexRef adds message reference to stored file, marking it as recently used
+func (mod *module) indexRef(name, ref string) {
+	mod.im.Lock()
+	defer mod.im.Unlock()
+
+	e, err := mod.indexGet(name)
+	if err != nil || e == nil {
+		return
+	}
+
+	e.Refs = appendUnique(e.Refs, ref)
+	e.LastUsed = time.Now()
+
+	err = mod.indexPut(e)
+	if err != nil {
+		mod.config.Log.WithError(err).Error("Referencing stored file", name)
+	}
+}
+
+// indexUnref removes message reference to stored file, returning entry if file is no longer referenced
+// and should be deleted, and whether file is indexed at all
+func (mod *module) indexUnref(name, ref string) (e *indexEntry, indexed bool, err error) {
+	mod.im.Lock()
+	defer mod.im.Unlock()
+
+	e, err = mod.indexGet(name)
+	if err != nil || e == nil {
+		return nil, false, err
+	}
+
+	refs := e.Refs[:0]
+
+	for _, r := range e.Refs {
+		if r != ref {
+			refs = append(refs, r)
+		}
+	}
+
+	e.Refs = refs
+
+	if len(e.Refs) > 0 {
+		return nil, true, mod.indexPut(e)
+	}
+
+	return e, true, mod.indexDelete(e)
+}
+
+// indexDelete removes entry and its lookup keys from index, must be called with index lock held
+func (mod *module) indexDelete(e *indexEntry) error {
+	keys := []string{indexKeyPrefix + e.Name, hashKeyPrefix + e.Hash}
+
+	for _, f := range e.Formats {
+		keys = append(keys, fileKeyPrefix+f)
+	}
+
+	return mod.config.Storage.Del(keys...)
+}
+
+// indexRemove removes stored file from index regardless of references, returning removed entry or nil
+func (mod *module) indexRemove(name string) *indexEntry {
+	mod.im.Lock()
+	defer mod.im.Unlock()
+
+	e, err := mod.indexGet(name)
+	if err != nil || e == nil {
+		return nil
+	}
+
+	err = mod.indexDelete(e)
+	if err != nil {
+		mod.config.Log.WithError(err).Error("Removing stored file from index", name)
+	}
+
+	return e
+}
+
+// indexTouch marks stored file as recently used
+func (mod *module) indexTouch(name string) {
+	mod.im.Lock()
+	defer mod.im.Unlock()
+
+	e, err := mod.indexGet(name)
+	if err != nil || e == nil {
+		return
+	}
+
+	e.LastUsed = time.Now()
+
+	err = mod.indexPut(e)
+	if err != nil {
+		mod.config.Log.WithError(err).Error("Touching stored file", name)
+	}
+}
@@ -0,0 +1,31 @@
+package nico
+
+import (
+	"github.com/eientei/jaroid/util/metrics"
+)
+
+var (
+	metricFeedSearches = metrics.NewCounter(
+		"jaroid_nico_feed_searches_total",
+		"Number of feed search API calls by result",
+		"result",
+	)
+	metricFeedSearchDuration = metrics.NewHistogram(
+		"jaroid_nico_feed_search_duration_seconds",
+		"Duration of feed search API calls",
+		metrics.DefaultBuckets,
+	)
+	metricFeedBackoff = metrics.NewGauge(
+		"jaroid_nico_feed_backoff_seconds",
+		"Current feed search backoff, zero if not backing off",
+	)
+	metricFeedBackoffSkips = metrics.NewCounter(
+		"jaroid_nico_feed_backoff_skips_total",
+		"Number of feed executions skipped while awaiting backoff",
+	)
+	metricFeedItems = metrics.NewCounter(
+		"jaroid_nico_feed_items_total",
+		"Number of new feed items by result",
+		"result",
+	)
+)
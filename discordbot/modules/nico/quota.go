@@ -0,0 +1,307 @@
+package nico
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eientei/jaroid/discordbot/router"
+	"github.com/eientei/jaroid/mediaservice"
+)
+
+var (
+	// ErrQuotaExceeded is returned when video estimate is larger than whole download directory quota
+	ErrQuotaExceeded = errors.New("video is larger than download quota")
+	// ErrQuotaUnknownSize is returned when video size can not be estimated while download quota is set
+	ErrQuotaUnknownSize = errors.New("video size is unknown, select format with known size or size limit, e.g. 50m")
+)
+
+// storedFile is complete downloaded file in download directory
+type storedFile struct {
+	access time.Time
+	name   string
+	size   int64
+}
+
+// quota returns download directory size cap, zero if unlimited
+func (mod *module) quota() uint64 {
+	return mediaservice.HumanSizeParse(mod.config.Config.Private.Nicovideo.Quota)
+}
+
+// estimateTask fills task size estimate from formats listed by downloader or size limit of format selector when
+// quota is set, as downloads of unknown size can not be accounted
+func (mod *module) estimateTask(ctx context.Context, downloader mediaservice.Downloader, task *TaskDownload) error {
+	if mod.quota() == 0 || task.Estimate > 0 {
+		return nil
+	}
+
+	formats, err := downloader.ListFormats(ctx, task.VideoURL, nil)
+	if err == nil {
+		_, _, idx, _, _, serr := mediaservice.SelectFormat(formats, task.Format)
+		if serr == nil {
+			task.Estimate = formats[idx].SizeEstimate()
+		}
+	}
+
+	if task.Estimate == 0 {
+		size, _, _, perr := mediaservice.ParseFormatSize(task.Format)
+		if perr == nil && size != math.MaxUint64 {
+			task.Estimate = size
+		}
+	}
+
+	if task.Estimate == 0 {
+		return ErrQuotaUnknownSize
+	}
+
+	return nil
+}
+
+// checkQuota refuses downloads which would not fit into quota even with empty directory
+func (mod *module) checkQuota(task *TaskDownload) error {
+	quota := mod.quota()
+
+	if quota > 0 && task.Estimate > quota {
+		return fmt.Errorf(
+			"%w: %s > %s",
+			ErrQuotaExceeded,
+			strings.TrimSpace(mediaservice.HumanSizeFormat(float64(task.Estimate))),
+			strings.TrimSpace(mediaservice.HumanSizeFormat(float64(quota))),
+		)
+	}
+
+	return nil
+}
+
+// storedFiles lists complete files in download directory with their last use time from download index and
+// their total size. Partial downloads are not counted, as active downloads are accounted by their estimates.
+// Subtitles of indexed videos are not listed, as they are removed along with videos.
+func (mod *module) storedFiles() (files []*storedFile, total uint64, err error) {
+	entries, err := os.ReadDir(mod.config.Config.Private.Nicovideo.Directory)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, 0, err
+	}
+
+	subs := make(map[string]bool)
+
+	for _, e := range entries {
+		finfo, ierr := e.Info()
+		if ierr != nil || !finfo.Mode().IsRegular() {
+			continue
+		}
+
+		if strings.HasSuffix(e.Name(), ".part") {
+			continue
+		}
+
+		total += uint64(finfo.Size())
+
+		f := &storedFile{
+			name:   e.Name(),
+			size:   finfo.Size(),
+			access: finfo.ModTime(),
+		}
+
+		if ie, _ := mod.indexGet(e.Name()); ie != nil {
+			f.access = ie.LastUsed
+
+			for _, s := range ie.Subs {
+				subs[s] = true
+			}
+		}
+
+		files = append(files, f)
+	}
+
+	listed := files[:0]
+
+	for _, f := range files {
+		if !subs[f.name] {
+			listed = append(listed, f)
+		}
+	}
+
+	files = listed
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].access.Before(files[j].access)
+	})
+
+	return files, total, nil
+}
+
+// reserved returns sum of estimates of active downloads, must be called with lock held
+func (mod *module) reserved() (sum uint64) {
+	for _, d := range mod.downloads {
+		sum += d.task.Estimate
+	}
+
+	return
+}
+
+// quotaRescanInterval is how long scanned download directory usage is reused unless files were added or removed,
+// picking up changes made outside of the bot
+const quotaRescanInterval = time.Minute
+
+// quotaUsage is download directory usage, scanned without holding lock
+type quotaUsage struct {
+	files   []*storedFile
+	total   uint64
+	scanned time.Time
+}
+
+// scanQuota returns download directory usage, nil if quota is not set or directory can not be listed.
+// Usage is cached until download directory is changed by the bot or rescan interval passes.
+func (mod *module) scanQuota() *quotaUsage {
+	if mod.quota() == 0 {
+		return nil
+	}
+
+	mod.qm.Lock()
+	usage := mod.usage
+	mod.qm.Unlock()
+
+	if usage != nil && time.Since(usage.scanned) < quotaRescanInterval {
+		return usage
+	}
+
+	files, total, err := mod.storedFiles()
+	if err != nil {
+		mod.config.Log.WithError(err).Error("Listing download directory")
+
+		return nil
+	}
+
+	usage = &quotaUsage{
+		files:   files,
+		total:   total,
+		scanned: time.Now(),
+	}
+
+	mod.qm.Lock()
+	mod.usage = usage
+	mod.qm.Unlock()
+
+	return usage
+}
+
+// invalidateQuota drops cached download directory usage after files were added or removed
+func (mod *module) invalidateQuota() {
+	mod.qm.Lock()
+	mod.usage = nil
+	mod.qm.Unlock()
+}
+
+// quotaFits returns true if task estimate fits into quota along with active downloads. When it would fit only
+// after evicting least recently requested files, false is returned along with files to evict outside of lock.
+// Must be called with lock held.
+func (mod *module) quotaFits(task *TaskDownload, usage *quotaUsage) (bool, []*storedFile) {
+	quota := mod.quota()
+
+	// oversized tasks are refused by worker
+	if usage == nil || quota == 0 || task.Estimate > quota {
+		return true, nil
+	}
+
+	need := usage.total + mod.reserved() + task.Estimate
+
+	if need <= quota {
+		return true, nil
+	}
+
+	var evictable uint64
+
+	for _, f := range usage.files {
+		evictable += uint64(f.size)
+	}
+
+	// no point in evicting anything while active downloads occupy the space
+	if need > quota+evictable {
+		return false, nil
+	}
+
+	var evict []*storedFile
+
+	for _, f := range usage.files {
+		if need <= quota {
+			break
+		}
+
+		evict = append(evict, f)
+
+		need -= uint64(f.size)
+	}
+
+	return false, evict
+}
+
+// evict removes file with its subtitles from download directory, files store and download index
+func (mod *module) evict(name string) {
+	names := []string{name}
+
+	if e := mod.indexRemove(name); e != nil {
+		names = append(names, e.Subs...)
+	}
+
+	err := mod.removeStored(context.Background(), names...)
+	if err != nil {
+		mod.config.Log.WithError(err).Error("Evicting ", name)
+
+		return
+	}
+
+	mod.config.Log.Info("Evicted ", name)
+}
+
+func (mod *module) commandUsage(ctx *router.Context) error {
+	files, total, err := mod.storedFiles()
+	if err != nil {
+		return err
+	}
+
+	mod.m.Lock()
+	reserved, active := mod.reserved(), len(mod.downloads)
+	mod.m.Unlock()
+
+	sb := &strings.Builder{}
+
+	_, _ = fmt.Fprintf(sb, "Used %s", strings.TrimSpace(mediaservice.HumanSizeFormat(float64(total))))
+
+	if quota := mod.quota(); quota > 0 {
+		_, _ = fmt.Fprintf(
+			sb,
+			" of %s (%2.1f%%)",
+			strings.TrimSpace(mediaservice.HumanSizeFormat(float64(quota))),
+			float64(total)/float64(quota)*100,
+		)
+	}
+
+	_, _ = fmt.Fprintf(sb, " by %d files", len(files))
+
+	if active > 0 {
+		_, _ = fmt.Fprintf(
+			sb,
+			", %d active downloads reserve %s",
+			active,
+			strings.TrimSpace(mediaservice.HumanSizeFormat(float64(reserved))),
+		)
+	}
+
+	if len(files) > 0 {
+		_, _ = fmt.Fprintf(
+			sb,
+			"\nLeast recently requested: %s at %s",
+			files[0].name,
+			files[0].access.UTC().Format(time.RFC3339),
+		)
+	}
+
+	_, err = ctx.Reply(sb.String())
+
+	return err
+}
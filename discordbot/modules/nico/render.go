@@ -0,0 +1,190 @@
+package nico
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eientei/jaroid/integration/nicovideo"
+)
+
+// defaultTemplate is default video embed description template, see nicovideo.Item for .item fields
+const defaultTemplate = `{{ length .item.LengthSeconds }} | views {{ .item.ViewCounter }} | ` +
+	`mylists {{ .item.MylistCounter }} | comments {{ .item.CommentCounter }}
+{{ tags .item.Tags }}`
+
+// Discord embed length limits
+const (
+	embedTitleLimit       = 256
+	embedDescriptionLimit = 4096
+)
+
+// renderFields are search fields used in video embeds
+var renderFields = []nicovideo.Field{
+	nicovideo.FieldContentID,
+	nicovideo.FieldTitle,
+	nicovideo.FieldThumbnailURL,
+	nicovideo.FieldUserID,
+	nicovideo.FieldChannelID,
+	nicovideo.FieldTags,
+	nicovideo.FieldStartTime,
+	nicovideo.FieldLengthSeconds,
+	nicovideo.FieldViewCounter,
+	nicovideo.FieldMylistCounter,
+	nicovideo.FieldCommentCounter,
+}
+
+var templateFuncs = template.FuncMap{
+	"length": formatLength,
+	"tags":   formatTags,
+	"join": func(delim string, ss []string) string {
+		return strings.Join(ss, delim)
+	},
+}
+
+// sampleItem is video with every rendered field set, templates are executed against it when set
+var sampleItem = &nicovideo.Item{
+	StartTime: time.Date(2007, 3, 6, 0, 33, 0, 0, time.UTC),
+	Tags:      []string{"sample", "tag"},
+	ItemRaw: nicovideo.ItemRaw{
+		ContentID:      "sm9",
+		Title:          "sample video",
+		ThumbnailURL:   "https://nicovideo.cdn.nimg.jp/thumbnails/9/9",
+		StartTime:      "2007-03-06T00:33:00Z",
+		Tags:           "sample tag",
+		UserID:         4,
+		ViewCounter:    1000,
+		MylistCounter:  100,
+		LengthSeconds:  320,
+		CommentCounter: 10,
+	},
+}
+
+// defaultParsedTemplate is parsed default template, rendering whatever templates fail on
+var defaultParsedTemplate = template.Must(parseTemplate(""))
+
+// parseTemplate parses video embed description template, empty template is parsed as default one
+func parseTemplate(tmpl string) (*template.Template, error) {
+	if strings.TrimSpace(tmpl) == "" {
+		tmpl = defaultTemplate
+	}
+
+	return template.New("").Funcs(templateFuncs).Parse(tmpl)
+}
+
+// validateTemplate parses template and executes it against sample video, catching unknown fields and
+// mistyped function arguments before template is used
+func validateTemplate(tmpl string) error {
+	t, err := parseTemplate(tmpl)
+	if err != nil {
+		return err
+	}
+
+	return t.Execute(io.Discard, renderData(sampleItem))
+}
+
+// renderTemplate returns parsed template of guild or feed, falling back to default one on errors
+func (mod *module) renderTemplate(guildID, tmpl string) *template.Template {
+	if tmpl == "" {
+		tmpl, _ = mod.config.Repository.ConfigGet(guildID, "nico", "template")
+	}
+
+	t, err := parseTemplate(tmpl)
+	if err != nil {
+		mod.config.Log.WithError(err).Error("Parsing nico template", guildID)
+
+		return defaultParsedTemplate
+	}
+
+	return t
+}
+
+// formatUploader returns name and link of video uploader
+func formatUploader(item *nicovideo.Item) (name, uri string) {
+	switch {
+	case item.ChannelID != 0:
+		name = "ch" + strconv.Itoa(item.ChannelID)
+
+		return name, "https://ch.nicovideo.jp/" + name
+	case item.UserID != 0:
+		name = strconv.Itoa(item.UserID)
+
+		return "user/" + name, "https://www.nicovideo.jp/user/" + name
+	}
+
+	return "", ""
+}
+
+func truncateText(s string, limit int) string {
+	rs := []rune(s)
+	if len(rs) <= limit {
+		return s
+	}
+
+	return string(rs[:limit-1]) + "…"
+}
+
+// renderData returns template data of video
+func renderData(item *nicovideo.Item) map[string]interface{} {
+	uploader, uploaderURL := formatUploader(item)
+
+	return map[string]interface{}{
+		"url":          "https://www.nicovideo.jp/watch/" + item.ContentID,
+		"item":         item,
+		"uploader":     uploader,
+		"uploader_url": uploaderURL,
+	}
+}
+
+// renderEmbed renders video search result as embed with description from template, falling back to default
+// template if execution fails
+func (mod *module) renderEmbed(t *template.Template, item *nicovideo.Item) (*discordgo.MessageEmbed, error) {
+	uri := "https://www.nicovideo.jp/watch/" + item.ContentID
+	uploader, uploaderURL := formatUploader(item)
+	data := renderData(item)
+
+	sb := &strings.Builder{}
+
+	err := t.Execute(sb, data)
+	if err != nil {
+		mod.config.Log.WithError(err).Error("Executing nico template", item.ContentID)
+
+		sb.Reset()
+
+		err = defaultParsedTemplate.Execute(sb, data)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	title := item.Title
+	if title == "" {
+		title = item.ContentID
+	}
+
+	embed := &discordgo.MessageEmbed{
+		URL:         uri,
+		Title:       truncateText(title, embedTitleLimit),
+		Description: truncateText(sb.String(), embedDescriptionLimit),
+		Timestamp:   item.ItemRaw.StartTime,
+	}
+
+	if item.ThumbnailURL != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{
+			URL: item.ThumbnailURL,
+		}
+	}
+
+	if uploader != "" {
+		embed.Author = &discordgo.MessageEmbedAuthor{
+			Name: uploader,
+			URL:  uploaderURL,
+		}
+	}
+
+	return embed, nil
+}
@@ -93,6 +93,28 @@ func parseSearchVariables(a string, s *nicovideo.Search) bool {
 	return false
 }
 
+// formatSearchFilters formats filters the way they are given in search query
+func formatSearchFilters(filters []nicovideo.Filter) string {
+	var parts []string
+
+	for _, f := range filters {
+		switch f.Operator {
+		case nicovideo.OperatorRange:
+			parts = append(parts, "$"+string(f.Field)+"="+strings.Join(f.Values, ".."))
+		case nicovideo.OperatorGTE:
+			parts = append(parts, "$"+string(f.Field)+"=>"+strings.Join(f.Values, ""))
+		case nicovideo.OperatorLTE:
+			parts = append(parts, "$"+string(f.Field)+"=<"+strings.Join(f.Values, ""))
+		default:
+			for _, v := range f.Values {
+				parts = append(parts, "$"+string(f.Field)+"="+v)
+			}
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
 func (mod *module) parseSearch(args router.Args, fields []nicovideo.Field, offset, limit int) (s *nicovideo.Search) {
 	s = &nicovideo.Search{
 		Fields: fields,
@@ -0,0 +1,147 @@
+package nico
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/eientei/jaroid/mediaservice/filestore"
+)
+
+// workingPath returns path of stored file in download directory
+func (mod *module) workingPath(name string) string {
+	return filepath.Join(mod.config.Config.Private.Nicovideo.Directory, name)
+}
+
+// storesInPlace returns true if files store is the download directory itself
+func (mod *module) storesInPlace() bool {
+	local, ok := mod.config.Files.(*filestore.Local)
+	if !ok {
+		return false
+	}
+
+	dir, _ := filepath.Abs(mod.config.Config.Private.Nicovideo.Directory)
+	ldir, _ := filepath.Abs(local.Directory)
+
+	return dir == ldir
+}
+
+// findStored returns download directory path of already stored video with its subtitles or empty string,
+// looking up download index first and files store by file id prefix next.
+// Videos to be posted to fediverse need local copy, so they are not considered stored without it.
+func (mod *module) findStored(ctx context.Context, task *TaskDownload, fileID string) string {
+	name := mod.indexLookup(fileID)
+
+	var err error
+
+	if name == "" {
+		name, err = filestore.Find(ctx, mod.config.Files, fileID)
+		if err != nil {
+			mod.config.Log.WithError(err).Error("Finding stored file", fileID)
+
+			return ""
+		}
+	}
+
+	if name == "" {
+		return ""
+	}
+
+	if task.Subs != "" {
+		var ok bool
+
+		ok, err = filestore.Exists(ctx, mod.config.Files, subtitleFilename(name, task.Subs))
+		if err != nil || !ok {
+			return ""
+		}
+	}
+
+	fpath := mod.workingPath(name)
+
+	if task.Post {
+		if _, err = os.Stat(fpath); err != nil {
+			return ""
+		}
+	}
+
+	mod.indexTouch(name)
+
+	return fpath
+}
+
+// storeVideo puts downloaded video and its subtitles into files store, video shared with earlier download
+// is already stored
+func (mod *module) storeVideo(ctx context.Context, task *TaskDownload, fpath string, shared bool) error {
+	var fpaths []string
+
+	if !shared {
+		fpaths = append(fpaths, fpath)
+	}
+
+	if task.Subs != "" {
+		fpaths = append(fpaths, subtitleFilename(fpath, task.Subs))
+	}
+
+	for _, p := range fpaths {
+		err := mod.config.Files.Put(ctx, filepath.Base(p), p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeStored removes files from download directory and files store
+func (mod *module) removeStored(ctx context.Context, names ...string) error {
+	defer mod.invalidateQuota()
+
+	for _, name := range names {
+		_ = os.Remove(mod.workingPath(name))
+
+		if mod.storesInPlace() {
+			continue
+		}
+
+		err := mod.config.Files.Remove(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cleanupFile drops reference of expired message to stored file, removing file once it is not referenced anymore
+func (mod *module) cleanupFile(task *TaskCleanup) error {
+	name := filepath.Base(task.FilePath)
+
+	e, indexed, err := mod.indexUnref(name, messageRef(task.GuildID, task.ChannelID, task.MessageID))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !indexed:
+		_ = os.Remove(task.FilePath)
+
+		return mod.removeStored(context.Background(), name)
+	case e != nil:
+		return mod.removeStored(context.Background(), append([]string{e.Name}, e.Subs...)...)
+	}
+
+	return nil
+}
+
+// releaseWorkingCopy removes local copy of video stored elsewhere once it is no longer needed
+func (mod *module) releaseWorkingCopy(task *TaskDownload, fpath string) {
+	if mod.storesInPlace() || task.Post {
+		return
+	}
+
+	_ = os.Remove(fpath)
+
+	if task.Subs != "" {
+		_ = os.Remove(subtitleFilename(fpath, task.Subs))
+	}
+}
@@ -4,15 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
-	"path"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/eientei/jaroid/discordbot/model"
+	"github.com/eientei/jaroid/integration/ffmpeg"
 	"github.com/eientei/jaroid/mediaservice"
 	"github.com/eientei/jaroid/nicopost"
 )
@@ -31,9 +31,11 @@ type TaskDownload struct {
 	UserID    string          `json:"user_id"`
 	Subs      string          `json:"subs"`
 	Data      json.RawMessage `json:"data"`
+	Service   string          `json:"service"`
 	Estimate  uint64          `json:"estimate"`
 	Post      bool            `json:"post"`
 	Preview   bool            `json:"preview"`
+	Mux       bool            `json:"mux"`
 }
 
 // Scope returns task scope
@@ -46,6 +48,16 @@ func (TaskDownload) Name() string {
 	return "download"
 }
 
+// RetryPolicy returns task retry policy
+func (TaskDownload) RetryPolicy() *model.RetryPolicy {
+	return &model.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Second * 30,
+		MaxBackoff:  time.Minute * 10,
+		Jitter:      0.2,
+	}
+}
+
 // TaskList provides list of video formats available
 type TaskList struct {
 	GuildID   string `json:"guild_id"`
@@ -54,6 +66,7 @@ type TaskList struct {
 	UserID    string `json:"user_id"`
 	VideoURL  string `json:"video_url"`
 	Subs      string `json:"subs"`
+	Service   string `json:"service"`
 	Post      bool   `json:"post"`
 }
 
@@ -67,6 +80,15 @@ func (TaskList) Name() string {
 	return "list"
 }
 
+// RetryPolicy returns task retry policy
+func (TaskList) RetryPolicy() *model.RetryPolicy {
+	return &model.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Second * 10,
+		Jitter:      0.2,
+	}
+}
+
 // TaskCleanup provides message and file removal delayed task
 type TaskCleanup struct {
 	GuildID   string `json:"guild_id"`
@@ -85,6 +107,15 @@ func (TaskCleanup) Name() string {
 	return "cleanup"
 }
 
+// RetryPolicy returns task retry policy
+func (TaskCleanup) RetryPolicy() *model.RetryPolicy {
+	return &model.RetryPolicy{
+		MaxAttempts: 5,
+		Backoff:     time.Minute,
+		MaxBackoff:  time.Hour,
+	}
+}
+
 // TaskPleromaPost posts video to pleroma instance
 type TaskPleromaPost struct {
 	GuildID     string `json:"guild_id"`
@@ -107,6 +138,15 @@ func (TaskPleromaPost) Name() string {
 	return "pleroma_post"
 }
 
+// RetryPolicy returns task retry policy
+func (TaskPleromaPost) RetryPolicy() *model.RetryPolicy {
+	return &model.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Minute,
+		Jitter:      0.2,
+	}
+}
+
 func (mod *module) ackTask(task model.Task, id string, err error) {
 	if err != nil {
 		mod.config.Log.WithError(err).Error("Dequeuing")
@@ -116,30 +156,72 @@ func (mod *module) ackTask(task model.Task, id string, err error) {
 		}
 	}
 
-	if err == nil {
-		err = mod.config.Repository.TaskAck(task, id)
-		if err != nil {
-			mod.config.Log.WithError(err).Error("Acking task", id)
-		}
+	if err != nil {
+		mod.failTask(task, id, err)
+
+		return
+	}
+
+	err = mod.config.Repository.TaskAck(task, id)
+	if err != nil {
+		mod.config.Log.WithError(err).Error("Acking task", id)
+	}
+}
+
+// failTask requeues failed task according to its retry policy, returns id of requeued task or empty string
+// if task was moved to dead-letter queue
+func (mod *module) failTask(task model.Task, id string, cause error) string {
+	newid, err := mod.config.Repository.TaskFail(task, id, cause)
+	if err != nil {
+		mod.config.Log.WithError(err).Error("Failing task", id)
 	}
+
+	return newid
+}
+
+// downloader returns media downloader of given service, tasks without service are nicovideo ones
+func (mod *module) downloader(service string) (mediaservice.Downloader, error) {
+	if service == "" {
+		return mod.config.Nicovideo, nil
+	}
+
+	downloader := mod.config.Media.Get(service)
+	if downloader == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownService, service)
+	}
+
+	return downloader, nil
 }
 
 func subtitleFilename(s, subs string) string {
 	return strings.ReplaceAll(s, ".mp4", "."+subs+".ass")
 }
 
-func (mod *module) downloadSend(task *TaskDownload, fpath string) {
+// availableSend sends already stored video, referencing it by task message
+func (mod *module) availableSend(task *TaskDownload, fpath string) {
+	mod.scheduleCleanup(task, fpath)
+	mod.downloadSend(task, fpath, "Already available as ")
+}
+
+func (mod *module) downloadSend(task *TaskDownload, fpath, status string) {
 	time.Sleep(time.Second)
 
-	base := filepath.Base(fpath)
-	uri := mod.config.Config.Private.Nicovideo.Public + "/" + base
+	if mod.uploadSend(task, fpath, status) {
+		if task.Post {
+			mod.pleromaPostEnqueue(task, fpath)
+		}
+
+		return
+	}
+
+	uri := mod.publicURL(fpath)
 	sb := &strings.Builder{}
-	_, _ = sb.WriteString("Downloaded as ")
+	_, _ = sb.WriteString(status)
 	_, _ = sb.WriteString(uri)
 	_, _ = sb.WriteString(" file will be deleted after " + mod.config.Config.Private.Nicovideo.Period.String())
 
 	if task.Subs != "" {
-		sb.WriteString("\ndanmaku subtitles: " + subtitleFilename(uri, task.Subs))
+		sb.WriteString("\ndanmaku subtitles: " + mod.publicURL(subtitleFilename(fpath, task.Subs)))
 	}
 
 	var err error
@@ -180,7 +262,12 @@ func (mod *module) listFormatsVideo(task *TaskList) (err error) {
 		}
 	}()
 
-	formats, err := mod.config.Nicovideo.ListFormats(context.Background(), task.VideoURL, &mediaservice.ListOptions{
+	downloader, err := mod.downloader(task.Service)
+	if err != nil {
+		return err
+	}
+
+	formats, err := downloader.ListFormats(context.Background(), task.VideoURL, &mediaservice.ListOptions{
 		Reporter: reporter,
 	})
 	if err != nil {
@@ -195,6 +282,11 @@ func (mod *module) listFormatsVideo(task *TaskList) (err error) {
 }
 
 func (mod *module) downloadVideo(ctx context.Context, id string, task *TaskDownload) (fmtname string, err error) {
+	err = mod.checkQuota(task)
+	if err != nil {
+		return "", err
+	}
+
 	err = os.MkdirAll(mod.config.Config.Private.Nicovideo.Directory, 0777)
 	if err != nil {
 		return "", err
@@ -208,31 +300,115 @@ func (mod *module) downloadVideo(ctx context.Context, id string, task *TaskDownl
 
 	if task.Subs != "" {
 		opts.Subtitles = append(opts.Subtitles, task.Subs)
+		opts.MuxSubtitles = task.Mux
 	}
 
-	go func() {
-		for r := range opts.Reporter.Messages() {
-			_, err = mod.config.Discord.ChannelMessageEdit(task.ChannelID, task.MessageID, id+" [downloading] "+r)
-			if err != nil {
-				mod.config.Log.WithError(err).Error("updating message")
-			}
-		}
-	}()
+	go mod.forwardProgress(id, task, "downloading", opts.Reporter)
 
-	fmtname, err = mod.config.Nicovideo.SaveFormat(ctx, task.VideoURL, task.Format, output, true, task.Data, opts)
+	downloader, err := mod.downloader(task.Service)
+	if err != nil {
+		return "", err
+	}
+
+	fmtname, err = downloader.SaveFormat(ctx, task.VideoURL, task.Format, output, true, task.Data, opts)
 	if err != nil {
 		opts.Reporter.Submit("ERROR: "+err.Error(), true)
 
 		mod.config.Log.WithError(err).Error("downloading file")
+
+		return
+	}
+
+	// comments failing to download do not fail the video, it is stored without subtitles
+	if task.Subs != "" {
+		if _, serr := os.Stat(subtitleFilename(fmtname, task.Subs)); serr != nil {
+			mod.config.Log.WithError(serr).Warn("Skipping subtitles ", task.VideoURL)
+
+			task.Subs = ""
+		}
+	}
+
+	fmtname, err = mod.fitVideo(ctx, id, task, fmtname)
+	if err != nil {
+		return
+	}
+
+	fileID := nicopost.FormatFileID(nicopost.MediaID(task.VideoURL), task.Format)
+
+	fmtname, entry, shared, err := mod.dedupVideo(task, fmtname)
+	if err != nil {
+		mod.config.Log.WithError(err).Error("deduplicating file")
+
+		return
+	}
+
+	err = mod.storeVideo(ctx, task, fmtname, shared)
+	if err != nil {
+		mod.config.Log.WithError(err).Error("storing file")
+
+		return
+	}
+
+	// indexed only once stored, so failed uploads are not taken for stored videos on retry
+	err = mod.indexVideo(task, fileID, entry)
+	if err != nil {
+		mod.config.Log.WithError(err).Error("indexing file")
 	}
 
 	return
 }
 
-func (mod *module) startList() {
+// forwardProgress relays reporter messages to download progress and task message
+func (mod *module) forwardProgress(id string, task *TaskDownload, stage string, reporter mediaservice.Reporter) {
+	for r := range reporter.Messages() {
+		mod.config.Progress.Update(progressDownload, id, r)
+
+		_, rerr := mod.config.Discord.ChannelMessageEdit(task.ChannelID, task.MessageID, id+" ["+stage+"] "+r)
+		if rerr != nil {
+			mod.config.Log.WithError(rerr).Error("updating message")
+		}
+	}
+}
+
+// fitVideo re-encodes downloaded video exceeding size constraint of format (e.g. 50m!) when ffmpeg is configured
+func (mod *module) fitVideo(
+	ctx context.Context,
+	id string,
+	task *TaskDownload,
+	fpath string,
+) (string, error) {
+	conf := mod.config.Config.Private.FFmpeg
+	if conf.Executable == "" {
+		return fpath, nil
+	}
+
+	reporter := mediaservice.NewReporter(time.Second*10, 1, nil)
+
+	defer reporter.Close()
+
+	go mod.forwardProgress(id, task, "re-encoding", reporter)
+
+	transcoder := &ffmpeg.Transcoder{
+		ExecutablePath: conf.Executable,
+		Args:           conf.Args,
+	}
+
+	fitted, err := transcoder.Fit(ctx, task.Format, fpath, reporter)
+	if err != nil {
+		reporter.Submit("ERROR: "+err.Error(), true)
+
+		mod.config.Log.WithError(err).Error("re-encoding file")
+
+		return fpath, err
+	}
+
+	return fitted, nil
+}
+
+func (mod *module) startList(ctx context.Context) {
 	task := &TaskList{}
 
-	for {
+	for ctx.Err() == nil {
 		id, err := mod.config.Repository.TaskDequeue(task, time.Second)
 		if err != nil {
 			mod.config.Log.WithError(err).Error("Dequeuing")
@@ -250,12 +426,15 @@ func (mod *module) startList() {
 			continue
 		}
 
-		err = mod.tryPerform(func() error {
-			return mod.listFormatsVideo(task)
-		})
+		err = mod.listFormatsVideo(task)
 		if err != nil {
 			mod.config.Log.WithError(err).Error("Listing formats for video")
-			mod.updateMessage(task.GuildID, task.ChannelID, task.MessageID, "Listing formats error")
+
+			if mod.failTask(task, id, err) == "" {
+				mod.updateMessage(task.GuildID, task.ChannelID, task.MessageID, "Listing formats error")
+			}
+
+			continue
 		}
 
 		mod.ackTask(task, id, nil)
@@ -263,13 +442,13 @@ func (mod *module) startList() {
 }
 
 func (mod *module) startDownloadError(err error, task *TaskDownload) {
-	if err == context.Canceled {
+	if errors.Is(err, context.Canceled) {
 		mod.updateMessage(task.GuildID, task.ChannelID, task.MessageID, "Cancelled")
 
 		return
 	}
 
-	if err == context.DeadlineExceeded {
+	if errors.Is(err, context.DeadlineExceeded) {
 		mod.updateMessage(
 			task.GuildID,
 			task.ChannelID,
@@ -284,167 +463,60 @@ func (mod *module) startDownloadError(err error, task *TaskDownload) {
 	mod.updateMessage(task.GuildID, task.ChannelID, task.MessageID, "Downloading error")
 }
 
-func subsExist(task *TaskDownload, path string) bool {
-	if task.Subs == "" {
-		return true
+// retryDownload requeues failed download, reporting scheduled retry or final failure
+func (mod *module) retryDownload(id string, task *TaskDownload, cause error) {
+	attempt, max, err := mod.config.Repository.TaskAttempt(task, id)
+	if err != nil {
+		mod.config.Log.WithError(err).Error("Getting task attempt", id)
 	}
 
-	cand := subtitleFilename(path, task.Subs)
+	newid := mod.failTask(task, id, cause)
+	if newid == "" {
+		mod.startDownloadError(cause, task)
 
-	_, err := os.Stat(cand)
+		return
+	}
 
-	return err == nil
+	mod.config.Log.WithError(cause).Error("Downloading video, retrying", newid)
+	mod.updateMessage(
+		task.GuildID,
+		task.ChannelID,
+		task.MessageID,
+		fmt.Sprintf("%s Downloading error, retry scheduled (attempt %d/%d)", newid, attempt+1, max),
+	)
+
+	_ = mod.config.Discord.MessageReactionAdd(task.ChannelID, task.MessageID, emojiStop)
 }
 
-func (mod *module) startDownload() {
-	task := &TaskDownload{}
+func (mod *module) startCleanup(ctx context.Context) {
+	task := &TaskCleanup{}
 
-	for {
+	for ctx.Err() == nil {
 		id, err := mod.config.Repository.TaskDequeue(task, time.Second)
 		if err != nil {
 			mod.config.Log.WithError(err).Error("Dequeuing")
-			continue
 		}
 
 		if id == "" {
 			continue
 		}
 
-		basename := path.Base(task.VideoURL)
-		if len(basename) == 0 {
-			mod.ackTask(task, id, nil)
-
-			continue
-		}
-
-		fileID := nicopost.FormatFileID(basename, task.Format)
-
-		var fpath string
-
-		if fpath, err = nicopost.GlobFind(mod.config.Config.Private.Nicovideo.Directory, fileID); err == nil &&
-			len(fpath) > 0 && subsExist(task, fpath) {
-			mod.downloadSend(task, fpath)
-			mod.ackTask(task, id, nil)
-
-			_ = mod.config.Discord.MessageReactionRemove(task.ChannelID, task.MessageID, emojiStop, "@me")
-
-			continue
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
-
-		mod.m.Lock()
-		mod.task = task
-		mod.cancel = cancel
-		mod.m.Unlock()
-
-		err = mod.tryPerform(func() (err error) {
-			fpath, err = mod.downloadVideo(ctx, id, task)
-
-			return
-		})
-
-		cancel()
-
-		_ = mod.config.Discord.MessageReactionRemove(task.ChannelID, task.MessageID, emojiStop, "@me")
-
-		mod.m.Lock()
-
-		mod.task = nil
-		mod.cancel = nil
-
-		mod.m.Unlock()
-
-		mod.ackTask(task, id, nil)
-
-		if len(fpath) > 0 {
-			_, _, cerr := mod.config.Repository.TaskEnqueue(&TaskCleanup{
-				GuildID:   task.GuildID,
-				ChannelID: task.ChannelID,
-				MessageID: task.MessageID,
-				FilePath:  fpath,
-			}, mod.config.Config.Private.Nicovideo.Period, 0)
-			if cerr != nil {
-				mod.config.Log.WithError(cerr).Error(
-					"Scheduling cleanup",
-					task.GuildID,
-					task.ChannelID,
-					task.MessageID,
-				)
-			}
-
-			if task.Subs != "" {
-				_, _, cerr = mod.config.Repository.TaskEnqueue(&TaskCleanup{
-					GuildID:   task.GuildID,
-					ChannelID: task.ChannelID,
-					MessageID: task.MessageID,
-					FilePath:  subtitleFilename(fpath, task.Subs),
-				}, mod.config.Config.Private.Nicovideo.Period, 0)
-				if cerr != nil {
-					mod.config.Log.WithError(cerr).Error(
-						"Scheduling subtitle cleanup",
-						task.GuildID,
-						task.ChannelID,
-						task.MessageID,
-					)
-				}
-			}
-		}
-
-		if err != nil {
-			mod.startDownloadError(err, task)
-
-			continue
-		}
-
-		if len(fpath) > 0 {
-			mod.downloadSend(task, fpath)
-		}
-	}
-}
-
-func (mod *module) tryPerform(action func() error) (err error) {
-	i := 0
-	last := time.Now()
-
-	for i < 3 {
-		err = action()
+		err = mod.cleanupFile(task)
 		if err != nil {
-			if errors.Is(err, mediaservice.ErrUnknownFormat) ||
-				err == context.Canceled || err == context.DeadlineExceeded {
-				break
-			}
+			mod.config.Log.WithError(err).Error("Removing stored file", task.FilePath)
 
-			if time.Since(last) < time.Second*30 {
-				i++
-			} else {
-				i = 0
-			}
+			mod.failTask(task, id, err)
 
-			last = time.Now()
-		} else {
-			break
+			continue
 		}
-	}
-
-	return
-}
-
-func (mod *module) startCleanup() {
-	task := &TaskCleanup{}
 
-	for {
-		id, err := mod.config.Repository.TaskDequeue(task, time.Second)
-		if err != nil {
-			mod.config.Log.WithError(err).Error("Dequeuing")
-		}
+		// uploaded attachments stay available after the downloaded copy expires, message is left as is
+		if mod.uploaded(task) {
+			mod.ackTask(task, id, nil)
 
-		if id == "" {
 			continue
 		}
 
-		_ = os.Remove(task.FilePath)
-
 		line := "Downloaded video deleted due to expiration"
 		_, err = mod.config.Discord.ChannelMessageEdit(task.ChannelID, task.MessageID, line)
 		mod.ackTask(task, id, err)
@@ -0,0 +1,131 @@
+package nico
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eientei/jaroid/mediaservice"
+)
+
+// uploadLimits maps guild boost tier to attachment size limit
+var uploadLimits = map[discordgo.PremiumTier]uint64{
+	discordgo.PremiumTierNone: 10 << 20,
+	discordgo.PremiumTier1:    10 << 20,
+	discordgo.PremiumTier2:    50 << 20,
+	discordgo.PremiumTier3:    100 << 20,
+}
+
+// uploadLimit returns attachment size limit of guild if direct uploads are enabled for it, zero otherwise
+func (mod *module) uploadLimit(guildID string) uint64 {
+	s, ok := mod.server(guildID)
+	if !ok || !s.upload {
+		return 0
+	}
+
+	if s.uploadLimit > 0 {
+		return s.uploadLimit
+	}
+
+	tier := discordgo.PremiumTierNone
+
+	if guild, err := mod.config.Discord.State.Guild(guildID); err == nil {
+		tier = guild.PremiumTier
+	}
+
+	return uploadLimits[tier]
+}
+
+// uploadFiles returns files to attach to message, nil if they do not fit into upload limit of guild
+func (mod *module) uploadFiles(task *TaskDownload, fpath string) (files []string) {
+	limit := mod.uploadLimit(task.GuildID)
+	if limit == 0 || task.Preview {
+		return nil
+	}
+
+	files = append(files, fpath)
+
+	if task.Subs != "" {
+		files = append(files, subtitleFilename(fpath, task.Subs))
+	}
+
+	var total uint64
+
+	for _, f := range files {
+		finfo, err := os.Stat(f)
+		if err != nil {
+			return nil
+		}
+
+		total += uint64(finfo.Size())
+	}
+
+	if total > limit {
+		return nil
+	}
+
+	return files
+}
+
+// uploadSend attaches downloaded files to task message, returns false if upload was not possible
+func (mod *module) uploadSend(task *TaskDownload, fpath, status string) bool {
+	fnames := mod.uploadFiles(task, fpath)
+	if len(fnames) == 0 {
+		return false
+	}
+
+	var files []*discordgo.File
+
+	for _, fname := range fnames {
+		f, err := os.Open(fname)
+		if err != nil {
+			mod.config.Log.WithError(err).Error("Opening upload", fname)
+
+			return false
+		}
+
+		defer func() {
+			_ = f.Close()
+		}()
+
+		files = append(files, &discordgo.File{
+			Name:   filepath.Base(fname),
+			Reader: f,
+		})
+	}
+
+	content := status + filepath.Base(fpath)
+	edit := discordgo.NewMessageEdit(task.ChannelID, task.MessageID).SetContent(content)
+	edit.Files = files
+
+	_, err := mod.config.Discord.ChannelMessageEditComplex(edit)
+	if err != nil {
+		mod.config.Log.WithError(err).Error("Uploading file", task.GuildID, task.ChannelID, task.MessageID)
+
+		return false
+	}
+
+	return true
+}
+
+// uploaded returns true if download message of cleaned up file has files attached to it
+func (mod *module) uploaded(task *TaskCleanup) bool {
+	msg, err := mod.config.Discord.ChannelMessage(task.ChannelID, task.MessageID)
+	if err != nil {
+		return false
+	}
+
+	return len(msg.Attachments) > 0
+}
+
+// parseUploadLimit parses per-guild upload limit override as human size, e.g. 25m
+func parseUploadLimit(s string) uint64 {
+	s = strings.TrimSpace(s)
+
+	if !mediaservice.MatchesHumanSize(s) || strings.HasSuffix(s, "!") {
+		return 0
+	}
+
+	return mediaservice.HumanSizeParse(s)
+}
@@ -0,0 +1,348 @@
+package nico
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/eientei/jaroid/discordbot/model"
+	"github.com/eientei/jaroid/mediaservice"
+	"github.com/eientei/jaroid/nicopost"
+)
+
+const progressDownload = "nico.download"
+
+// download keeps state of download being executed by worker
+type download struct {
+	id     string
+	task   *TaskDownload
+	cancel context.CancelFunc
+}
+
+func (mod *module) guildWorkers(guildID string) int {
+	if s, ok := mod.server(guildID); ok && s.workers > 0 {
+		return s.workers
+	}
+
+	return mod.config.Config.Private.Nicovideo.GuildWorkers
+}
+
+// canStart returns true if there is free worker for given guild, must be called with lock held
+func (mod *module) canStart(guildID string) bool {
+	if len(mod.downloads) >= mod.config.Config.Private.Nicovideo.Workers {
+		return false
+	}
+
+	var n int
+
+	for _, d := range mod.downloads {
+		if d.task.GuildID == guildID {
+			n++
+		}
+	}
+
+	return n < mod.guildWorkers(guildID)
+}
+
+func (mod *module) startDownload(ctx context.Context) {
+	for ctx.Err() == nil {
+		task := &TaskDownload{}
+
+		// usage is scanned and files are evicted without lock, accept callback is called every second
+		usage := mod.scanQuota()
+
+		var evict []*storedFile
+
+		id, err := mod.config.Repository.TaskDequeueFunc(task, time.Second, func(id string) bool {
+			mod.m.Lock()
+			defer mod.m.Unlock()
+
+			if _, ok := mod.downloads[id]; ok || !mod.canStart(task.GuildID) {
+				return false
+			}
+
+			fits, files := mod.quotaFits(task, usage)
+			if evict == nil {
+				evict = files
+			}
+
+			return fits
+		})
+		if err != nil {
+			mod.config.Log.WithError(err).Error("Dequeuing")
+
+			continue
+		}
+
+		if id == "" {
+			// task is accepted on next iteration, once space is freed
+			for _, f := range evict {
+				mod.evict(f.name)
+			}
+
+			continue
+		}
+
+		dctx, cancel := context.WithTimeout(ctx, time.Hour)
+
+		mod.m.Lock()
+		mod.downloads[id] = &download{
+			id:     id,
+			task:   task,
+			cancel: cancel,
+		}
+		mod.m.Unlock()
+
+		mod.config.Scheduler.Go(progressDownload+"."+id, func(context.Context) {
+			mod.performDownload(dctx, id, task)
+		})
+
+		mod.refreshQueue()
+	}
+}
+
+func (mod *module) performDownload(ctx context.Context, id string, task *TaskDownload) {
+	mod.config.Progress.Start(progressDownload, id, task.GuildID, task.VideoURL+" "+task.Format)
+
+	defer func() {
+		mod.config.Progress.Finish(progressDownload, id)
+
+		// downloaded, deduplicated or stored files change directory usage
+		mod.invalidateQuota()
+
+		mod.m.Lock()
+		if d, ok := mod.downloads[id]; ok {
+			d.cancel()
+			delete(mod.downloads, id)
+		}
+		mod.m.Unlock()
+
+		mod.refreshQueue()
+	}()
+
+	basename := nicopost.MediaID(task.VideoURL)
+	if len(basename) == 0 {
+		mod.ackTask(task, id, nil)
+
+		return
+	}
+
+	fileID := nicopost.FormatFileID(basename, task.Format)
+
+	if fpath := mod.findStored(ctx, task, fileID); len(fpath) > 0 {
+		mod.availableSend(task, fpath)
+		mod.ackTask(task, id, nil)
+
+		_ = mod.config.Discord.MessageReactionRemove(task.ChannelID, task.MessageID, emojiStop, "@me")
+
+		return
+	}
+
+	partial, err := nicopost.GlobFindPartial(mod.config.Config.Private.Nicovideo.Directory, fileID)
+	if err == nil && len(partial) > 0 && !task.Preview {
+		mod.updateMessage(task.GuildID, task.ChannelID, task.MessageID, id+" Resuming download from partial file...")
+	}
+
+	fpath, err := mod.downloadVideo(ctx, id, task)
+
+	_ = mod.config.Discord.MessageReactionRemove(task.ChannelID, task.MessageID, emojiStop, "@me")
+
+	switch {
+	case err == nil:
+		mod.ackTask(task, id, nil)
+
+		if len(fpath) > 0 {
+			mod.scheduleCleanup(task, fpath)
+			mod.downloadSend(task, fpath, "Downloaded as ")
+			mod.releaseWorkingCopy(task, fpath)
+		}
+	case errors.Is(err, context.Canceled) && mod.config.Scheduler.Stopped():
+		// task is left unacknowledged to be resumed after restart
+		mod.updateMessage(task.GuildID, task.ChannelID, task.MessageID, id+" Interrupted by restart, download will resume")
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		mod.ackTask(task, id, nil)
+		mod.startDownloadError(err, task)
+	case errors.Is(err, mediaservice.ErrUnknownFormat), errors.Is(err, ErrUnknownService),
+		errors.Is(err, ErrQuotaExceeded):
+		derr := mod.config.Repository.TaskDead(task, id, err)
+		if derr != nil {
+			mod.config.Log.WithError(derr).Error("Moving task to dead-letter queue", id)
+		}
+
+		mod.startDownloadError(err, task)
+	default:
+		mod.retryDownload(id, task, err)
+	}
+}
+
+// scheduleCleanup references stored file by task message and schedules removal of the reference
+func (mod *module) scheduleCleanup(task *TaskDownload, fpath string) {
+	name := filepath.Base(fpath)
+
+	mod.indexRef(name, messageRef(task.GuildID, task.ChannelID, task.MessageID))
+
+	_, _, err := mod.config.Repository.TaskEnqueue(&TaskCleanup{
+		GuildID:   task.GuildID,
+		ChannelID: task.ChannelID,
+		MessageID: task.MessageID,
+		FilePath:  fpath,
+	}, mod.config.Config.Private.Nicovideo.Period, 0)
+	if err != nil {
+		mod.config.Log.WithError(err).Error(
+			"Scheduling cleanup",
+			task.GuildID,
+			task.ChannelID,
+			task.MessageID,
+		)
+	}
+
+	// subtitles of indexed videos are removed along with them
+	if e, _ := mod.indexGet(name); task.Subs == "" || e != nil {
+		return
+	}
+
+	_, _, err = mod.config.Repository.TaskEnqueue(&TaskCleanup{
+		GuildID:   task.GuildID,
+		ChannelID: task.ChannelID,
+		MessageID: task.MessageID,
+		FilePath:  subtitleFilename(fpath, task.Subs),
+	}, mod.config.Config.Private.Nicovideo.Period, 0)
+	if err != nil {
+		mod.config.Log.WithError(err).Error(
+			"Scheduling subtitle cleanup",
+			task.GuildID,
+			task.ChannelID,
+			task.MessageID,
+		)
+	}
+}
+
+// cancelDownload cancels active download by task id, returns false if task is not being downloaded
+func (mod *module) cancelDownload(id string) bool {
+	mod.m.Lock()
+	defer mod.m.Unlock()
+
+	d, ok := mod.downloads[id]
+	if !ok {
+		return false
+	}
+
+	d.cancel()
+
+	return true
+}
+
+// refreshQueue updates messages of waiting downloads with their current queue position
+func (mod *module) refreshQueue() {
+	queue, err := mod.config.Repository.TaskPending(&TaskDownload{})
+	if err != nil {
+		mod.config.Log.WithError(err).Error("Listing download queue")
+
+		return
+	}
+
+	for _, m := range queue {
+		mod.m.Lock()
+		_, active := mod.downloads[m.ID]
+		mod.m.Unlock()
+
+		if active {
+			continue
+		}
+
+		var task TaskDownload
+
+		if m.Values["attempt"] != "" && m.Values["attempt"] != "0" {
+			continue
+		}
+
+		if json.Unmarshal([]byte(m.Values["data"]), &task) != nil || task.MessageID == "" || task.Preview {
+			continue
+		}
+
+		mod.updateMessage(task.GuildID, task.ChannelID, task.MessageID, mod.queuedMessage(m.ID, &task, queue))
+	}
+}
+
+// TaskCancelled marks message of download cancelled by config command and updates positions of the rest
+func (mod *module) TaskCancelled(name string, m *model.StreamMessage) {
+	if name != taskNico+"."+(TaskDownload{}).Name() {
+		return
+	}
+
+	var task TaskDownload
+
+	if json.Unmarshal([]byte(m.Values["data"]), &task) == nil && task.MessageID != "" {
+		mod.updateMessage(task.GuildID, task.ChannelID, task.MessageID, "Cancelled")
+	}
+
+	mod.refreshQueue()
+}
+
+// TaskMoved updates messages of waiting downloads after download was moved to the front under new id
+func (mod *module) TaskMoved(name string, m *model.StreamMessage, newID string) {
+	if name != taskNico+"."+(TaskDownload{}).Name() {
+		return
+	}
+
+	// retried downloads are skipped by queue refresh, their messages would keep id they were requeued with
+	if m.Values["attempt"] != "" && m.Values["attempt"] != "0" {
+		var task TaskDownload
+
+		queue, err := mod.config.Repository.TaskPending(&task)
+		if err != nil {
+			mod.config.Log.WithError(err).Error("Listing download queue")
+		}
+
+		if err == nil && json.Unmarshal([]byte(m.Values["data"]), &task) == nil && task.MessageID != "" {
+			mod.updateMessage(task.GuildID, task.ChannelID, task.MessageID, mod.queuedMessage(newID, &task, queue))
+		}
+	}
+
+	mod.refreshQueue()
+}
+
+// queuedMessage renders position of task in queue, skipping tasks being downloaded already
+func (mod *module) queuedMessage(id string, task *TaskDownload, queue []model.StreamMessage) string {
+	mod.m.Lock()
+	defer mod.m.Unlock()
+
+	var (
+		position int
+		size     uint64
+	)
+
+	for _, m := range queue {
+		if m.ID == id {
+			break
+		}
+
+		if _, ok := mod.downloads[m.ID]; ok {
+			continue
+		}
+
+		var t TaskDownload
+
+		if json.Unmarshal([]byte(m.Values["data"]), &t) == nil {
+			size += t.Estimate
+		}
+
+		position++
+	}
+
+	if position == 0 && mod.canStart(task.GuildID) {
+		return fmt.Sprintf("%s Starting download...", id)
+	}
+
+	return fmt.Sprintf(
+		"%s queued at position %d (in front of you: %s, active downloads: %d/%d)",
+		id,
+		position+1,
+		mediaservice.HumanSizeFormat(float64(size)),
+		len(mod.downloads),
+		mod.config.Config.Private.Nicovideo.Workers,
+	)
+}
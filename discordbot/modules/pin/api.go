@@ -146,9 +146,9 @@ func (mod *module) commandPin(ctx *router.Context) error {
 
 	key := ctx.Message.GuildID + "." + ctx.Message.Author.ID + ".pin"
 
-	v := mod.config.Client.Incr(key).Val()
+	v, _ := mod.config.Storage.Incr(key)
 	if v == 1 {
-		mod.config.Client.Expire(key, time.Hour)
+		_ = mod.config.Storage.Expire(key, time.Hour)
 	}
 
 	if v > 1 {
@@ -182,7 +182,7 @@ func (mod *module) commandPin(ctx *router.Context) error {
 		}
 	}
 
-	mod.config.Client.Set(msg.GuildID+"."+messageID+".pinned", msg.Author.ID, time.Hour*24*7)
+	_ = mod.config.Storage.Set(msg.GuildID+"."+messageID+".pinned", msg.Author.ID, time.Hour*24*7)
 
 	return ctx.Session.ChannelMessagePin(ctx.Message.ChannelID, messageID)
 }
@@ -199,9 +199,9 @@ func (mod *module) commandUnpin(ctx *router.Context) error {
 
 	key := ctx.Message.GuildID + "." + ctx.Message.Author.ID + ".unpin"
 
-	v := mod.config.Client.Incr(key).Val()
+	v, _ := mod.config.Storage.Incr(key)
 	if v == 1 {
-		mod.config.Client.Expire(key, time.Hour)
+		_ = mod.config.Storage.Expire(key, time.Hour)
 	}
 
 	if v > 1 {
@@ -224,7 +224,7 @@ func (mod *module) commandUnpin(ctx *router.Context) error {
 		return ErrInvalidMessageID
 	}
 
-	if mod.config.Client.Exists(msg.GuildID+"."+messageID+".pinned").Val() != 0 {
+	if pinned, _ := mod.config.Storage.Exists(msg.GuildID + "." + messageID + ".pinned"); pinned {
 		return ErrTooEarly
 	}
 
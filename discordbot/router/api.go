@@ -39,12 +39,21 @@ type MiddlewareFunc func(handler HandlerFunc) HandlerFunc
 // HandlerFunc implements command execution
 type HandlerFunc func(ctx *Context) error
 
+// AutocompleteFunc returns suggestions for focused application command option
+type AutocompleteFunc func(
+	ctx *Context,
+	option *discordgo.ApplicationCommandInteractionDataOption,
+) []*discordgo.ApplicationCommandOptionChoice
+
 // Context simplifies request handling
 type Context struct {
-	Session *discordgo.Session
-	Message *discordgo.Message
-	Route   *Route
-	Args    Args
+	Session     *discordgo.Session
+	Message     *discordgo.Message
+	Interaction *discordgo.Interaction
+	Route       *Route
+	Args        Args
+	Values      Values
+	response    *discordgo.Message
 }
 
 // Reply keeps track of user requests and bot replies
@@ -55,15 +64,73 @@ type Reply struct {
 
 // React reacts to original message with emoji
 func (ctx *Context) React(emoji string) (err error) {
-	err = ctx.Session.MessageReactionAdd(ctx.Message.ChannelID, ctx.Message.ID, emoji)
+	if ctx.Interaction == nil {
+		return ctx.Session.MessageReactionAdd(ctx.Message.ChannelID, ctx.Message.ID, emoji)
+	}
+
+	if ctx.response == nil {
+		_, err = ctx.respond(emoji, nil)
+
+		return
+	}
+
+	return ctx.Session.MessageReactionAdd(ctx.response.ChannelID, ctx.response.ID, emoji)
+}
+
+// respond sends interaction reply, editing deferred response first and following up afterwards
+func (ctx *Context) respond(content string, embeds []*discordgo.MessageEmbed) (msg *discordgo.Message, err error) {
+	if ctx.response == nil {
+		edit := &discordgo.WebhookEdit{}
+
+		if content != "" {
+			edit.Content = &content
+		}
+
+		if len(embeds) > 0 {
+			edit.Embeds = &embeds
+		}
+
+		msg, err = ctx.Session.InteractionResponseEdit(ctx.Interaction, edit)
+		if err != nil {
+			return
+		}
+
+		msg.GuildID = ctx.Interaction.GuildID
+		ctx.response = msg
+
+		return
+	}
+
+	msg, err = ctx.Session.FollowupMessageCreate(ctx.Interaction, true, &discordgo.WebhookParams{
+		Content: content,
+		Embeds:  embeds,
+	})
+	if err != nil {
+		return
+	}
+
+	msg.GuildID = ctx.Interaction.GuildID
 
 	return
 }
 
+func (ctx *Context) send(content string, embed *discordgo.MessageEmbed) (msg *discordgo.Message, err error) {
+	switch {
+	case ctx.Interaction != nil && embed != nil:
+		return ctx.respond("", []*discordgo.MessageEmbed{embed})
+	case ctx.Interaction != nil:
+		return ctx.respond(content, nil)
+	case embed != nil:
+		return ctx.Session.ChannelMessageSendEmbed(ctx.Message.ChannelID, embed)
+	default:
+		return ctx.Session.ChannelMessageSend(ctx.Message.ChannelID, content)
+	}
+}
+
 // ReplyEmbed replies to original message with embed
 func (ctx *Context) ReplyEmbed(desc string) (err error) {
 	var msg *discordgo.Message
-	msg, err = ctx.Session.ChannelMessageSendEmbed(ctx.Message.ChannelID, &discordgo.MessageEmbed{
+	msg, err = ctx.send("", &discordgo.MessageEmbed{
 		Description: desc,
 	})
 
@@ -82,7 +149,7 @@ func (ctx *Context) ReplyEmbed(desc string) (err error) {
 // ReplyEmbedCustom replies to original message with custom embed
 func (ctx *Context) ReplyEmbedCustom(embed *discordgo.MessageEmbed) (err error) {
 	var msg *discordgo.Message
-	msg, err = ctx.Session.ChannelMessageSendEmbed(ctx.Message.ChannelID, embed)
+	msg, err = ctx.send("", embed)
 
 	if err != nil {
 		return
@@ -98,7 +165,30 @@ func (ctx *Context) ReplyEmbedCustom(embed *discordgo.MessageEmbed) (err error)
 
 // Reply replies to original message
 func (ctx *Context) Reply(desc string) (msg *discordgo.Message, err error) {
-	msg, err = ctx.Session.ChannelMessageSend(ctx.Message.ChannelID, desc)
+	msg, err = ctx.send(desc, nil)
+
+	if err != nil {
+		return
+	}
+
+	ctx.Route.Replies[msg.ID] = &Reply{
+		Request:  ctx.Message,
+		Response: msg,
+	}
+
+	return
+}
+
+// ReplyEmbeds replies to original message with content and embeds
+func (ctx *Context) ReplyEmbeds(content string, embeds []*discordgo.MessageEmbed) (msg *discordgo.Message, err error) {
+	if ctx.Interaction != nil {
+		msg, err = ctx.respond(content, embeds)
+	} else {
+		msg, err = ctx.Session.ChannelMessageSendComplex(ctx.Message.ChannelID, &discordgo.MessageSend{
+			Content: content,
+			Embeds:  embeds,
+		})
+	}
 
 	if err != nil {
 		return
@@ -127,18 +217,37 @@ func NewRouter() *Router {
 
 // Route describes command route
 type Route struct {
-	Router      *Router
-	Name        string
-	Description string
-	Matcher     MatcherFunc
-	Handler     HandlerFunc
-	Baked       HandlerFunc
-	Data        map[string]interface{}
-	Replies     map[string]*Reply
-	Middleware  []MiddlewareFunc
-	Groups      []*Group
-	Alias       []string
-	AliasHelp   bool
+	Router       *Router
+	Name         string
+	Description  string
+	Matcher      MatcherFunc
+	Handler      HandlerFunc
+	Baked        HandlerFunc
+	Data         map[string]interface{}
+	Replies      map[string]*Reply
+	Middleware   []MiddlewareFunc
+	Groups       []*Group
+	Alias        []string
+	AliasHelp    bool
+	Arguments    []*Argument
+	Command      bool
+	Options      []*discordgo.ApplicationCommandOption
+	Autocomplete AutocompleteFunc
+}
+
+// SetCommand marks route to be registered as application command with given options
+func (route *Route) SetCommand(options ...*discordgo.ApplicationCommandOption) *Route {
+	route.Command = true
+	route.Options = options
+
+	return route
+}
+
+// SetAutocomplete sets autocomplete handler for application command options
+func (route *Route) SetAutocomplete(autocomplete AutocompleteFunc) *Route {
+	route.Autocomplete = autocomplete
+
+	return route
 }
 
 // Set sets route config value
@@ -0,0 +1,509 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/eientei/jaroid/mediaservice"
+)
+
+var (
+	// ErrMissingArgument is returned when required argument is not provided
+	ErrMissingArgument = errors.New("missing argument")
+	// ErrInvalidArgument is returned when argument value does not match its type
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrUnexpectedArgument is returned when more arguments than declared are provided
+	ErrUnexpectedArgument = errors.New("unexpected argument")
+)
+
+var (
+	mentionChannelRegex = regexp.MustCompile(`^<#([0-9]+)>$`)
+	mentionRoleRegex    = regexp.MustCompile(`^<@&([0-9]+)>$`)
+	mentionUserRegex    = regexp.MustCompile(`^<@!?([0-9]+)>$`)
+	snowflakeRegex      = regexp.MustCompile(`^[0-9]+$`)
+	colorRegex          = regexp.MustCompile(`^#?([0-9a-fA-F]{6})$`)
+)
+
+// ArgumentType describes kind of argument value
+type ArgumentType int
+
+// Known argument types
+const (
+	ArgumentString ArgumentType = iota
+	ArgumentInt
+	ArgumentURL
+	ArgumentDuration
+	ArgumentChannel
+	ArgumentRole
+	ArgumentUser
+	ArgumentSize
+	ArgumentColor
+	ArgumentFlag
+)
+
+// String returns human-readable type name
+func (t ArgumentType) String() string {
+	switch t {
+	case ArgumentInt:
+		return "number"
+	case ArgumentURL:
+		return "url"
+	case ArgumentDuration:
+		return "duration"
+	case ArgumentChannel:
+		return "#channel"
+	case ArgumentRole:
+		return "@role"
+	case ArgumentUser:
+		return "@user"
+	case ArgumentSize:
+		return "size"
+	case ArgumentColor:
+		return "#rrggbb"
+	case ArgumentFlag:
+		return "flag"
+	default:
+		return "text"
+	}
+}
+
+// Argument describes single route argument.
+//
+// Positional arguments are consumed in declaration order, named arguments are given as name:value in any position,
+// flags are given as bare name. Rest argument consumes all remaining positional arguments, named arguments and flags
+// following it are still parsed. Number arguments are bounded by MinValue, if set, and MaxValue, if not zero.
+type Argument struct {
+	Name         string
+	Description  string
+	Type         ArgumentType
+	Required     bool
+	Named        bool
+	Rest         bool
+	Autocomplete bool
+	Default      string
+	Choices      []string
+	MinValue     *float64
+	MaxValue     float64
+}
+
+// Values keeps parsed argument values
+type Values map[string]interface{}
+
+// Has returns true if argument was provided
+func (values Values) Has(name string) bool {
+	_, ok := values[name]
+
+	return ok
+}
+
+// String returns string, channel, role or user argument value
+func (values Values) String(name string) string {
+	switch v := values[name].(type) {
+	case string:
+		return v
+	case Args:
+		return v.Join(0)
+	}
+
+	return ""
+}
+
+// Args returns rest argument value
+func (values Values) Args(name string) Args {
+	v, _ := values[name].(Args)
+
+	return v
+}
+
+// Int returns integer argument value
+func (values Values) Int(name string) int64 {
+	v, _ := values[name].(int64)
+
+	return v
+}
+
+// URL returns url argument value
+func (values Values) URL(name string) *url.URL {
+	v, _ := values[name].(*url.URL)
+
+	return v
+}
+
+// Duration returns duration argument value
+func (values Values) Duration(name string) time.Duration {
+	v, _ := values[name].(time.Duration)
+
+	return v
+}
+
+// Size returns size argument value in bytes
+func (values Values) Size(name string) uint64 {
+	v, _ := values[name].(uint64)
+
+	return v
+}
+
+// Color returns color argument value as 0xRRGGBB
+func (values Values) Color(name string) int {
+	v, _ := values[name].(int)
+
+	return v
+}
+
+// Flag returns true if flag argument was given
+func (values Values) Flag(name string) bool {
+	v, _ := values[name].(bool)
+
+	return v
+}
+
+// SetArguments sets route argument schema, validated before handler is called
+func (route *Route) SetArguments(arguments ...*Argument) *Route {
+	route.Arguments = arguments
+
+	return route
+}
+
+// Usage renders route usage line from argument schema
+func (route *Route) Usage() string {
+	sb := &strings.Builder{}
+
+	_, _ = sb.WriteString(route.Name)
+
+	for _, arg := range route.Arguments {
+		_, _ = sb.WriteString(" ")
+		_, _ = sb.WriteString(arg.usage())
+	}
+
+	return sb.String()
+}
+
+func (arg *Argument) usage() string {
+	var s string
+
+	switch {
+	case arg.Type == ArgumentFlag:
+		return "[" + arg.Name + "]"
+	case len(arg.Choices) > 0:
+		s = strings.Join(arg.Choices, "|")
+	default:
+		s = arg.Name
+	}
+
+	if arg.Rest {
+		s += "..."
+	}
+
+	if arg.Named {
+		s = arg.Name + ":<" + s + ">"
+		if !arg.Required {
+			s = "[" + s + "]"
+		}
+
+		return s
+	}
+
+	if arg.Required {
+		return "<" + s + ">"
+	}
+
+	return "[" + s + "]"
+}
+
+func (route *Route) argument(name string) *Argument {
+	for _, arg := range route.Arguments {
+		if arg.Name == name {
+			return arg
+		}
+	}
+
+	return nil
+}
+
+// ParseArguments validates arguments against route schema
+func (route *Route) ParseArguments(args Args) (values Values, err error) {
+	values = make(Values)
+
+	var (
+		positional []*Argument
+		rest       *Argument
+	)
+
+	for _, arg := range route.Arguments {
+		if !arg.Named && arg.Type != ArgumentFlag {
+			positional = append(positional, arg)
+		}
+	}
+
+	for i := 1; i < len(args); i++ {
+		a := args[i]
+
+		if arg := route.argument(a); arg != nil && (arg.Type == ArgumentFlag || arg.Named) {
+			if arg.Type == ArgumentFlag {
+				values[arg.Name] = true
+
+				continue
+			}
+
+			if arg.Default != "" {
+				if values[arg.Name], err = route.parseArgument(arg, arg.Default); err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+		}
+
+		if idx := strings.Index(a, ":"); idx > 0 {
+			if arg := route.argument(a[:idx]); arg != nil && arg.Named {
+				raw := a[idx+1:]
+				if raw == "" {
+					raw = arg.Default
+				}
+
+				if values[arg.Name], err = route.parseArgument(arg, raw); err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+		}
+
+		if rest != nil {
+			values[rest.Name] = append(values.Args(rest.Name), a)
+
+			continue
+		}
+
+		if len(positional) == 0 {
+			return nil, fmt.Errorf("%w %s, usage: %s", ErrUnexpectedArgument, a, route.Usage())
+		}
+
+		arg := positional[0]
+		positional = positional[1:]
+
+		if arg.Rest {
+			rest = arg
+			values[arg.Name] = Args{a}
+
+			continue
+		}
+
+		if a == "" {
+			continue
+		}
+
+		if values[arg.Name], err = route.parseArgument(arg, a); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, arg := range route.Arguments {
+		if arg.Required && !values.Has(arg.Name) {
+			return nil, fmt.Errorf("%w %s, usage: %s", ErrMissingArgument, arg.Name, route.Usage())
+		}
+	}
+
+	return values, nil
+}
+
+func (route *Route) parseArgument(arg *Argument, raw string) (v interface{}, err error) {
+	if len(arg.Choices) > 0 {
+		var found bool
+
+		for _, c := range arg.Choices {
+			if c == raw {
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("%w %s: expected one of %s", ErrInvalidArgument, arg.Name, strings.Join(arg.Choices, ", "))
+		}
+	}
+
+	v, err = parseArgumentValue(arg.Type, raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: expected %s, usage: %s", ErrInvalidArgument, arg.Name, arg.Type, route.Usage())
+	}
+
+	if n, ok := v.(int64); ok && !arg.inRange(float64(n)) {
+		return nil, fmt.Errorf("%w %s: expected %s, usage: %s", ErrInvalidArgument, arg.Name, arg.bounds(), route.Usage())
+	}
+
+	return
+}
+
+// inRange returns true if number is within argument bounds
+func (arg *Argument) inRange(n float64) bool {
+	return (arg.MinValue == nil || n >= *arg.MinValue) && (arg.MaxValue == 0 || n <= arg.MaxValue)
+}
+
+// bounds describes argument bounds
+func (arg *Argument) bounds() string {
+	switch {
+	case arg.MinValue != nil && arg.MaxValue != 0:
+		return fmt.Sprintf("%v to %v", *arg.MinValue, arg.MaxValue)
+	case arg.MinValue != nil:
+		return fmt.Sprintf("at least %v", *arg.MinValue)
+	default:
+		return fmt.Sprintf("at most %v", arg.MaxValue)
+	}
+}
+
+func parseArgumentValue(t ArgumentType, raw string) (interface{}, error) {
+	switch t {
+	case ArgumentInt:
+		return strconv.ParseInt(raw, 10, 64)
+	case ArgumentURL:
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, strconv.ErrSyntax
+		}
+
+		return u, nil
+	case ArgumentDuration:
+		return time.ParseDuration(raw)
+	case ArgumentChannel:
+		return parseMention(mentionChannelRegex, raw)
+	case ArgumentRole:
+		return parseMention(mentionRoleRegex, raw)
+	case ArgumentUser:
+		return parseMention(mentionUserRegex, raw)
+	case ArgumentSize:
+		size := mediaservice.HumanSizeParse(raw)
+		if size == 0 {
+			return nil, strconv.ErrSyntax
+		}
+
+		return size, nil
+	case ArgumentColor:
+		parts := colorRegex.FindStringSubmatch(raw)
+		if len(parts) < 2 {
+			return nil, strconv.ErrSyntax
+		}
+
+		c, err := strconv.ParseInt(parts[1], 16, 32)
+
+		return int(c), err
+	case ArgumentFlag:
+		return true, nil
+	default:
+		return raw, nil
+	}
+}
+
+func parseMention(reg *regexp.Regexp, raw string) (string, error) {
+	if snowflakeRegex.MatchString(raw) {
+		return raw, nil
+	}
+
+	parts := reg.FindStringSubmatch(raw)
+	if len(parts) < 2 {
+		return "", strconv.ErrSyntax
+	}
+
+	return parts[1], nil
+}
+
+func (route *Route) middlewareArguments(handler HandlerFunc) HandlerFunc {
+	return func(ctx *Context) (err error) {
+		ctx.Values, err = route.ParseArguments(ctx.Args)
+		if err != nil {
+			return err
+		}
+
+		return handler(ctx)
+	}
+}
+
+// argumentOptions derives application command options from argument schema
+func argumentOptions(arguments []*Argument) (options []*discordgo.ApplicationCommandOption) {
+	for _, arg := range arguments {
+		option := &discordgo.ApplicationCommandOption{
+			Type:         discordgo.ApplicationCommandOptionString,
+			Name:         commandName(arg.Name),
+			Description:  commandDescription(arg.Description),
+			Required:     arg.Required,
+			Autocomplete: arg.Autocomplete,
+			MinValue:     arg.MinValue,
+			MaxValue:     arg.MaxValue,
+		}
+
+		switch arg.Type {
+		case ArgumentInt:
+			option.Type = discordgo.ApplicationCommandOptionInteger
+		case ArgumentChannel:
+			option.Type = discordgo.ApplicationCommandOptionChannel
+		case ArgumentRole:
+			option.Type = discordgo.ApplicationCommandOptionRole
+		case ArgumentUser:
+			option.Type = discordgo.ApplicationCommandOptionUser
+		case ArgumentFlag:
+			option.Type = discordgo.ApplicationCommandOptionBoolean
+		}
+
+		for _, c := range arg.Choices {
+			if len(option.Choices) == commandChoicesLimit {
+				break
+			}
+
+			option.Choices = append(option.Choices, &discordgo.ApplicationCommandOptionChoice{
+				Name:  c,
+				Value: c,
+			})
+		}
+
+		options = append(options, option)
+	}
+
+	sort.SliceStable(options, func(i, j int) bool {
+		return options[i].Required && !options[j].Required
+	})
+
+	return
+}
+
+// argumentArgs converts interaction options into text arguments according to argument schema
+func argumentArgs(route *Route, values map[string]*discordgo.ApplicationCommandInteractionDataOption) Args {
+	args := Args{route.Name}
+
+	var skipped int
+
+	for _, arg := range route.Arguments {
+		o, ok := values[commandName(arg.Name)]
+
+		switch {
+		case !ok && !arg.Named && arg.Type != ArgumentFlag:
+			skipped++
+		case !ok:
+		case arg.Type == ArgumentFlag:
+			if v, ok := o.Value.(bool); ok && v {
+				args = append(args, arg.Name)
+			}
+		case arg.Named:
+			args = append(args, arg.Name+":"+optionString(o))
+		case arg.Rest:
+			args = append(args, make(Args, skipped)...)
+			args = append(args, splitArgs(optionString(o))...)
+			skipped = 0
+		default:
+			args = append(args, make(Args, skipped)...)
+			args = append(args, optionString(o))
+			skipped = 0
+		}
+	}
+
+	return args
+}
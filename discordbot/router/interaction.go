@@ -0,0 +1,343 @@
+package router
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	commandNameLimit        = 32
+	commandDescriptionLimit = 100
+	commandChoicesLimit     = 25
+)
+
+// ApplicationCommands returns application command definitions for routes marked as commands.
+//
+// Route name is split on first dot into command and subcommand, i.e. "nico.download" becomes "/nico download",
+// while routes without dot become top-level commands. Definitions are built once, on first call or interaction,
+// so all command routes have to be registered before that.
+func (router *Router) ApplicationCommands() []*discordgo.ApplicationCommand {
+	router.commandsOnce.Do(func() {
+		router.definitions, router.commands = router.buildCommands()
+	})
+
+	return router.definitions
+}
+
+func (router *Router) buildCommands() (commands []*discordgo.ApplicationCommand, routes map[string]*Route) {
+	dm := false
+	index := make(map[string]*discordgo.ApplicationCommand)
+	bare := make(map[string]*Route)
+	seen := make(map[*Route]bool)
+
+	routes = make(map[string]*Route)
+
+	for _, g := range router.Groups {
+		for _, r := range g.Routes {
+			if !r.Command || seen[r] {
+				continue
+			}
+
+			seen[r] = true
+
+			command, sub := commandPath(r.Name)
+			if sub == "" {
+				bare[command] = r
+
+				continue
+			}
+
+			cmd, ok := index[command]
+			if !ok {
+				cmd = &discordgo.ApplicationCommand{
+					Name:         command,
+					Description:  commandDescription(g.Description),
+					DMPermission: &dm,
+				}
+				index[command] = cmd
+			}
+
+			cmd.Options = append(cmd.Options, &discordgo.ApplicationCommandOption{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        sub,
+				Description: commandDescription(r.Description),
+				Options:     r.commandOptions(),
+			})
+
+			routes[command+" "+sub] = r
+		}
+	}
+
+	for command, r := range bare {
+		if cmd, ok := index[command]; ok {
+			cmd.Options = append(cmd.Options, &discordgo.ApplicationCommandOption{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        command,
+				Description: commandDescription(r.Description),
+				Options:     r.commandOptions(),
+			})
+
+			routes[command+" "+command] = r
+
+			continue
+		}
+
+		index[command] = &discordgo.ApplicationCommand{
+			Name:         command,
+			Description:  commandDescription(r.Description),
+			Options:      r.commandOptions(),
+			DMPermission: &dm,
+		}
+
+		routes[command] = r
+	}
+
+	for _, cmd := range index {
+		commands = append(commands, cmd)
+	}
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+
+	return
+}
+
+func (route *Route) commandOptions() []*discordgo.ApplicationCommandOption {
+	if len(route.Options) == 0 {
+		return argumentOptions(route.Arguments)
+	}
+
+	return route.Options
+}
+
+// DispatchInteraction tries to find route matching application command interaction and execute it
+func (router *Router) DispatchInteraction(session *discordgo.Session, interaction *discordgo.Interaction) error {
+	switch interaction.Type {
+	case discordgo.InteractionApplicationCommand:
+		return router.dispatchCommand(session, interaction)
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		return router.dispatchAutocomplete(session, interaction)
+	}
+
+	return ErrNotMatched
+}
+
+func (router *Router) dispatchCommand(session *discordgo.Session, interaction *discordgo.Interaction) (err error) {
+	route, options := router.interactionRoute(interaction.ApplicationCommandData())
+	if route == nil {
+		return ErrNotMatched
+	}
+
+	err = session.InteractionRespond(interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		return
+	}
+
+	ctx := interactionContext(session, interaction, route, options)
+
+	err = route.bake()(ctx)
+
+	if ctx.response == nil {
+		derr := session.InteractionResponseDelete(interaction)
+		if err == nil {
+			err = derr
+		}
+	}
+
+	return
+}
+
+func (router *Router) dispatchAutocomplete(session *discordgo.Session, interaction *discordgo.Interaction) error {
+	route, options := router.interactionRoute(interaction.ApplicationCommandData())
+	if route == nil || route.Autocomplete == nil {
+		return ErrNotMatched
+	}
+
+	var focused *discordgo.ApplicationCommandInteractionDataOption
+
+	for _, o := range options {
+		if o.Focused {
+			focused = o
+		}
+	}
+
+	if focused == nil {
+		return ErrNotMatched
+	}
+
+	choices := route.Autocomplete(interactionContext(session, interaction, route, options), focused)
+	if len(choices) > commandChoicesLimit {
+		choices = choices[:commandChoicesLimit]
+	}
+
+	return session.InteractionRespond(interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+}
+
+func (router *Router) interactionRoute(
+	data discordgo.ApplicationCommandInteractionData,
+) (route *Route, options []*discordgo.ApplicationCommandInteractionDataOption) {
+	router.ApplicationCommands()
+
+	key := data.Name
+	options = data.Options
+
+	if len(options) == 1 && options[0].Type == discordgo.ApplicationCommandOptionSubCommand {
+		key += " " + options[0].Name
+		options = options[0].Options
+	}
+
+	return router.commands[key], options
+}
+
+func interactionContext(
+	session *discordgo.Session,
+	interaction *discordgo.Interaction,
+	route *Route,
+	options []*discordgo.ApplicationCommandInteractionDataOption,
+) *Context {
+	args := interactionArgs(route, options)
+
+	author := interaction.User
+	if interaction.Member != nil && interaction.Member.User != nil {
+		author = interaction.Member.User
+		interaction.Member.GuildID = interaction.GuildID
+	}
+
+	return &Context{
+		Session:     session,
+		Interaction: interaction,
+		Message: &discordgo.Message{
+			ID:        interaction.ID,
+			ChannelID: interaction.ChannelID,
+			GuildID:   interaction.GuildID,
+			Content:   args.Join(0),
+			Author:    author,
+			Member:    interaction.Member,
+		},
+		Route: route,
+		Args:  args,
+	}
+}
+
+// interactionArgs converts interaction options into text-like arguments in route options declaration order,
+// boolean options are passed as their name when set, omitted options followed by given ones are passed as empty
+// placeholders to keep positions
+func interactionArgs(route *Route, options []*discordgo.ApplicationCommandInteractionDataOption) Args {
+	values := make(map[string]*discordgo.ApplicationCommandInteractionDataOption)
+
+	for _, o := range options {
+		values[o.Name] = o
+	}
+
+	if len(route.Options) == 0 && len(route.Arguments) > 0 {
+		return argumentArgs(route, values)
+	}
+
+	args := Args{route.Name}
+
+	var skipped int
+
+	for _, decl := range route.Options {
+		o, ok := values[decl.Name]
+
+		switch {
+		case !ok && decl.Type == discordgo.ApplicationCommandOptionBoolean:
+		case decl.Type == discordgo.ApplicationCommandOptionBoolean:
+			if v, bok := o.Value.(bool); bok && v {
+				args = append(args, o.Name)
+			}
+		case !ok:
+			skipped++
+		default:
+			args = append(args, make(Args, skipped)...)
+			args = append(args, optionString(o))
+			skipped = 0
+		}
+	}
+
+	return args
+}
+
+func optionString(o *discordgo.ApplicationCommandInteractionDataOption) string {
+	switch o.Type {
+	case discordgo.ApplicationCommandOptionInteger:
+		if v, ok := o.Value.(float64); ok {
+			return strconv.FormatInt(int64(v), 10)
+		}
+	case discordgo.ApplicationCommandOptionNumber:
+		if v, ok := o.Value.(float64); ok {
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	case discordgo.ApplicationCommandOptionUser:
+		return "<@" + o.StringValue() + ">"
+	case discordgo.ApplicationCommandOptionChannel:
+		return "<#" + o.StringValue() + ">"
+	case discordgo.ApplicationCommandOptionRole:
+		return "<@&" + o.StringValue() + ">"
+	case discordgo.ApplicationCommandOptionBoolean:
+		return strconv.FormatBool(o.BoolValue())
+	default:
+		if v, ok := o.Value.(string); ok {
+			return v
+		}
+	}
+
+	return ""
+}
+
+func commandPath(name string) (command, sub string) {
+	parts := strings.SplitN(name, ".", 2)
+
+	command = commandName(parts[0])
+
+	if len(parts) > 1 {
+		sub = commandName(strings.ReplaceAll(parts[1], ".", "-"))
+	}
+
+	return
+}
+
+func commandName(name string) string {
+	var sb strings.Builder
+
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '-', r == '_':
+			_, _ = sb.WriteRune(r)
+		default:
+			_, _ = sb.WriteRune('-')
+		}
+	}
+
+	runes := []rune(sb.String())
+	if len(runes) > commandNameLimit {
+		runes = runes[:commandNameLimit]
+	}
+
+	return string(runes)
+}
+
+func commandDescription(desc string) string {
+	if desc == "" {
+		return "-"
+	}
+
+	runes := []rune(desc)
+	if len(runes) > commandDescriptionLimit {
+		return string(runes[:commandDescriptionLimit-1]) + "…"
+	}
+
+	return desc
+}
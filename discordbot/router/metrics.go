@@ -0,0 +1,51 @@
+package router
+
+import (
+	"errors"
+	"time"
+
+	"github.com/eientei/jaroid/util/metrics"
+)
+
+var (
+	metricDispatches = metrics.NewCounter(
+		"jaroid_router_dispatches_total",
+		"Number of handled route dispatches by error kind",
+		"route", "error",
+	)
+	metricDispatchDuration = metrics.NewHistogram(
+		"jaroid_router_dispatch_duration_seconds",
+		"Duration of route handling",
+		metrics.DefaultBuckets,
+		"route",
+	)
+)
+
+// errorLabel maps error to low-cardinality metric label value
+func errorLabel(err error) string {
+	switch {
+	case err == nil:
+		return "none"
+	case errors.Is(err, ErrMissingArgument):
+		return "missing_argument"
+	case errors.Is(err, ErrInvalidArgument):
+		return "invalid_argument"
+	case errors.Is(err, ErrUnexpectedArgument):
+		return "unexpected_argument"
+	default:
+		return "other"
+	}
+}
+
+func (route *Route) middlewareMetrics(handler HandlerFunc) HandlerFunc {
+	return func(ctx *Context) error {
+		start := time.Now()
+
+		err := handler(ctx)
+
+		metricDispatchDuration.Since(start, route.Name)
+		metricDispatches.Inc(route.Name, errorLabel(err))
+
+		return err
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/bwmarrin/discordgo"
 )
@@ -22,6 +23,9 @@ type Router struct {
 	GroupSorter        GroupSorterFunc
 	DefaultRouteSorter RouteSorterFunc
 	Middleware         []MiddlewareFunc
+	commands           map[string]*Route
+	definitions        []*discordgo.ApplicationCommand
+	commandsOnce       sync.Once
 }
 
 // Dispatch tries to find matching route and execute it
@@ -72,11 +76,7 @@ func (router *Router) dispatch(
 
 	raw = strings.TrimPrefix(raw, prefix)
 
-	reader := csv.NewReader(strings.NewReader(raw))
-	reader.Comma = ' '
-	reader.TrimLeadingSpace = true
-
-	args, err := reader.Read()
+	args, err := parseArgs(raw)
 	if err != nil {
 		return false, err
 	}
@@ -89,24 +89,7 @@ func (router *Router) dispatch(
 
 			matched = true
 
-			if r.Baked == nil {
-				var middlewares []MiddlewareFunc
-
-				middlewares = append(middlewares, router.Middleware...)
-
-				for _, g := range r.Groups {
-					middlewares = append(middlewares, g.Middleware...)
-				}
-
-				middlewares = append(middlewares, r.Middleware...)
-
-				r.Baked = r.Handler
-				for i := len(middlewares) - 1; i >= 0; i-- {
-					r.Baked = middlewares[i](r.Baked)
-				}
-			}
-
-			err = r.Baked(&Context{
+			err = r.bake()(&Context{
 				Session: session,
 				Message: msg,
 				Route:   r,
@@ -120,6 +103,54 @@ func (router *Router) dispatch(
 	return
 }
 
+func parseArgs(raw string) (Args, error) {
+	reader := csv.NewReader(strings.NewReader(raw))
+	reader.Comma = ' '
+	reader.TrimLeadingSpace = true
+
+	return reader.Read()
+}
+
+// splitArgs splits raw string the same way as text commands are, falling back to whitespace split
+func splitArgs(raw string) Args {
+	args, err := parseArgs(raw)
+	if err != nil {
+		return strings.Fields(raw)
+	}
+
+	return args
+}
+
+func (route *Route) bake() HandlerFunc {
+	if route.Baked != nil {
+		return route.Baked
+	}
+
+	var middlewares []MiddlewareFunc
+
+	middlewares = append(middlewares, route.Router.Middleware...)
+
+	for _, g := range route.Groups {
+		middlewares = append(middlewares, g.Middleware...)
+	}
+
+	middlewares = append(middlewares, route.Middleware...)
+
+	route.Baked = route.Handler
+
+	if len(route.Arguments) > 0 {
+		route.Baked = route.middlewareArguments(route.Baked)
+	}
+
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		route.Baked = middlewares[i](route.Baked)
+	}
+
+	route.Baked = route.middlewareMetrics(route.Baked)
+
+	return route.Baked
+}
+
 func checkExclude(excludegroups []string, only string, r *Route) bool {
 	if only != "" {
 		var matched bool
@@ -55,6 +55,7 @@ type Mediaservice struct {
 	Auth      MediaserviceAuth `yaml:"auth"`
 	SaveDir   string           `yaml:"save_dir"`
 	CookieJar string           `yaml:"cookie_jar"`
+	FFmpeg    string           `yaml:"ffmpeg"`
 	KeepFiles bool             `yaml:"keep_files"`
 }
 
@@ -12,10 +12,25 @@ import (
 	"path"
 
 	"github.com/eientei/jaroid/fedipost"
+	"github.com/eientei/jaroid/util/metrics"
+)
+
+var metricUploads = metrics.NewCounter(
+	"jaroid_fedipost_uploads_total",
+	"Number of media uploads by result",
+	"result",
 )
 
 // UploadFile returns media id for provided file path
-func UploadFile(config *fedipost.Config, filepath string) (string, error) {
+func UploadFile(config *fedipost.Config, filepath string) (id string, err error) {
+	defer func() {
+		if err != nil {
+			metricUploads.Inc("error")
+		} else {
+			metricUploads.Inc("ok")
+		}
+	}()
+
 	f, err := os.Open(filepath)
 	if err != nil {
 		return "", err
@@ -9,6 +9,7 @@ import (
 	"regexp"
 
 	"github.com/eientei/jaroid/fedipost"
+	"github.com/eientei/jaroid/util/metrics"
 )
 
 // CreateStatus represents fediverse status (post) creation parameters
@@ -29,16 +30,30 @@ type CreatedStatus struct {
 
 var symregex = regexp.MustCompile(`[^\pL\pN_]`)
 
+var metricPosts = metrics.NewCounter(
+	"jaroid_fedipost_posts_total",
+	"Number of created statuses by result",
+	"result",
+)
+
 // MakeTag returns corrsponding tag for porvided string
 func MakeTag(s string) string {
 	return "#" + symregex.ReplaceAllString(s, "")
 }
 
 // Create creates a new status
-func Create(config *fedipost.Config, status *CreateStatus) (*CreatedStatus, error) {
+func Create(config *fedipost.Config, status *CreateStatus) (created *CreatedStatus, err error) {
+	defer func() {
+		if err != nil {
+			metricPosts.Inc("error")
+		} else {
+			metricPosts.Inc("ok")
+		}
+	}()
+
 	var b bytes.Buffer
 
-	err := json.NewEncoder(&b).Encode(status)
+	err = json.NewEncoder(&b).Encode(status)
 	if err != nil {
 		return nil, err
 	}
@@ -59,7 +74,7 @@ func Create(config *fedipost.Config, status *CreateStatus) (*CreatedStatus, erro
 		_ = resp.Body.Close()
 	}()
 
-	created := &CreatedStatus{}
+	created = &CreatedStatus{}
 
 	err = json.NewDecoder(resp.Body).Decode(created)
 	if err != nil {
@@ -0,0 +1,307 @@
+// Package ffmpeg provides size-constrained re-encoding of downloaded media using system ffmpeg
+package ffmpeg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eientei/jaroid/mediaservice"
+)
+
+const (
+	// DefaultExecutable is ffmpeg executable looked up in PATH
+	DefaultExecutable = "ffmpeg"
+
+	// maxAudioBitrate is audio bitrate used when total bitrate allows
+	maxAudioBitrate = 128000
+
+	// minVideoBitrate is lowest video bitrate still worth encoding
+	minVideoBitrate = 64000
+
+	// attempts of re-encoding with lowered bitrate when output still exceeds the limit
+	attempts = 3
+
+	// overhead fraction of target size reserved for container
+	overhead = 0.05
+)
+
+var (
+	// ErrBitrateTooLow is returned when target size is too small for media duration
+	ErrBitrateTooLow = errors.New("target size is too small for media duration")
+
+	// ErrUnknownDuration is returned when media duration could not be determined
+	ErrUnknownDuration = errors.New("unknown media duration")
+
+	// ErrTooLarge is returned when re-encoded media still exceeds target size
+	ErrTooLarge = errors.New("re-encoded media exceeds target size")
+
+	durationRegexp = regexp.MustCompile(`Duration:\s*(\d+):(\d+):(\d+(\.\d+)?)`)
+)
+
+// Transcoder re-encodes media files using ffmpeg executable
+type Transcoder struct {
+	ExecutablePath string
+	Args           []string
+}
+
+func (t *Transcoder) executable() string {
+	if t.ExecutablePath == "" {
+		return DefaultExecutable
+	}
+
+	return t.ExecutablePath
+}
+
+// Probe returns duration of media file
+func (t *Transcoder) Probe(ctx context.Context, input string) (dur time.Duration, err error) {
+	cmd := exec.CommandContext(ctx, t.executable(), "-hide_banner", "-i", input)
+
+	// ffmpeg exits with error without output file, media info is printed to stderr regardless
+	out, _ := cmd.CombinedOutput()
+
+	parts := durationRegexp.FindSubmatch(out)
+	if parts == nil {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownDuration, input)
+	}
+
+	hours, _ := strconv.ParseInt(string(parts[1]), 10, 64)
+	minutes, _ := strconv.ParseInt(string(parts[2]), 10, 64)
+	seconds, _ := strconv.ParseFloat(string(parts[3]), 64)
+
+	dur = time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds*float64(time.Second))
+
+	if dur <= 0 {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownDuration, input)
+	}
+
+	return dur, nil
+}
+
+// bitrates splits total bitrate fitting size over duration between video and audio
+func bitrates(size uint64, dur time.Duration) (video, audio uint64, err error) {
+	total := uint64(float64(size) * 8 * (1 - overhead) / dur.Seconds())
+
+	audio = total / 4
+	if audio > maxAudioBitrate {
+		audio = maxAudioBitrate
+	}
+
+	if total < audio+minVideoBitrate {
+		return 0, 0, fmt.Errorf(
+			"%w: %s for %s",
+			ErrBitrateTooLow,
+			strings.TrimSpace(mediaservice.HumanSizeFormat(float64(size))),
+			dur.String(),
+		)
+	}
+
+	return total - audio, audio, nil
+}
+
+// FitSize re-encodes input media into output file in mp4 format no larger than size bytes, bitrate is calculated
+// from duration, which is probed from input when zero. Encoding is retried with lowered bitrate if output still
+// exceeds the size.
+func (t *Transcoder) FitSize(
+	ctx context.Context,
+	input, output string,
+	size uint64,
+	dur time.Duration,
+	reporter mediaservice.Reporter,
+) (err error) {
+	if reporter == nil {
+		reporter = mediaservice.NewDummyReporter()
+	}
+
+	if dur <= 0 {
+		dur, err = t.Probe(ctx, input)
+		if err != nil {
+			return err
+		}
+	}
+
+	target := size
+
+	for i := 0; i < attempts; i++ {
+		var vbitrate, abitrate uint64
+
+		vbitrate, abitrate, err = bitrates(target, dur)
+		if err != nil {
+			return err
+		}
+
+		err = t.encode(ctx, input, output, vbitrate, abitrate, dur, reporter)
+		if err != nil {
+			return err
+		}
+
+		var finfo os.FileInfo
+
+		finfo, err = os.Stat(output)
+		if err != nil {
+			return err
+		}
+
+		if uint64(finfo.Size()) <= size {
+			return nil
+		}
+
+		target = uint64(float64(target) * float64(size) / float64(finfo.Size()))
+	}
+
+	return fmt.Errorf("%w: %s", ErrTooLarge, strings.TrimSpace(mediaservice.HumanSizeFormat(float64(size))))
+}
+
+func (t *Transcoder) encode(
+	ctx context.Context,
+	input, output string,
+	vbitrate, abitrate uint64,
+	dur time.Duration,
+	reporter mediaservice.Reporter,
+) (err error) {
+	vrate := strconv.FormatUint(vbitrate, 10)
+
+	args := []string{"-hide_banner", "-y", "-i", input}
+	args = append(args, t.Args...)
+	args = append(args,
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-b:v", vrate,
+		"-maxrate", vrate,
+		"-bufsize", strconv.FormatUint(vbitrate*2, 10),
+		"-c:a", "aac",
+		"-b:a", strconv.FormatUint(abitrate, 10),
+		"-movflags", "+faststart",
+		"-f", "mp4",
+		"-progress", "pipe:1",
+		"-nostats",
+		output,
+	)
+
+	cmd := exec.CommandContext(ctx, t.executable(), args...)
+
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	note := fmt.Sprintf(
+		"video %dk audio %dk",
+		vbitrate/1000,
+		abitrate/1000,
+	)
+
+	scanner := bufio.NewScanner(stdout)
+
+	for scanner.Scan() {
+		reportProgress(reporter, scanner.Text(), dur, note)
+	}
+
+	err = cmd.Wait()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return fmt.Errorf("%w: %s", err, lastLine(stderr.String()))
+	}
+
+	return nil
+}
+
+// reportProgress submits encoding progress from ffmpeg -progress output line
+func reportProgress(reporter mediaservice.Reporter, line string, dur time.Duration, note string) {
+	parts := strings.SplitN(line, "=", 2)
+	if len(parts) != 2 {
+		return
+	}
+
+	switch parts[0] {
+	case "out_time_us", "out_time_ms":
+		// out_time_ms is also reported in microseconds
+		us, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || us < 0 {
+			return
+		}
+
+		percent := float64(time.Duration(us)*time.Microsecond) / float64(dur) * 100
+		if percent > 100 {
+			percent = 100
+		}
+
+		reporter.Submit(fmt.Sprintf("Re-encoding %2.1f%% (%s)", percent, note), false)
+	case "progress":
+		if parts[1] == "end" {
+			reporter.Submit(fmt.Sprintf("Re-encoding 100.0%% (%s)", note), true)
+		}
+	}
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// Fit re-encodes file downloaded with given format id selector to fit its size constraint, if selector allows
+// falling back to formats exceeding the constraint (e.g. 50m!) and the file is larger than that. Selectors without
+// size constraint leave the file as is. Returns path of resulting mp4 file, which replaces the original.
+func (t *Transcoder) Fit(
+	ctx context.Context,
+	formatID, fname string,
+	reporter mediaservice.Reporter,
+) (string, error) {
+	size, wildcard, _, err := mediaservice.ParseFormatSize(formatID)
+	if err != nil || !wildcard {
+		// exact format selectors, e.g. 22 or bv+ba of yt-dlp, are not size constraints
+		return fname, nil
+	}
+
+	finfo, err := os.Stat(fname)
+	if err != nil {
+		return "", err
+	}
+
+	if uint64(finfo.Size()) <= size {
+		return fname, nil
+	}
+
+	base := strings.TrimSuffix(fname, filepath.Ext(fname))
+	output, tmp := base+".mp4", base+".fit.part"
+
+	err = t.FitSize(ctx, fname, tmp, size, 0, reporter)
+	if err != nil {
+		_ = os.Remove(tmp)
+
+		return "", err
+	}
+
+	err = os.Rename(tmp, output)
+	if err != nil {
+		return "", err
+	}
+
+	if output != fname {
+		_ = os.Remove(fname)
+	}
+
+	return output, nil
+}
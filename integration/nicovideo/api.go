@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -19,6 +20,9 @@ const (
 	loginURI     = "https://account.nicovideo.jp/api/v1/login"
 )
 
+// URLPattern matches nicovideo video page URLs
+var URLPattern = regexp.MustCompile(`^https?://(www\.)?nicovideo\.jp/(.*/)?[sn]m[0-9]*$`)
+
 // Auth provides nicovideo credentials to log in with
 type Auth struct {
 	Username string
@@ -85,6 +89,15 @@ func (client *Client) openCopyFile(f *os.File, outpath, fmtname string, reuse bo
 		return nil
 	}
 
+	finfo, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if finfo.Size() > 0 {
+		return nil
+	}
+
 	parts := strings.SplitN(filepath.Base(outpath), "-", 2)
 
 	matches, err := filepath.Glob(filepath.Join(filepath.Dir(outpath), parts[0]+"-*-"+fmtname+"*"))
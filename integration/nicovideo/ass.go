@@ -0,0 +1,374 @@
+package nicovideo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	commentPositionScroll = iota
+	commentPositionTop
+	commentPositionBottom
+)
+
+// ngScoreThreshold hides comments disliked by other viewers, same as default nicovideo player filter
+const ngScoreThreshold = -4800
+
+// commentColors maps nicovideo color commands to RGB colors
+var commentColors = map[string]uint32{
+	"white":          0xFFFFFF,
+	"red":            0xFF0000,
+	"pink":           0xFF8080,
+	"orange":         0xFFC000,
+	"yellow":         0xFFFF00,
+	"green":          0x00FF00,
+	"cyan":           0x00FFFF,
+	"blue":           0x0000FF,
+	"purple":         0xC000FF,
+	"black":          0x000000,
+	"white2":         0xCCCC99,
+	"niconicowhite":  0xCCCC99,
+	"red2":           0xCC0033,
+	"truered":        0xCC0033,
+	"pink2":          0xFF33CC,
+	"orange2":        0xFF6600,
+	"passionorange":  0xFF6600,
+	"yellow2":        0x999900,
+	"madyellow":      0x999900,
+	"green2":         0x00CC66,
+	"elementalgreen": 0x00CC66,
+	"cyan2":          0x00CCCC,
+	"blue2":          0x3399FF,
+	"marineblue":     0x3399FF,
+	"purple2":        0x6633CC,
+	"nobleviolet":    0x6633CC,
+	"black2":         0x666666,
+}
+
+// ASSOptions provides comment rendering options, zero values are replaced with defaults
+type ASSOptions struct {
+	Font           string
+	Width          int
+	Height         int
+	FontSize       int
+	ScrollDuration time.Duration
+	FixedDuration  time.Duration
+}
+
+func (opts *ASSOptions) defaults() *ASSOptions {
+	res := ASSOptions{}
+
+	if opts != nil {
+		res = *opts
+	}
+
+	if res.Font == "" {
+		res.Font = "sans-serif"
+	}
+
+	if res.Width == 0 {
+		res.Width = 1280
+	}
+
+	if res.Height == 0 {
+		res.Height = 720
+	}
+
+	if res.FontSize == 0 {
+		res.FontSize = res.Height / 13
+	}
+
+	if res.ScrollDuration == 0 {
+		res.ScrollDuration = time.Second * 4
+	}
+
+	if res.FixedDuration == 0 {
+		res.FixedDuration = time.Second * 3
+	}
+
+	return &res
+}
+
+type assComment struct {
+	text     string
+	start    time.Duration
+	end      time.Duration
+	color    uint32
+	size     int
+	width    int
+	height   int
+	position int
+}
+
+type assRow struct {
+	start time.Duration
+	end   time.Duration
+	width int
+}
+
+type assLayout struct {
+	opts      *ASSOptions
+	rows      [3][]assRow
+	rowheight int
+	height    int
+}
+
+func parseCommentColor(s string) (color uint32, ok bool) {
+	if color, ok = commentColors[s]; ok {
+		return
+	}
+
+	if len(s) == 7 && s[0] == '#' {
+		_, err := fmt.Sscanf(s[1:], "%06x", &color)
+
+		return color, err == nil
+	}
+
+	return 0, false
+}
+
+func commentWidth(line string, size int) (width int) {
+	for _, r := range line {
+		switch {
+		case r < 0x80, unicode.Is(unicode.Mn, r):
+			width += size / 2
+		case r >= 0xFF61 && r <= 0xFFDC:
+			width += size / 2
+		default:
+			width += size
+		}
+	}
+
+	return
+}
+
+func escapeASS(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\u200b")
+	s = strings.ReplaceAll(s, "{", "\\{")
+	s = strings.ReplaceAll(s, "}", "\\}")
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+
+	return strings.ReplaceAll(s, "\n", "\\N")
+}
+
+// newASSComment applies comment commands, returns nil for comments that should not be displayed
+func newASSComment(c *Comment, opts *ASSOptions) *assComment {
+	if c.Score <= ngScoreThreshold || strings.TrimSpace(c.Body) == "" {
+		return nil
+	}
+
+	res := &assComment{
+		color: 0xFFFFFF,
+		size:  opts.FontSize,
+	}
+
+	for _, cmd := range c.Commands {
+		switch cmd {
+		case "invisible":
+			return nil
+		case "ue":
+			res.position = commentPositionTop
+		case "shita":
+			res.position = commentPositionBottom
+		case "naka":
+			res.position = commentPositionScroll
+		case "big":
+			res.size = opts.FontSize * 3 / 2
+		case "small":
+			res.size = opts.FontSize * 2 / 3
+		default:
+			if color, ok := parseCommentColor(cmd); ok {
+				res.color = color
+			}
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(c.Body, "\r\n", "\n"), "\n")
+
+	for _, l := range lines {
+		if w := commentWidth(l, res.size); w > res.width {
+			res.width = w
+		}
+	}
+
+	res.height = len(lines) * res.size
+	res.text = escapeASS(c.Body)
+	res.start = time.Duration(c.VposMs) * time.Millisecond
+
+	if res.position == commentPositionScroll {
+		res.start -= time.Second
+		if res.start < 0 {
+			res.start = 0
+		}
+
+		res.end = res.start + opts.ScrollDuration
+	} else {
+		res.end = res.start + opts.FixedDuration
+	}
+
+	return res
+}
+
+// fits reports whether comment can be placed after another one in the same row without overlapping
+func (layout *assLayout) fits(row assRow, c *assComment) bool {
+	if c.position != commentPositionScroll {
+		return row.end <= c.start
+	}
+
+	if row.end <= c.start {
+		return true
+	}
+
+	w := float64(layout.opts.Width)
+	dur := float64(layout.opts.ScrollDuration)
+
+	// previous comment has to be fully visible and new comment must not reach left edge before previous one leaves
+	entered := row.start + time.Duration(float64(row.width)*dur/(w+float64(row.width)))
+	leaves := c.start + time.Duration(w*dur/(w+float64(c.width)))
+
+	return entered <= c.start && leaves >= row.end
+}
+
+// place returns vertical offset of comment, reusing rows freed by earlier comments
+func (layout *assLayout) place(c *assComment) int {
+	rows := layout.rows[c.position]
+	span := (c.height + layout.rowheight - 1) / layout.rowheight
+	if span < 1 {
+		span = 1
+	}
+
+	best, bestEnd := 0, time.Duration(-1)
+
+	for i := 0; i+span <= len(rows); i++ {
+		free := true
+		end := time.Duration(0)
+
+		for j := i; j < i+span; j++ {
+			if !layout.fits(rows[j], c) {
+				free = false
+			}
+
+			if rows[j].end > end {
+				end = rows[j].end
+			}
+		}
+
+		if free {
+			best = i
+
+			break
+		}
+
+		if bestEnd < 0 || end < bestEnd {
+			best, bestEnd = i, end
+		}
+	}
+
+	for j := best; j < best+span && j < len(rows); j++ {
+		rows[j] = assRow{start: c.start, end: c.end, width: c.width}
+	}
+
+	return best * layout.rowheight
+}
+
+func formatASSTime(d time.Duration) string {
+	cs := d.Milliseconds() / 10
+
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+func assColor(color uint32) string {
+	return fmt.Sprintf("&H%02X%02X%02X&", color&0xFF, color>>8&0xFF, color>>16&0xFF)
+}
+
+func (layout *assLayout) dialogue(c *assComment) string {
+	y := layout.place(c)
+	sb := &strings.Builder{}
+
+	_, _ = sb.WriteString("{")
+
+	switch c.position {
+	case commentPositionTop:
+		_, _ = fmt.Fprintf(sb, "\\an8\\pos(%d,%d)", layout.opts.Width/2, y)
+	case commentPositionBottom:
+		_, _ = fmt.Fprintf(sb, "\\an2\\pos(%d,%d)", layout.opts.Width/2, layout.height-y)
+	default:
+		_, _ = fmt.Fprintf(sb, "\\an7\\move(%d,%d,%d,%d)", layout.opts.Width, y, -c.width, y)
+	}
+
+	if c.size != layout.opts.FontSize {
+		_, _ = fmt.Fprintf(sb, "\\fs%d", c.size)
+	}
+
+	if c.color != 0xFFFFFF {
+		_, _ = sb.WriteString("\\c" + assColor(c.color))
+	}
+
+	// dark comments are outlined in white to remain readable
+	if c.color>>16&0xFF+c.color>>8&0xFF+c.color&0xFF < 0x60 {
+		_, _ = sb.WriteString("\\3c&HFFFFFF&")
+	}
+
+	_, _ = sb.WriteString("}")
+	_, _ = sb.WriteString(c.text)
+
+	return fmt.Sprintf(
+		"Dialogue: %d,%s,%s,Default,,0,0,0,,%s\n",
+		c.position,
+		formatASSTime(c.start),
+		formatASSTime(c.end),
+		sb.String(),
+	)
+}
+
+// WriteASS renders comments as Advanced SubStation Alpha subtitles, with scrolling, top and bottom comments
+// honoring position, size and color commands
+func WriteASS(w io.Writer, comments []*Comment, opts *ASSOptions) error {
+	opts = opts.defaults()
+
+	bw := bufio.NewWriter(w)
+
+	_, _ = fmt.Fprintf(bw, `[Script Info]
+ScriptType: v4.00+
+PlayResX: %d
+PlayResY: %d
+WrapStyle: 2
+ScaledBorderAndShadow: yes
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, `+
+		`Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, `+
+		`MarginL, MarginR, MarginV, Encoding
+Style: Default,%s,%d,&H33FFFFFF,&H33FFFFFF,&H33000000,&H33000000,1,0,0,0,100,100,0,0,1,2,0,7,0,0,0,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`, opts.Width, opts.Height, opts.Font, opts.FontSize)
+
+	rowheight := opts.FontSize * 2 / 3
+
+	layout := &assLayout{
+		opts:      opts,
+		rowheight: rowheight,
+		height:    opts.Height,
+	}
+
+	for i := range layout.rows {
+		layout.rows[i] = make([]assRow, opts.Height/rowheight)
+	}
+
+	for _, c := range comments {
+		ac := newASSComment(c, opts)
+		if ac == nil {
+			continue
+		}
+
+		_, _ = bw.WriteString(layout.dialogue(ac))
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,301 @@
+package nicovideo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eientei/jaroid/mediaservice"
+	"github.com/eientei/jaroid/mediaservice/remux"
+)
+
+// maxCueLines limits number of simultaneously displayed comments in muxed subtitles
+const maxCueLines = 5
+
+// commentLanguages maps subtitle language codes to comment thread languages
+var commentLanguages = map[string]string{
+	"jpn": "ja-jp",
+	"ja":  "ja-jp",
+	"eng": "en-us",
+	"en":  "en-us",
+	"zho": "zh-tw",
+	"chi": "zh-tw",
+	"zh":  "zh-tw",
+}
+
+// Comment represents single comment of comment thread
+type Comment struct {
+	ID       string   `json:"id"`
+	Body     string   `json:"body"`
+	UserID   string   `json:"userId"`
+	PostedAt string   `json:"postedAt"`
+	Fork     string   `json:"-"`
+	Commands []string `json:"commands"`
+	No       int64    `json:"no"`
+	VposMs   int64    `json:"vposMs"`
+	Score    int64    `json:"score"`
+}
+
+// CommentThread represents comment thread of a video
+type CommentThread struct {
+	ID           string     `json:"id"`
+	Fork         string     `json:"fork"`
+	Comments     []*Comment `json:"comments"`
+	CommentCount int64      `json:"commentCount"`
+}
+
+type commentRequest struct {
+	Additionals map[string]interface{} `json:"additionals"`
+	ThreadKey   string                 `json:"threadKey"`
+	Params      APIDataNvCommentParams `json:"params"`
+}
+
+type commentResponse struct {
+	Meta struct {
+		ErrorCode string `json:"errorCode"`
+		Status    int    `json:"status"`
+	} `json:"meta"`
+	Data struct {
+		Threads []*CommentThread `json:"threads"`
+	} `json:"data"`
+}
+
+// CommentLanguage returns comment thread language for given subtitle language code, or empty string for default
+func CommentLanguage(lang string) string {
+	lang = strings.ToLower(lang)
+
+	if l, ok := commentLanguages[lang]; ok {
+		return l
+	}
+
+	if strings.Contains(lang, "-") {
+		return lang
+	}
+
+	return ""
+}
+
+// CommentsFilepath returns subtitle file path for given video file path and language
+func CommentsFilepath(outpath, lang string) string {
+	return strings.TrimSuffix(outpath, filepath.Ext(outpath)) + "." + lang + ".ass"
+}
+
+// Comments fetches comment threads of a video in given language, empty language selects video default
+func (client *Client) Comments(
+	ctx context.Context,
+	data *APIData,
+	language string,
+) (threads []*CommentThread, err error) {
+	nv := data.Comment.NvComment
+
+	if nv.Server == "" || nv.ThreadKey == "" {
+		return nil, fmt.Errorf("no comment threads")
+	}
+
+	req := &commentRequest{
+		Additionals: map[string]interface{}{},
+		ThreadKey:   nv.ThreadKey,
+		Params:      nv.Params,
+	}
+
+	if language != "" {
+		req.Params.Language = language
+	}
+
+	bs, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	h := make(http.Header)
+	h.Set("content-type", "application/json")
+	h.Set("x-frontend-id", "6")
+	h.Set("x-frontend-version", "0")
+
+	bs, err = client.postPage(ctx, strings.TrimSuffix(nv.Server, "/")+"/v1/threads", bytes.NewReader(bs), h)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp commentResponse
+
+	err = json.Unmarshal(bs, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Meta.Status/100 != 2 {
+		return nil, fmt.Errorf("comment threads: %d %s", resp.Meta.Status, resp.Meta.ErrorCode)
+	}
+
+	for _, t := range resp.Data.Threads {
+		for _, c := range t.Comments {
+			c.Fork = t.Fork
+		}
+	}
+
+	return resp.Data.Threads, nil
+}
+
+// MergeComments returns comments of all threads ordered by playback position
+func MergeComments(threads []*CommentThread) (comments []*Comment) {
+	for _, t := range threads {
+		comments = append(comments, t.Comments...)
+	}
+
+	sort.SliceStable(comments, func(i, j int) bool {
+		if comments[i].VposMs == comments[j].VposMs {
+			return comments[i].No < comments[j].No
+		}
+
+		return comments[i].VposMs < comments[j].VposMs
+	})
+
+	return
+}
+
+// saveComments renders comments in each of requested languages as ASS subtitles next to outpath,
+// returning them as timed text tracks as well. Languages failing to download or render are reported and skipped,
+// as video is still usable without subtitles.
+func (client *Client) saveComments(
+	ctx context.Context,
+	data *APIData,
+	outpath string,
+	langs []string,
+	reporter mediaservice.Reporter,
+) (tracks []*remux.SubtitleTrack) {
+	for _, lang := range langs {
+		reporter.Submit("Downloading comments...", false)
+
+		track, err := client.saveComment(ctx, data, outpath, lang)
+		if err != nil {
+			_ = os.Remove(CommentsFilepath(outpath, lang))
+
+			reporter.Submit("comments error, skipping "+lang+" subtitles: "+err.Error(), true)
+
+			continue
+		}
+
+		tracks = append(tracks, track)
+	}
+
+	return tracks
+}
+
+// saveComment renders comments in language as ASS subtitles next to outpath
+func (client *Client) saveComment(
+	ctx context.Context,
+	data *APIData,
+	outpath, lang string,
+) (*remux.SubtitleTrack, error) {
+	threads, err := client.Comments(ctx, data, CommentLanguage(lang))
+	if err != nil {
+		return nil, err
+	}
+
+	comments := MergeComments(threads)
+
+	f, err := os.Create(CommentsFilepath(outpath, lang))
+	if err != nil {
+		return nil, err
+	}
+
+	err = WriteASS(f, comments, nil)
+
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return CommentsSubtitleTrack(comments, lang), nil
+}
+
+// CommentsSubtitleTrack converts comments to timed text track, showing most recent comments at any given time
+func CommentsSubtitleTrack(comments []*Comment, language string) *remux.SubtitleTrack {
+	opts := (*ASSOptions)(nil).defaults()
+
+	var items []*assComment
+
+	for _, c := range comments {
+		if ac := newASSComment(c, opts); ac != nil {
+			ac.text = strings.ReplaceAll(c.Body, "\r\n", "\n")
+			items = append(items, ac)
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].start < items[j].start
+	})
+
+	bounds := make([]time.Duration, 0, len(items)*2)
+
+	for _, c := range items {
+		bounds = append(bounds, c.start, c.end)
+	}
+
+	sort.Slice(bounds, func(i, j int) bool {
+		return bounds[i] < bounds[j]
+	})
+
+	track := &remux.SubtitleTrack{
+		Language: language,
+	}
+
+	var active []*assComment
+
+	next := 0
+
+	for i := 0; i+1 < len(bounds); i++ {
+		start, end := bounds[i], bounds[i+1]
+		if start == end {
+			continue
+		}
+
+		for ; next < len(items) && items[next].start <= start; next++ {
+			active = append(active, items[next])
+		}
+
+		visible := active[:0]
+
+		for _, c := range active {
+			if c.end > start {
+				visible = append(visible, c)
+			}
+		}
+
+		active = visible
+
+		if len(active) == 0 {
+			continue
+		}
+
+		lines := make([]string, 0, maxCueLines)
+
+		for j := len(active) - 1; j >= 0 && len(lines) < maxCueLines; j-- {
+			lines = append(lines, active[j].text)
+		}
+
+		for l, r := 0, len(lines)-1; l < r; l, r = l+1, r-1 {
+			lines[l], lines[r] = lines[r], lines[l]
+		}
+
+		track.Cues = append(track.Cues, remux.SubtitleCue{
+			Text:  strings.Join(lines, "\n"),
+			Start: start,
+			End:   end,
+		})
+	}
+
+	return track
+}
@@ -4,10 +4,6 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
-	"encoding/binary"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"html"
@@ -22,6 +18,8 @@ import (
 	"time"
 
 	"github.com/eientei/jaroid/mediaservice"
+	"github.com/eientei/jaroid/mediaservice/hls"
+	"github.com/eientei/jaroid/mediaservice/remux"
 )
 
 var (
@@ -240,12 +238,37 @@ type APIDataVideo struct {
 	Duration     DurationSeconds `json:"duration,omitempty"`
 }
 
+// APIDataNvCommentTarget json mapping
+type APIDataNvCommentTarget struct {
+	ID   string `json:"id"`
+	Fork string `json:"fork"`
+}
+
+// APIDataNvCommentParams json mapping
+type APIDataNvCommentParams struct {
+	Targets  []APIDataNvCommentTarget `json:"targets"`
+	Language string                   `json:"language"`
+}
+
+// APIDataNvComment json mapping
+type APIDataNvComment struct {
+	Server    string                 `json:"server,omitempty"`
+	ThreadKey string                 `json:"threadKey,omitempty"`
+	Params    APIDataNvCommentParams `json:"params,omitempty"`
+}
+
+// APIDataComment json mapping
+type APIDataComment struct {
+	NvComment APIDataNvComment `json:"nvComment,omitempty"`
+}
+
 // APIData represents subset of data-api-data video stream information required to establish a download session
 type APIData struct {
-	Created time.Time    `json:"-"`
-	Client  APIClient    `json:"client,omitempty"`
-	Video   APIDataVideo `json:"video,omitempty"`
-	Media   APIDataMedia `json:"media,omitempty"`
+	Created time.Time      `json:"-"`
+	Client  APIClient      `json:"client,omitempty"`
+	Video   APIDataVideo   `json:"video,omitempty"`
+	Media   APIDataMedia   `json:"media,omitempty"`
+	Comment APIDataComment `json:"comment,omitempty"`
 }
 
 // SessionRequestClientInfo json mapping
@@ -394,43 +417,6 @@ func (client *Client) methodPage(
 	return resp, nil
 }
 
-func (client *Client) getPageBuf(ctx context.Context, url string, buf *[]byte) error {
-	resp, err := client.methodPage(ctx, url, http.MethodGet, nil, nil)
-	if err != nil {
-		return err
-	}
-
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-
-	*buf = (*buf)[0:]
-
-	if resp.ContentLength != 0 {
-		if int64(len(*buf)) < resp.ContentLength {
-			*buf = append(*buf, make([]byte, resp.ContentLength)...)
-		}
-
-		_, err = io.ReadFull(resp.Body, *buf)
-		if err != nil {
-			return err
-		}
-
-		*buf = (*buf)[:resp.ContentLength]
-
-		return nil
-	}
-
-	bs, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	*buf = append(*buf, bs...)
-
-	return nil
-}
-
 func (client *Client) getPage(ctx context.Context, url string) ([]byte, error) {
 	resp, err := client.methodPage(ctx, url, http.MethodGet, nil, nil)
 	if err != nil {
@@ -902,64 +888,6 @@ func (client *Client) keepalive(
 	}
 }
 
-func (client *Client) reportProgress(ctx context.Context, reporter mediaservice.Reporter, f *os.File, total int64) {
-	t := time.NewTicker(time.Second)
-
-	defer t.Stop()
-
-	finfo, err := f.Stat()
-	if err != nil {
-		return
-	}
-
-	totalsize := mediaservice.HumanSizeFormat(float64(total))
-
-	for {
-		select {
-		case <-t.C:
-			old := finfo.Size()
-
-			finfo, err = f.Stat()
-			if err != nil {
-				return
-			}
-
-			diffsize := finfo.Size() - old
-
-			if diffsize == 0 {
-				continue
-			}
-
-			percent := float64(finfo.Size()) / float64(total) * 100
-
-			if percent > 100 {
-				percent = 100
-			}
-
-			speed := mediaservice.HumanSizeFormat(float64(diffsize))
-
-			rem := total - finfo.Size()
-
-			remain := rem / diffsize
-
-			minutes, seconds := remain/60, remain%60
-
-			msg := fmt.Sprintf(
-				"%2.1f%% of %s at %s/s ETA %02d:%02d",
-				percent,
-				totalsize,
-				speed,
-				minutes,
-				seconds,
-			)
-
-			reporter.Submit(msg, false)
-		case <-ctx.Done():
-			return
-		}
-	}
-}
-
 // QueryFormat returns API response with available media data formats
 func (client *Client) QueryFormat(
 	ctx context.Context,
@@ -969,198 +897,12 @@ func (client *Client) QueryFormat(
 	return client.fetchAPIData(ctx, urls, reporter)
 }
 
-type contentStream int
-
-const (
-	contentStreamAudio = 1
-	contentStreamVideo = 2
-)
-
-type streamChunk struct {
-	data   *[]byte
-	err    error
-	done   chan struct{}
-	block  cipher.Block
-	url    string
-	iv     []byte
-	stream contentStream
-	idx    int
-	init   bool
-}
-
-type contentChunk struct {
-	audio *streamChunk
-	video *streamChunk
-}
-
-func (chunk *contentChunk) download(work chan *streamChunk, audiodata, videodata *[]byte) (datas [][]byte, err error) {
-	work <- chunk.audio
-	work <- chunk.video
-
-	<-chunk.audio.done
-	<-chunk.video.done
-
-	if chunk.audio.err != nil {
-		return nil, chunk.audio.err
-	}
-
-	if chunk.video.err != nil {
-		return nil, chunk.video.err
-	}
-
-	if len(*videodata) != 0 {
-		datas = append(datas, *videodata)
-	}
-
-	if len(*audiodata) != 0 {
-		datas = append(datas, *audiodata)
-	}
-
-	return
-}
-
-var (
-	regexpURL              = regexp.MustCompile(`URI="([^"]*)`)
-	regexpAverageBandwidth = regexp.MustCompile(`AVERAGE-BANDWIDTH=([^",]*)`)
-	regexpIV               = regexp.MustCompile(`IV=0x([^",]*)`)
-)
-
-func (client *Client) parseM3U8AES(ctx context.Context, contents []*streamChunk, ivs, key string) (err error) {
-	var iv []byte
-
-	iv, err = hex.DecodeString(ivs)
-	if err != nil {
-		return
-	}
-
-	keybs, err := client.getPage(ctx, key)
-	if err != nil {
-		return
-	}
-
-	var block cipher.Block
-
-	block, err = aes.NewCipher(keybs)
-	if err != nil {
-		return
-	}
-
-	for _, c := range contents {
-		if !c.init {
-			c.iv = iv
-			c.block = block
-		}
-	}
-
-	return
-}
-
-func matchM3U8(s string, regex *regexp.Regexp) (res string, ok bool) {
-	parts := regex.FindAllStringSubmatch(s, -1)
-	if len(parts) > 0 && len(parts[0]) == 2 {
-		return parts[0][1], true
-	}
-
-	return "", false
-}
-
-func (client *Client) parseStreamM3U8(
-	ctx context.Context,
-	stream contentStream,
-	page string,
-) (contents []*streamChunk, err error) {
-	data, err := client.getPage(ctx, page)
-	if err != nil {
-		return
-	}
-
-	reader := bufio.NewReader(bytes.NewReader(data))
-
-	var line, key, ivs string
-
-	for {
-		line, err = reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-		}
-
-		line = strings.TrimSpace(strings.TrimSuffix(line, "\n"))
-
-		switch {
-		case strings.HasPrefix(line, "#EXT-X-MAP"):
-			if res, ok := matchM3U8(line, regexpURL); ok {
-				contents = append(
-					contents,
-					&streamChunk{url: res, stream: stream, init: true, idx: len(contents)},
-				)
-			}
-		case strings.HasPrefix(line, "#EXT-X-KEY"):
-			if res, ok := matchM3U8(line, regexpURL); ok {
-				key = res
-			}
-
-			if res, ok := matchM3U8(line, regexpIV); ok {
-				ivs = res
-			}
-		case !strings.HasPrefix(line, "#") && line != "":
-			contents = append(
-				contents,
-				&streamChunk{url: line, stream: stream, init: false, idx: len(contents)},
-			)
-		}
-	}
-
-	err = client.parseM3U8AES(ctx, contents, ivs, key)
-
-	return
-}
-
-func (client *Client) downloadDMSExtractPlaylistsParseM3U8(
-	respbs []byte,
-) (audio, video string, bandwidth int64, err error) {
-	var line string
-
-	reader := bufio.NewReader(bytes.NewReader(respbs))
-
-	for {
-		line, err = reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				err = nil
-
-				break
-			}
-		}
-
-		line = strings.TrimSpace(strings.TrimSuffix(line, "\n"))
-
-		switch {
-		case strings.HasPrefix(line, "#EXT-X-MEDIA:TYPE=AUDIO"):
-			if res, ok := matchM3U8(line, regexpURL); ok {
-				audio = res
-			}
-		case strings.HasPrefix(line, "#EXT-X-STREAM-INF"):
-			if res, ok := matchM3U8(line, regexpAverageBandwidth); ok {
-				bandwidth, err = strconv.ParseInt(res, 10, 64)
-				if err != nil {
-					return
-				}
-			}
-		case !strings.HasPrefix(line, "#") && line != "":
-			video = strings.TrimSuffix(line, "\n")
-		}
-	}
-
-	return
-}
-
 func (client *Client) downloadDMSExtractPlaylists(
 	ctx context.Context,
+	hlsclient *hls.Client,
 	data *APIData,
 	aformatid, vformatid string,
-) (audio, video string, bandwidth int64, err error) {
+) (stream *hls.Stream, err error) {
 	nvapi := fmt.Sprintf(
 		"https://nvapi.nicovideo.jp/v1/watch/%s/access-rights/hls?actionTrackId=%s",
 		data.Client.WatchID,
@@ -1203,76 +945,29 @@ func (client *Client) downloadDMSExtractPlaylists(
 		return
 	}
 
-	respbs, err = client.getPage(ctx, resp.Data.ContentURL)
+	master, err := hlsclient.Master(ctx, resp.Data.ContentURL)
 	if err != nil {
 		return
 	}
 
-	return client.downloadDMSExtractPlaylistsParseM3U8(respbs)
-}
-
-func (client *Client) downloadDMSWorker(ctx context.Context, work chan *streamChunk) {
-	for {
-		select {
-		case stream, ok := <-work:
-			if !ok {
-				return
-			}
-
-			err := client.getPageBuf(ctx, stream.url, stream.data)
-			if err != nil {
-				stream.err = err
-
-				close(stream.done)
-
-				continue
-			}
-
-			if stream.block != nil {
-				cbc := cipher.NewCBCDecrypter(stream.block, stream.iv)
-				cbc.CryptBlocks(*stream.data, *stream.data)
-
-				length := len(*stream.data)
-				unpadding := int((*stream.data)[length-1])
-				*stream.data = (*stream.data)[:(length - unpadding)]
-			}
-
-			close(stream.done)
-		case <-ctx.Done():
-			return
-		}
-	}
-}
-
-func (client *Client) downloadDMSResumeIndex(f *os.File, idxbs []byte, magic uint32) (idx int) {
-	siz, err := f.Seek(0, io.SeekEnd)
-	if err != nil {
-		return
+	if len(master.Variants) == 0 {
+		return nil, fmt.Errorf("no variant streams")
 	}
 
-	if int(siz) > len(idxbs) {
-		_, err = f.Seek(-int64(len(idxbs)), io.SeekEnd)
-		if err != nil {
-			return
-		}
+	stream = master.Stream(master.Variants[0])
 
-		_, err = io.ReadFull(f, idxbs)
-		if err != nil {
-			return
+	// requested audio is the only audio rendition, even if not referenced by variant
+	for _, r := range master.Renditions {
+		if stream.Audio == "" && r.Type == "AUDIO" && r.URI != "" {
+			stream.Audio = r.URI
 		}
+	}
 
-		if binary.BigEndian.Uint32(idxbs[0:4]) != uint32(len(idxbs)) ||
-			binary.BigEndian.Uint32(idxbs[4:8]) != magic {
-			_, err = f.Seek(0, io.SeekStart)
-			if err != nil {
-				return
-			}
-		} else {
-			idx = int(binary.BigEndian.Uint64(idxbs[8:16])) + 1
-		}
+	if stream.Audio == "" {
+		return nil, fmt.Errorf("no audio stream")
 	}
 
-	return
+	return stream, nil
 }
 
 func (client *Client) downloadDMS(
@@ -1280,7 +975,7 @@ func (client *Client) downloadDMS(
 	f *os.File,
 	data *APIData,
 	aformatid, vformatid, outpath string,
-	dur time.Duration,
+	subtitles []*remux.SubtitleTrack,
 	reporter mediaservice.Reporter,
 ) (err error) {
 	defer func() {
@@ -1291,106 +986,20 @@ func (client *Client) downloadDMS(
 		}
 	}()
 
-	var contentChunks []*contentChunk
-
-	var audioChunks, videoChunks []*streamChunk
-
-	audio, video, bandwidth, err := client.downloadDMSExtractPlaylists(ctx, data, aformatid, vformatid)
-	if err != nil {
-		return err
-	}
+	hlsclient := hls.New(&hls.Config{
+		HTTPClient: client.HTTPClient,
+	})
 
-	audioChunks, err = client.parseStreamM3U8(ctx, contentStreamAudio, audio)
+	stream, err := client.downloadDMSExtractPlaylists(ctx, hlsclient, data, aformatid, vformatid)
 	if err != nil {
 		return err
 	}
 
-	videoChunks, err = client.parseStreamM3U8(ctx, contentStreamVideo, video)
+	_, err = hlsclient.Download(ctx, f, stream, reporter)
 	if err != nil {
 		return err
 	}
 
-	if len(audioChunks) != len(videoChunks) {
-		return fmt.Errorf(
-			"uneven audi/video streams: %d != %d",
-			len(audioChunks),
-			len(videoChunks),
-		)
-	}
-
-	for i := 0; i < len(audioChunks); i++ {
-		contentChunks = append(contentChunks, &contentChunk{
-			audio: audioChunks[i],
-			video: videoChunks[i],
-		})
-	}
-
-	work := make(chan *streamChunk, 2)
-
-	defer close(work)
-
-	for i := 0; i < 2; i++ {
-		go client.downloadDMSWorker(ctx, work)
-	}
-
-	go client.reportProgress(ctx, reporter, f, bandwidth*int64(dur.Seconds())/8)
-
-	idxbs := make([]byte, 16)
-
-	off := int64(len(idxbs))
-
-	const jaroid = 0x31393139
-
-	idx := client.downloadDMSResumeIndex(f, idxbs, jaroid)
-
-	binary.BigEndian.PutUint32(idxbs[0:4], uint32(len(idxbs)))
-	binary.BigEndian.PutUint32(idxbs[4:8], uint32(jaroid))
-
-	audiodata, videodata := make([]byte, 0), make([]byte, 0)
-
-	for ; idx < len(contentChunks); idx++ {
-		chunk := contentChunks[idx]
-
-		if idx > 0 {
-			_, err = f.Seek(-off, io.SeekEnd)
-			if err != nil {
-				return
-			}
-		}
-
-		chunk.audio.done = make(chan struct{})
-		chunk.video.done = make(chan struct{})
-
-		chunk.audio.data, chunk.video.data = &audiodata, &videodata
-
-		var datas [][]byte
-
-		datas, err = chunk.download(work, &audiodata, &videodata)
-		if err != nil {
-			return err
-		}
-
-		if chunk.audio.init {
-			err = combineInitSegments(datas, f)
-		} else {
-			err = combineMediaSegments(datas, f)
-		}
-
-		audiodata = audiodata[:0]
-		videodata = videodata[:0]
-
-		if err != nil {
-			return err
-		}
-
-		binary.BigEndian.PutUint64(idxbs[off-8:], uint64(idx))
-
-		_, err = f.Write(idxbs)
-		if err != nil {
-			return err
-		}
-	}
-
 	_, err = f.Seek(0, io.SeekStart)
 	if err != nil {
 		return err
@@ -1412,7 +1021,7 @@ func (client *Client) downloadDMS(
 		"\xA9day": data.Video.RegisteredAt,
 	}
 
-	err = DefragmentMP4(f, of, metadata)
+	err = remux.DefragmentMP4(f, of, metadata, subtitles...)
 	if err != nil {
 		return
 	}
@@ -1478,7 +1087,12 @@ func (client *Client) downloadDMC(
 		return nil
 	}
 
-	go client.reportProgress(ctx, reporter, f, cl)
+	note := mediaservice.ResumedNote(siz, cl)
+	if note != "" {
+		reporter.Submit(note, true)
+	}
+
+	go mediaservice.ReportProgress(ctx, reporter, f, cl, note)
 
 	_, err = io.Copy(f, resp.Body)
 	if err != nil {
@@ -1509,13 +1123,28 @@ func (client *Client) SaveFormat(
 		}
 	}
 
-	aformatid, vformatid, _, _, dur, err := mediaservice.SelectFormat(data.ListFormats(), formatID)
+	aformatid, vformatid, _, _, _, err := mediaservice.SelectFormat(data.ListFormats(), formatID)
 	if err != nil {
 		return "", err
 	}
 
 	fmtname := strings.TrimPrefix(vformatid, "archive_") + "--" + strings.TrimPrefix(aformatid, "archive_")
 
+	start := time.Now()
+
+	defer func() {
+		metricDownloads.Inc(fmtname, resultLabel(err))
+		metricDownloadDuration.Since(start, fmtname, resultLabel(err))
+
+		if err != nil {
+			return
+		}
+
+		if st, serr := os.Stat(fname); serr == nil {
+			metricDownloadBytes.Add(float64(st.Size()), fmtname)
+		}
+	}()
+
 	outpath = strings.ReplaceAll(outpath, "${fmt}", fmtname)
 
 	tempname := outpath
@@ -1533,15 +1162,28 @@ func (client *Client) SaveFormat(
 		return "", err
 	}
 
+	var subtitles []*remux.SubtitleTrack
+
+	if opts != nil && len(opts.Subtitles) > 0 {
+		subtitles = client.saveComments(ctx, data, outpath, opts.Subtitles, reporter)
+
+		if !opts.MuxSubtitles {
+			subtitles = nil
+		}
+	}
+
 	cctx, cancel := context.WithCancel(ctx)
 
 	defer cancel()
 
 	switch {
 	case data.Media.Domand.AccessRightKey != "":
-		err = client.downloadDMS(cctx, f, data, aformatid, vformatid, outpath, dur, reporter)
+		err = client.downloadDMS(cctx, f, data, aformatid, vformatid, outpath, subtitles, reporter)
 	case len(data.Media.Delivery.Movie.Session.URLS) > 0:
 		err = client.downloadDMC(cctx, f, data, aformatid, vformatid, outpath, reuse, reporter)
+		if err == nil && len(subtitles) > 0 {
+			err = remux.MuxSubtitles(outpath, subtitles)
+		}
 	default:
 		err = fmt.Errorf("unknown content delivery method")
 	}
@@ -0,0 +1,32 @@
+package nicovideo
+
+import (
+	"github.com/eientei/jaroid/util/metrics"
+)
+
+var (
+	metricDownloads = metrics.NewCounter(
+		"jaroid_nicovideo_downloads_total",
+		"Number of finished downloads by format and result",
+		"format", "result",
+	)
+	metricDownloadBytes = metrics.NewCounter(
+		"jaroid_nicovideo_download_bytes_total",
+		"Size of successfully downloaded files by format",
+		"format",
+	)
+	metricDownloadDuration = metrics.NewHistogram(
+		"jaroid_nicovideo_download_duration_seconds",
+		"Duration of downloads by format and result",
+		metrics.DefaultBuckets,
+		"format", "result",
+	)
+)
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+
+	return "ok"
+}
@@ -1,5 +1,4 @@
-// Package youtubedl provides downloader service implementation using system youtube-dl
-// deprecated
+// Package youtubedl provides downloader service implementation using system youtube-dl or yt-dlp
 package youtubedl
 
 import (
@@ -248,25 +247,62 @@ func (d *Downloader) SaveFormatRaw(
 	return d.readlines(ctx, cmd, reporter, nil, "")
 }
 
-// SaveFormat implementation
+var formatIDSanitizer = regexp.MustCompile(`[^a-zA-Z0-9_.+-]+`)
+
+// formatSelector translates size-limited format ids such as 8m or 8m! into youtube-dl format selector
+func formatSelector(formatID string) string {
+	switch {
+	case formatID == "" || formatID == "max" || formatID == "inf":
+		return "bv*+ba/b"
+	case mediaservice.MatchesHumanSize(formatID):
+		size := strconv.FormatUint(mediaservice.HumanSizeParse(formatID), 10)
+		selector := "b[filesize<" + size + "]/b[filesize_approx<" + size + "]"
+
+		if strings.HasSuffix(formatID, "!") {
+			selector += "/w"
+		}
+
+		return selector
+	default:
+		return formatID
+	}
+}
+
+// SaveFormat implementation, ${fmt} in outpath is replaced by sanitized format id
 func (d *Downloader) SaveFormat(
 	ctx context.Context,
 	url, formatID, outpath string,
+	reuse bool,
+	_ []byte,
 	opts *mediaservice.SaveOptions,
-) (err error) {
-	var args []string
-
-	if formatID != "" {
-		args = append(args, "-f", formatID)
+) (fname string, err error) {
+	fmtname := formatIDSanitizer.ReplaceAllString(formatID, "_")
+	if fmtname == "" {
+		fmtname = "max"
 	}
 
-	args = append(args, "-o", outpath, url)
+	fname = strings.ReplaceAll(outpath, "${fmt}", fmtname)
+
+	args := []string{"-f", formatSelector(formatID), "--merge-output-format", "mp4", "-o", fname}
+
+	if !reuse {
+		args = append(args, "--no-continue")
+	}
 
 	if opts != nil && len(opts.Subtitles) > 0 {
 		args = append(args, "--write-sub", "--sub-lang", strings.Join(opts.Subtitles, ","))
+
+		if opts.MuxSubtitles {
+			args = append(args, "--embed-subs")
+		}
 	}
 
+	args = append(args, url)
+
 	_, err = d.SaveFormatRaw(ctx, opts, args...)
+	if err != nil {
+		return "", err
+	}
 
-	return
+	return fname, nil
 }
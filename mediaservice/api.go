@@ -124,8 +124,9 @@ func (s *ListOptions) GetReporter() Reporter {
 
 // SaveOptions options for SaveFormat
 type SaveOptions struct {
-	Reporter  Reporter
-	Subtitles []string
+	Reporter     Reporter
+	Subtitles    []string
+	MuxSubtitles bool
 }
 
 // GetReporter or default dummy
@@ -206,7 +207,8 @@ func HumanSizeFormat(size float64) string {
 	return fmt.Sprintf("%5.1f%s", size, humanFileSuffixes[i])
 }
 
-func parseFormatSize(formatID string) (dsize uint64, wildcard bool, tgt string, err error) {
+// ParseFormatSize parses format id selector into size constraint, wildcard flag and exact target format id
+func ParseFormatSize(formatID string) (dsize uint64, wildcard bool, tgt string, err error) {
 	switch {
 	case formatID == "inf" || formatID == "max" || formatID == "":
 		dsize = math.MaxUint64
@@ -273,7 +275,7 @@ func SelectFormat(
 	formats []*Format,
 	formatID string,
 ) (aformatid, vformatid string, idx int, size uint64, dur time.Duration, err error) {
-	dsize, wildcard, tgt, err := parseFormatSize(formatID)
+	dsize, wildcard, tgt, err := ParseFormatSize(formatID)
 	if err != nil {
 		return
 	}
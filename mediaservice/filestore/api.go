@@ -0,0 +1,120 @@
+// Package filestore provides storage targets for downloaded media files: local directory, S3-compatible object
+// storage and WebDAV
+package filestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrUnexpectedStatus is returned when remote storage responds with unexpected HTTP status
+var ErrUnexpectedStatus = errors.New("unexpected status")
+
+// DefaultTimeout limits single remote storage request including upload, used when no HTTP client is provided
+const DefaultTimeout = time.Minute * 10
+
+var defaultClient = &http.Client{
+	Timeout: DefaultTimeout,
+}
+
+// Store persists downloaded files under flat names and provides public URLs to them
+type Store interface {
+	// Put stores local file fpath under name
+	Put(ctx context.Context, name, fpath string) error
+	// List returns names of stored files starting with prefix
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Remove deletes stored file, removing missing file is not an error
+	Remove(ctx context.Context, name string) error
+	// URL returns public URL of stored file
+	URL(name string) string
+}
+
+// Find returns name of first complete file starting with prefix, skipping partial downloads and subtitles,
+// or empty string if none found
+func Find(ctx context.Context, store Store, prefix string) (string, error) {
+	names, err := store.List(ctx, prefix)
+	if err != nil {
+		return "", err
+	}
+
+	for _, n := range names {
+		if !strings.HasSuffix(n, ".part") && !strings.HasSuffix(n, ".ass") {
+			return n, nil
+		}
+	}
+
+	return "", nil
+}
+
+// Exists returns true if file with exact name is stored
+func Exists(ctx context.Context, store Store, name string) (bool, error) {
+	names, err := store.List(ctx, name)
+	if err != nil {
+		return false, err
+	}
+
+	for _, n := range names {
+		if n == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// joinURL joins base URL and escaped file name
+func joinURL(base, name string) string {
+	return strings.TrimSuffix(base, "/") + "/" + escapePath(name)
+}
+
+// escapePath escapes all characters except unreserved ones and slashes, as required by S3 signatures
+func escapePath(s string) string {
+	sb := &strings.Builder{}
+
+	for _, b := range []byte(s) {
+		switch {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', b == '/':
+			_ = sb.WriteByte(b)
+		default:
+			_, _ = fmt.Fprintf(sb, "%%%02X", b)
+		}
+	}
+
+	return sb.String()
+}
+
+// do performs request, returning error on unexpected status; missing resources are allowed if notFoundOK is set
+func do(client *http.Client, req *http.Request, notFoundOK bool) (body []byte, err error) {
+	if client == nil {
+		client = defaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return body, nil
+	case notFoundOK && resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s %s: %s", ErrUnexpectedStatus, req.Method, req.URL.Path, resp.Status)
+}
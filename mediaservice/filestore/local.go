@@ -0,0 +1,84 @@
+package filestore
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Local stores files in local directory, served by external HTTP server at Public URL
+type Local struct {
+	Directory string
+	Public    string
+}
+
+// Put implementation, file is copied into directory unless it is already there
+func (l *Local) Put(_ context.Context, name, fpath string) (err error) {
+	dst := filepath.Join(l.Directory, name)
+
+	if same, _ := filepath.Abs(dst); same != "" {
+		if src, _ := filepath.Abs(fpath); src == same {
+			return nil
+		}
+	}
+
+	err = os.MkdirAll(l.Directory, 0777)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = src.Close()
+	}()
+
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		cerr := f.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+
+	_, err = io.Copy(f, src)
+
+	return err
+}
+
+// List implementation
+func (l *Local) List(_ context.Context, prefix string) (names []string, err error) {
+	matches, err := filepath.Glob(filepath.Join(l.Directory, prefix) + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range matches {
+		names = append(names, filepath.Base(m))
+	}
+
+	return names, nil
+}
+
+// Remove implementation
+func (l *Local) Remove(_ context.Context, name string) error {
+	err := os.Remove(filepath.Join(l.Directory, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// URL implementation
+func (l *Local) URL(name string) string {
+	return l.Public + "/" + name
+}
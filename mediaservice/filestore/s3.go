@@ -0,0 +1,243 @@
+package filestore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3TimeFormat      = "20060102T150405Z"
+	s3DateFormat      = "20060102"
+)
+
+// S3 stores files in S3-compatible object storage bucket using path-style requests signed with AWS signature v4.
+// Objects are expected to be publicly readable at Public URL, or at Endpoint/Bucket if Public is empty.
+type S3 struct {
+	HTTPClient *http.Client
+	Endpoint   string
+	Region     string
+	Bucket     string
+	AccessKey  string
+	SecretKey  string
+	Public     string
+}
+
+type s3ListResult struct {
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated bool `xml:"IsTruncated"`
+}
+
+func (s *S3) region() string {
+	if s.Region == "" {
+		return "us-east-1"
+	}
+
+	return s.Region
+}
+
+func (s *S3) objectURL(name string) string {
+	return joinURL(strings.TrimSuffix(s.Endpoint, "/")+"/"+s.Bucket, name)
+}
+
+// Put implementation
+func (s *S3) Put(ctx context.Context, name, fpath string) error {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	finfo, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(name), f)
+	if err != nil {
+		return err
+	}
+
+	req.ContentLength = finfo.Size()
+
+	s.sign(req, s3UnsignedPayload, time.Now())
+
+	_, err = do(s.HTTPClient, req, false)
+
+	return err
+}
+
+// List implementation
+func (s *S3) List(ctx context.Context, prefix string) (names []string, err error) {
+	query := url.Values{
+		"list-type": []string{"2"},
+		"prefix":    []string{prefix},
+	}
+
+	for {
+		var req *http.Request
+
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL("")+"?"+canonicalQuery(query), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		s.sign(req, hashHex(nil), time.Now())
+
+		var body []byte
+
+		body, err = do(s.HTTPClient, req, false)
+		if err != nil {
+			return nil, err
+		}
+
+		res := &s3ListResult{}
+
+		err = xml.Unmarshal(body, res)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range res.Contents {
+			names = append(names, c.Key)
+		}
+
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return names, nil
+		}
+
+		query.Set("continuation-token", res.NextContinuationToken)
+	}
+}
+
+// Remove implementation
+func (s *S3) Remove(ctx context.Context, name string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(name), nil)
+	if err != nil {
+		return err
+	}
+
+	s.sign(req, hashHex(nil), time.Now())
+
+	_, err = do(s.HTTPClient, req, true)
+
+	return err
+}
+
+// URL implementation
+func (s *S3) URL(name string) string {
+	if s.Public != "" {
+		return joinURL(s.Public, name)
+	}
+
+	return s.objectURL(name)
+}
+
+func hashHex(bs []byte) string {
+	h := sha256.Sum256(bs)
+
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+
+	_, _ = h.Write([]byte(s))
+
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes query with sorted keys, escaping as required by signature v4
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+
+	for k := range query {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var parts []string
+
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, escapeQuery(k)+"="+escapeQuery(v))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+func escapeQuery(s string) string {
+	return strings.ReplaceAll(escapePath(s), "/", "%2F")
+}
+
+// sign adds signature v4 authorization headers to request, signing host and all headers already set
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzdate := now.Format(s3TimeFormat)
+	scope := now.Format(s3DateFormat) + "/" + s.region() + "/s3/aws4_request"
+
+	req.Header.Set("x-amz-date", amzdate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+
+	for k, vs := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(vs, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+
+	for k := range headers {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	canonical := &strings.Builder{}
+
+	_, _ = canonical.WriteString(req.Method + "\n")
+	_, _ = canonical.WriteString(escapePath(req.URL.Path) + "\n")
+	_, _ = canonical.WriteString(canonicalQuery(req.URL.Query()) + "\n")
+
+	for _, k := range names {
+		_, _ = canonical.WriteString(k + ":" + headers[k] + "\n")
+	}
+
+	signed := strings.Join(names, ";")
+
+	_, _ = canonical.WriteString("\n" + signed + "\n" + payloadHash)
+
+	tosign := s3Algorithm + "\n" + amzdate + "\n" + scope + "\n" + hashHex([]byte(canonical.String()))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), now.Format(s3DateFormat))
+	key = hmacSHA256(key, s.region())
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, tosign))
+
+	req.Header.Set(
+		"Authorization",
+		s3Algorithm+" Credential="+s.AccessKey+"/"+scope+", SignedHeaders="+signed+", Signature="+signature,
+	)
+}
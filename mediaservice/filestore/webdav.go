@@ -0,0 +1,134 @@
+package filestore
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/></prop></propfind>`
+
+// WebDAV stores files in WebDAV collection at Endpoint, served at Public URL, or at Endpoint itself if Public is empty
+type WebDAV struct {
+	HTTPClient *http.Client
+	Endpoint   string
+	Username   string
+	Password   string
+	Public     string
+}
+
+type webdavMultistatus struct {
+	Responses []struct {
+		Href string `xml:"href"`
+	} `xml:"response"`
+}
+
+func (w *WebDAV) request(ctx context.Context, method, uri string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, uri, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.Username != "" || w.Password != "" {
+		req.SetBasicAuth(w.Username, w.Password)
+	}
+
+	return req, nil
+}
+
+// Put implementation
+func (w *WebDAV) Put(ctx context.Context, name, fpath string) error {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	finfo, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := w.request(ctx, http.MethodPut, joinURL(w.Endpoint, name), f)
+	if err != nil {
+		return err
+	}
+
+	req.ContentLength = finfo.Size()
+
+	_, err = do(w.HTTPClient, req, false)
+
+	return err
+}
+
+// List implementation
+func (w *WebDAV) List(ctx context.Context, prefix string) (names []string, err error) {
+	uri := strings.TrimSuffix(w.Endpoint, "/") + "/"
+
+	req, err := w.request(ctx, "PROPFIND", uri, strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	body, err := do(w.HTTPClient, req, false)
+	if err != nil {
+		return nil, err
+	}
+
+	ms := &webdavMultistatus{}
+
+	err = xml.Unmarshal(body, ms)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range ms.Responses {
+		if strings.HasSuffix(r.Href, "/") {
+			continue
+		}
+
+		name, uerr := url.PathUnescape(path.Base(r.Href))
+		if uerr != nil {
+			continue
+		}
+
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+// Remove implementation
+func (w *WebDAV) Remove(ctx context.Context, name string) error {
+	req, err := w.request(ctx, http.MethodDelete, joinURL(w.Endpoint, name), nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = do(w.HTTPClient, req, true)
+
+	return err
+}
+
+// URL implementation
+func (w *WebDAV) URL(name string) string {
+	if w.Public != "" {
+		return joinURL(w.Public, name)
+	}
+
+	return joinURL(w.Endpoint, name)
+}
@@ -0,0 +1,338 @@
+// Package hls provides native HLS downloader service implementation for arbitrary m3u8 playlists
+package hls
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eientei/jaroid/mediaservice"
+	"github.com/eientei/jaroid/mediaservice/remux"
+)
+
+// URLPattern matches direct m3u8 playlist URLs
+var URLPattern = regexp.MustCompile(`^https?://\S+\.m3u8(\?\S*)?$`)
+
+// muxedAudio is audio format id of variants without separate audio rendition
+const muxedAudio = "muxed"
+
+var formatIDSanitizer = regexp.MustCompile(`[^a-zA-Z0-9_.+-]+`)
+
+// Config for HLS client
+type Config struct {
+	HTTPClient *http.Client
+	Workers    int
+	LiveWindow time.Duration
+}
+
+// Client implements mediaservice.Downloader for m3u8 playlists
+type Client struct {
+	Config
+}
+
+// New creates new HLS client, using two download workers and one hour live recording window by default
+func New(cfg *Config) *Client {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+
+	if cfg.Workers <= 0 {
+		cfg.Workers = 2
+	}
+
+	if cfg.LiveWindow <= 0 {
+		cfg.LiveWindow = time.Hour
+	}
+
+	return &Client{
+		Config: *cfg,
+	}
+}
+
+func (client *Client) playlist(ctx context.Context, uri string) (*MasterPlaylist, *MediaPlaylist, error) {
+	base, err := url.Parse(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bs, err := client.get(ctx, uri, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return Parse(base, bs)
+}
+
+// Master fetches master playlist, media playlist is returned as master playlist with single variant
+func (client *Client) Master(ctx context.Context, uri string) (*MasterPlaylist, error) {
+	master, _, err := client.playlist(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if master == nil {
+		master = &MasterPlaylist{
+			Variants: []*Variant{
+				{
+					URI: uri,
+				},
+			},
+		}
+	}
+
+	return master, nil
+}
+
+// MediaPlaylist fetches media playlist
+func (client *Client) MediaPlaylist(ctx context.Context, uri string) (*MediaPlaylist, error) {
+	_, media, err := client.playlist(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if media == nil {
+		return nil, ErrInvalidPlaylist
+	}
+
+	return media, nil
+}
+
+// Stream returns stream of given variant with its separate audio rendition, if any
+func (p *MasterPlaylist) Stream(v *Variant) *Stream {
+	s := &Stream{
+		Video:     v.URI,
+		Bandwidth: v.GetBandwidth(),
+	}
+
+	if r := p.AudioRendition(v); r != nil {
+		s.Audio = r.URI
+	}
+
+	return s
+}
+
+func videoCodec(codecs string) mediaservice.VideoCodec {
+	for _, c := range strings.Split(codecs, ",") {
+		c = strings.TrimSpace(c)
+
+		switch {
+		case strings.HasPrefix(c, "avc1"), strings.HasPrefix(c, "avc3"):
+			return mediaservice.VideoCodecH264
+		case strings.HasPrefix(c, "hvc1"), strings.HasPrefix(c, "hev1"):
+			return mediaservice.NewVideoCodec("h265")
+		case strings.HasPrefix(c, "vp09"):
+			return mediaservice.VideoCodecVP9
+		case strings.HasPrefix(c, "av01"):
+			return mediaservice.NewVideoCodec("av1")
+		}
+	}
+
+	return ""
+}
+
+func audioCodec(codecs string) mediaservice.AudioCodec {
+	for _, c := range strings.Split(codecs, ",") {
+		c = strings.TrimSpace(c)
+
+		switch {
+		case strings.HasPrefix(c, "mp4a"):
+			return mediaservice.AudioCodecAAC
+		case strings.HasPrefix(c, "opus"):
+			return mediaservice.AudioCodecOPUS
+		}
+	}
+
+	return ""
+}
+
+// formatIDs returns video and audio format ids of variants
+func (p *MasterPlaylist) formatIDs() (vids, aids []string) {
+	seen := make(map[string]int)
+
+	for _, v := range p.Variants {
+		vid := "hls-" + strconv.FormatInt(v.GetBandwidth()/1000, 10) + "k"
+
+		if seen[vid]++; seen[vid] > 1 {
+			vid += "-" + strconv.Itoa(seen[vid])
+		}
+
+		aid := muxedAudio
+
+		if r := p.AudioRendition(v); r != nil {
+			aid = formatIDSanitizer.ReplaceAllString(r.GroupID, "_")
+		}
+
+		vids, aids = append(vids, vid), append(aids, aid)
+	}
+
+	return
+}
+
+// Variant returns variant with given format id or nil
+func (p *MasterPlaylist) Variant(formatID string) *Variant {
+	vids, aids := p.formatIDs()
+
+	for i, v := range p.Variants {
+		if vids[i]+"--"+aids[i] == formatID {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// Formats returns formats of master playlist variants ordered by bandwidth, with given duration
+func (p *MasterPlaylist) Formats(dur time.Duration) (formats []*mediaservice.Format) {
+	vids, aids := p.formatIDs()
+
+	for i, v := range p.Variants {
+		formats = append(formats, &mediaservice.Format{
+			ID:        vids[i] + "--" + aids[i],
+			Container: mediaservice.ContainerMP4,
+			Audio: mediaservice.AudioFormat{
+				ID:    aids[i],
+				Codec: audioCodec(v.Codecs),
+			},
+			Video: mediaservice.VideoFormat{
+				ID:      vids[i],
+				Codec:   videoCodec(v.Codecs),
+				Bitrate: uint64(v.GetBandwidth()),
+				Width:   v.Width,
+				Height:  v.Height,
+			},
+			Duration: dur,
+		})
+	}
+
+	sort.SliceStable(formats, func(i, j int) bool {
+		return formats[i].Video.Bitrate < formats[j].Video.Bitrate
+	})
+
+	return
+}
+
+// formats fetches master playlist and its formats, with duration of first variant
+func (client *Client) formats(
+	ctx context.Context,
+	uri string,
+) (master *MasterPlaylist, formats []*mediaservice.Format, err error) {
+	master, err = client.Master(ctx, uri)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var dur time.Duration
+
+	if len(master.Variants) > 0 {
+		var media *MediaPlaylist
+
+		media, err = client.MediaPlaylist(ctx, master.Variants[0].URI)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		dur = media.Duration()
+	}
+
+	formats = master.Formats(dur)
+
+	return master, formats, nil
+}
+
+// ListFormats implementation
+func (client *Client) ListFormats(
+	ctx context.Context,
+	uri string,
+	opts *mediaservice.ListOptions,
+) ([]*mediaservice.Format, error) {
+	opts.GetReporter().Submit("Fetching playlist...", false)
+
+	_, formats, err := client.formats(ctx, uri)
+
+	return formats, err
+}
+
+// SaveFormat implementation, ${fmt} in outpath is replaced by format id. Fragmented mp4 streams are saved as
+// mp4 files, MPEG-TS streams are saved as is with .ts extension. Subtitles are not supported.
+func (client *Client) SaveFormat(
+	ctx context.Context,
+	uri, formatID, outpath string,
+	reuse bool,
+	_ []byte,
+	opts *mediaservice.SaveOptions,
+) (fname string, err error) {
+	reporter := opts.GetReporter()
+
+	master, formats, err := client.formats(ctx, uri)
+	if err != nil {
+		return "", err
+	}
+
+	_, _, idx, _, _, err := mediaservice.SelectFormat(formats, formatID)
+	if err != nil {
+		return "", err
+	}
+
+	fname = strings.ReplaceAll(outpath, "${fmt}", formats[idx].ID)
+	variant := master.Variant(formats[idx].ID)
+
+	flags := os.O_RDWR | os.O_CREATE
+	if !reuse {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(fname+".part", flags, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		_ = f.Close()
+
+		if err == nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	fragmented, err := client.Download(ctx, f, master.Stream(variant), reporter)
+	if err != nil {
+		return "", err
+	}
+
+	if !fragmented {
+		fname = strings.TrimSuffix(fname, ".mp4") + ".ts"
+
+		return fname, os.Rename(f.Name(), fname)
+	}
+
+	_, err = f.Seek(0, 0)
+	if err != nil {
+		return "", err
+	}
+
+	of, err := os.OpenFile(fname, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		_ = of.Close()
+	}()
+
+	err = remux.DefragmentMP4(f, of, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return fname, nil
+}
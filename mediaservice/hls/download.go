@@ -0,0 +1,558 @@
+package hls
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/eientei/jaroid/mediaservice"
+	"github.com/eientei/jaroid/mediaservice/remux"
+)
+
+// resumeMagic marks resume index trailer appended to partial downloads
+const resumeMagic = 0x31393139
+
+var (
+	// ErrUnevenStreams is returned when separate audio and video renditions have different number of segments
+	ErrUnevenStreams = errors.New("uneven audio/video streams")
+	// ErrUnsupportedStream is returned when separate audio rendition is used with MPEG-TS segments
+	ErrUnsupportedStream = errors.New("separate audio rendition requires fragmented mp4 segments")
+)
+
+// Stream is a selected variant media playlist with optional separate audio rendition playlist
+type Stream struct {
+	Video     string
+	Audio     string
+	Bandwidth int64
+}
+
+type segmentJob struct {
+	segment *Segment
+	err     error
+	done    chan struct{}
+	data    []byte
+}
+
+type chunk struct {
+	video *segmentJob
+	audio *segmentJob
+	init  bool
+}
+
+type downloader struct {
+	client *Client
+	keys   map[string]cipher.Block
+	work   chan *segmentJob
+	m      sync.Mutex
+}
+
+func (client *Client) get(ctx context.Context, uri string, r *ByteRange) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if r != nil {
+		req.Header.Set("range", r.Header())
+	}
+
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s", uri, resp.Status)
+	}
+
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// servers ignoring range header return whole resource
+	if r != nil && resp.StatusCode == http.StatusOK && int64(len(bs)) >= r.Offset+r.Length {
+		bs = bs[r.Offset : r.Offset+r.Length]
+	}
+
+	return bs, nil
+}
+
+func (d *downloader) key(ctx context.Context, key *Key) (block cipher.Block, err error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if block = d.keys[key.URI]; block != nil {
+		return
+	}
+
+	bs, err := d.client.get(ctx, key.URI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err = aes.NewCipher(bs)
+	if err != nil {
+		return nil, err
+	}
+
+	d.keys[key.URI] = block
+
+	return
+}
+
+func (d *downloader) decrypt(ctx context.Context, job *segmentJob) error {
+	block, err := d.key(ctx, job.segment.Key)
+	if err != nil {
+		return err
+	}
+
+	if len(job.data) == 0 || len(job.data)%aes.BlockSize != 0 {
+		return fmt.Errorf("%s: invalid encrypted segment size %d", job.segment.URI, len(job.data))
+	}
+
+	cipher.NewCBCDecrypter(block, job.segment.Key.IV).CryptBlocks(job.data, job.data)
+
+	unpadding := int(job.data[len(job.data)-1])
+	if unpadding == 0 || unpadding > aes.BlockSize {
+		return fmt.Errorf("%s: invalid segment padding", job.segment.URI)
+	}
+
+	job.data = job.data[:len(job.data)-unpadding]
+
+	return nil
+}
+
+func (d *downloader) worker(ctx context.Context) {
+	for {
+		select {
+		case job, ok := <-d.work:
+			if !ok {
+				return
+			}
+
+			job.data, job.err = d.client.get(ctx, job.segment.URI, job.segment.ByteRange)
+
+			if job.err == nil && job.segment.Key != nil {
+				job.err = d.decrypt(ctx, job)
+			}
+
+			close(job.done)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *downloader) submit(ctx context.Context, c *chunk) error {
+	for _, job := range []*segmentJob{c.video, c.audio} {
+		if job == nil {
+			continue
+		}
+
+		select {
+		case d.work <- job:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// wait returns downloaded chunk data, video first
+func (c *chunk) wait(ctx context.Context) (datas [][]byte, err error) {
+	for _, job := range []*segmentJob{c.video, c.audio} {
+		if job == nil {
+			continue
+		}
+
+		select {
+		case <-job.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if job.err != nil {
+			return nil, job.err
+		}
+
+		if len(job.data) != 0 {
+			datas = append(datas, job.data)
+		}
+	}
+
+	return
+}
+
+// release drops downloaded data of already written chunk
+func (c *chunk) release() {
+	for _, job := range []*segmentJob{c.video, c.audio} {
+		if job != nil {
+			job.data = nil
+		}
+	}
+}
+
+func newJob(segment *Segment) *segmentJob {
+	if segment == nil {
+		return nil
+	}
+
+	return &segmentJob{
+		segment: segment,
+		done:    make(chan struct{}),
+	}
+}
+
+// chunks pairs video and audio segments starting with given index, prepending init section chunk if at start
+func chunks(video, audio []*Segment, from int) (res []*chunk) {
+	if from == 0 && len(video) > 0 && video[0].Map != nil {
+		c := &chunk{
+			video: newJob(video[0].Map),
+			init:  true,
+		}
+
+		if len(audio) > 0 {
+			c.audio = newJob(audio[0].Map)
+		}
+
+		res = append(res, c)
+	}
+
+	for i := from; i < len(video) && (audio == nil || i < len(audio)); i++ {
+		c := &chunk{
+			video: newJob(video[i]),
+		}
+
+		if audio != nil {
+			c.audio = newJob(audio[i])
+		}
+
+		res = append(res, c)
+	}
+
+	return
+}
+
+// resumeIndex returns index of chunk to continue from according to trailer of partial file, truncating file
+// if trailer is not valid
+func resumeIndex(f *os.File, idxbs []byte) (idx int) {
+	siz, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return
+	}
+
+	if int(siz) <= len(idxbs) {
+		return
+	}
+
+	_, err = f.Seek(-int64(len(idxbs)), io.SeekEnd)
+	if err != nil {
+		return
+	}
+
+	_, err = io.ReadFull(f, idxbs)
+	if err != nil {
+		return
+	}
+
+	if binary.BigEndian.Uint32(idxbs[0:4]) == uint32(len(idxbs)) &&
+		binary.BigEndian.Uint32(idxbs[4:8]) == resumeMagic {
+		return int(binary.BigEndian.Uint64(idxbs[8:16])) + 1
+	}
+
+	err = f.Truncate(0)
+	if err != nil {
+		return
+	}
+
+	_, _ = f.Seek(0, io.SeekStart)
+
+	return
+}
+
+// refresh reloads live media playlist, appending segments newer than already known ones
+func (client *Client) refresh(ctx context.Context, uri string, segments []*Segment) ([]*Segment, bool, error) {
+	p, err := client.MediaPlaylist(ctx, uri)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var last int64 = -1
+
+	if len(segments) > 0 {
+		last = segments[len(segments)-1].Sequence
+	}
+
+	for _, s := range p.Segments {
+		if s.Sequence > last {
+			segments = append(segments, s)
+		}
+	}
+
+	return segments, p.Ended, nil
+}
+
+type streamState struct {
+	video    []*Segment
+	audio    []*Segment
+	duration time.Duration
+	target   time.Duration
+	ended    bool
+}
+
+func (client *Client) streamState(ctx context.Context, stream *Stream) (state *streamState, err error) {
+	video, err := client.MediaPlaylist(ctx, stream.Video)
+	if err != nil {
+		return nil, err
+	}
+
+	state = &streamState{
+		video:    video.Segments,
+		duration: video.Duration(),
+		target:   video.TargetDuration,
+		ended:    video.Ended,
+	}
+
+	if stream.Audio != "" {
+		var audio *MediaPlaylist
+
+		audio, err = client.MediaPlaylist(ctx, stream.Audio)
+		if err != nil {
+			return nil, err
+		}
+
+		state.audio = audio.Segments
+
+		if state.audio == nil {
+			state.audio = []*Segment{}
+		}
+
+		if len(state.video) > 0 && state.video[0].Map == nil {
+			return nil, ErrUnsupportedStream
+		}
+
+		if state.ended && len(state.audio) != len(state.video) {
+			return nil, fmt.Errorf("%w: %d != %d", ErrUnevenStreams, len(state.audio), len(state.video))
+		}
+	}
+
+	if state.target <= 0 {
+		state.target = time.Second * 5
+	}
+
+	return state, nil
+}
+
+// waitLive waits for live playlist update, returns false if recording window is filled or context is done
+func (client *Client) waitLive(ctx context.Context, stream *Stream, state *streamState) (bool, error) {
+	if state.ended || state.duration >= client.LiveWindow {
+		return false, nil
+	}
+
+	select {
+	case <-time.After(state.target):
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+
+	var err error
+
+	known := len(state.video)
+
+	state.video, state.ended, err = client.refresh(ctx, stream.Video, state.video)
+	if err != nil {
+		return false, err
+	}
+
+	for _, s := range state.video[known:] {
+		state.duration += s.Duration
+	}
+
+	if state.audio != nil {
+		state.audio, _, err = client.refresh(ctx, stream.Audio, state.audio)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// Download downloads stream to file f, fragmented mp4 segments are combined into fragmented mp4 file and
+// MPEG-TS segments are concatenated. Partial downloads of ended playlists are resumed. Live playlists are
+// followed until they end or LiveWindow is recorded.
+func (client *Client) Download(
+	ctx context.Context,
+	f *os.File,
+	stream *Stream,
+	reporter mediaservice.Reporter,
+) (fragmented bool, err error) {
+	state, err := client.streamState(ctx, stream)
+	if err != nil {
+		return false, err
+	}
+
+	fragmented = len(state.video) > 0 && state.video[0].Map != nil
+
+	idxbs := make([]byte, 16)
+
+	var idx int
+
+	if state.ended {
+		idx = resumeIndex(f, idxbs)
+	} else if err = f.Truncate(0); err != nil {
+		return
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+
+	defer cancel()
+
+	d := &downloader{
+		client: client,
+		keys:   make(map[string]cipher.Block),
+		work:   make(chan *segmentJob),
+	}
+
+	for i := 0; i < client.Workers; i++ {
+		go d.worker(cctx)
+	}
+
+	all := chunks(state.video, state.audio, 0)
+
+	note := mediaservice.ResumedNote(int64(idx), int64(len(all)))
+	if note != "" {
+		reporter.Submit(note, true)
+	}
+
+	go mediaservice.ReportProgress(cctx, reporter, f, stream.Bandwidth*int64(state.duration.Seconds())/8, note)
+
+	binary.BigEndian.PutUint32(idxbs[0:4], uint32(len(idxbs)))
+	binary.BigEndian.PutUint32(idxbs[4:8], uint32(resumeMagic))
+
+	for {
+		err = d.run(cctx, f, all, idx, idxbs, fragmented)
+		if err != nil {
+			return
+		}
+
+		idx = len(all)
+		known := len(state.video)
+
+		var more bool
+
+		more, err = client.waitLive(cctx, stream, state)
+		if err != nil || !more {
+			break
+		}
+
+		all = append(all, chunks(state.video, state.audio, known)...)
+	}
+
+	if err != nil {
+		return
+	}
+
+	return fragmented, stripTrailer(f, int64(len(idxbs)))
+}
+
+// stripTrailer removes resume index trailer from completely downloaded file
+func stripTrailer(f *os.File, off int64) error {
+	siz, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if siz < off {
+		return nil
+	}
+
+	return f.Truncate(siz - off)
+}
+
+// run downloads chunks starting with idx keeping workers busy, writing them in order followed by resume index
+// trailer
+func (d *downloader) run(ctx context.Context, f *os.File, all []*chunk, idx int, idxbs []byte, fragmented bool) error {
+	off := int64(len(idxbs))
+	next := idx
+
+	var inflight []*chunk
+
+	for idx < len(all) {
+		for ; next < len(all) && next-idx < d.client.Workers; next++ {
+			if err := d.submit(ctx, all[next]); err != nil {
+				return err
+			}
+
+			inflight = append(inflight, all[next])
+		}
+
+		c := inflight[0]
+		inflight = inflight[1:]
+
+		datas, err := c.wait(ctx)
+		if err != nil {
+			return err
+		}
+
+		siz, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+
+		if siz >= off {
+			if _, err = f.Seek(-off, io.SeekEnd); err != nil {
+				return err
+			}
+		}
+
+		err = writeChunk(f, c, datas, fragmented)
+		if err != nil {
+			return err
+		}
+
+		c.release()
+
+		binary.BigEndian.PutUint64(idxbs[off-8:], uint64(idx))
+
+		if _, err = f.Write(idxbs); err != nil {
+			return err
+		}
+
+		idx++
+	}
+
+	return nil
+}
+
+// writeChunk writes chunk data as is, unless separate audio rendition segments have to be combined with video
+func writeChunk(f *os.File, c *chunk, datas [][]byte, fragmented bool) (err error) {
+	switch {
+	case !fragmented, c.audio == nil:
+		for _, data := range datas {
+			if _, err = f.Write(data); err != nil {
+				return
+			}
+		}
+	case c.init:
+		err = remux.CombineInitSegments(datas, f)
+	default:
+		err = remux.CombineMediaSegments(datas, f)
+	}
+
+	return
+}
@@ -0,0 +1,404 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidPlaylist is returned when playlist is not an M3U8 playlist
+	ErrInvalidPlaylist = errors.New("invalid m3u8 playlist")
+	// ErrUnsupportedEncryption is returned when segments are encrypted with other method than AES-128
+	ErrUnsupportedEncryption = errors.New("unsupported encryption method")
+)
+
+// ByteRange describes sub-range of resource, as in EXT-X-BYTERANGE
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// Header returns HTTP Range header value
+func (r *ByteRange) Header() string {
+	return fmt.Sprintf("bytes=%d-%d", r.Offset, r.Offset+r.Length-1)
+}
+
+// Key describes segment encryption, as in EXT-X-KEY
+type Key struct {
+	Method string
+	URI    string
+	IV     []byte
+}
+
+// Segment describes media segment of media playlist
+type Segment struct {
+	Key       *Key
+	Map       *Segment
+	ByteRange *ByteRange
+	URI       string
+	Duration  time.Duration
+	Sequence  int64
+}
+
+// MediaPlaylist describes media playlist, Ended is set for VOD playlists which will not change anymore
+type MediaPlaylist struct {
+	Segments       []*Segment
+	TargetDuration time.Duration
+	MediaSequence  int64
+	Ended          bool
+}
+
+// Duration returns total duration of playlist segments
+func (p *MediaPlaylist) Duration() (dur time.Duration) {
+	for _, s := range p.Segments {
+		dur += s.Duration
+	}
+
+	return
+}
+
+// Rendition describes alternative rendition, as in EXT-X-MEDIA
+type Rendition struct {
+	Type     string
+	GroupID  string
+	Name     string
+	Language string
+	URI      string
+	Default  bool
+}
+
+// Variant describes variant stream, as in EXT-X-STREAM-INF
+type Variant struct {
+	URI              string
+	Codecs           string
+	Audio            string
+	Bandwidth        int64
+	AverageBandwidth int64
+	Width            uint64
+	Height           uint64
+}
+
+// GetBandwidth returns average bandwidth if known, peak bandwidth otherwise
+func (v *Variant) GetBandwidth() int64 {
+	if v.AverageBandwidth > 0 {
+		return v.AverageBandwidth
+	}
+
+	return v.Bandwidth
+}
+
+// MasterPlaylist describes master playlist with variant streams and their alternative renditions
+type MasterPlaylist struct {
+	Variants   []*Variant
+	Renditions []*Rendition
+}
+
+// AudioRendition returns separate audio rendition of variant, nil if audio is muxed into variant stream
+func (p *MasterPlaylist) AudioRendition(v *Variant) (res *Rendition) {
+	if v.Audio == "" {
+		return nil
+	}
+
+	for _, r := range p.Renditions {
+		if r.Type != "AUDIO" || r.GroupID != v.Audio || r.URI == "" {
+			continue
+		}
+
+		if res == nil || r.Default {
+			res = r
+		}
+	}
+
+	return
+}
+
+// parseAttributes parses attribute list, e.g. BANDWIDTH=1000,CODECS="avc1,mp4a"
+func parseAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+
+	for len(s) > 0 {
+		idx := strings.IndexByte(s, '=')
+		if idx < 0 {
+			break
+		}
+
+		name := strings.TrimSpace(s[:idx])
+		s = s[idx+1:]
+
+		var value string
+
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+
+			value, s = s[:end], s[end:]
+		}
+
+		s = strings.TrimPrefix(s, ",")
+
+		attrs[name] = value
+	}
+
+	return attrs
+}
+
+func parseByteRange(s string, last *ByteRange) (*ByteRange, error) {
+	parts := strings.SplitN(s, "@", 2)
+
+	length, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ByteRange{
+		Length: length,
+	}
+
+	switch {
+	case len(parts) == 2:
+		r.Offset, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	case last != nil:
+		r.Offset = last.Offset + last.Length
+	}
+
+	return r, nil
+}
+
+func resolve(base *url.URL, ref string) string {
+	if base == nil {
+		return ref
+	}
+
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+
+	return u.String()
+}
+
+func parseSeconds(s string) time.Duration {
+	v, _ := strconv.ParseFloat(s, 64)
+
+	return time.Duration(v * float64(time.Second))
+}
+
+func parseKey(base *url.URL, line string) (*Key, error) {
+	attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-KEY:"))
+
+	switch attrs["METHOD"] {
+	case "", "NONE":
+		return nil, nil
+	case "AES-128":
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncryption, attrs["METHOD"])
+	}
+
+	key := &Key{
+		Method: attrs["METHOD"],
+		URI:    resolve(base, attrs["URI"]),
+	}
+
+	if iv := attrs["IV"]; iv != "" {
+		bs, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X"))
+		if err != nil {
+			return nil, err
+		}
+
+		key.IV = bs
+	}
+
+	return key, nil
+}
+
+func parseMap(base *url.URL, line string) (*Segment, error) {
+	attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-MAP:"))
+
+	m := &Segment{
+		URI: resolve(base, attrs["URI"]),
+	}
+
+	if br := attrs["BYTERANGE"]; br != "" {
+		r, err := parseByteRange(br, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		m.ByteRange = r
+	}
+
+	return m, nil
+}
+
+func parseVariant(line string) *Variant {
+	attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+
+	v := &Variant{
+		Codecs: attrs["CODECS"],
+		Audio:  attrs["AUDIO"],
+	}
+
+	v.Bandwidth, _ = strconv.ParseInt(attrs["BANDWIDTH"], 10, 64)
+	v.AverageBandwidth, _ = strconv.ParseInt(attrs["AVERAGE-BANDWIDTH"], 10, 64)
+
+	if res := strings.SplitN(attrs["RESOLUTION"], "x", 2); len(res) == 2 {
+		v.Width, _ = strconv.ParseUint(res[0], 10, 64)
+		v.Height, _ = strconv.ParseUint(res[1], 10, 64)
+	}
+
+	return v
+}
+
+func parseRendition(base *url.URL, line string) *Rendition {
+	attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-MEDIA:"))
+
+	r := &Rendition{
+		Type:     attrs["TYPE"],
+		GroupID:  attrs["GROUP-ID"],
+		Name:     attrs["NAME"],
+		Language: attrs["LANGUAGE"],
+		Default:  attrs["DEFAULT"] == "YES",
+	}
+
+	if attrs["URI"] != "" {
+		r.URI = resolve(base, attrs["URI"])
+	}
+
+	return r
+}
+
+// segmentIV returns explicit key IV or one derived from media sequence number
+func segmentIV(key *Key, sequence int64) []byte {
+	if len(key.IV) > 0 {
+		return key.IV
+	}
+
+	iv := make([]byte, 16)
+
+	binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+
+	return iv
+}
+
+type mediaParser struct {
+	base    *url.URL
+	media   *MediaPlaylist
+	key     *Key
+	initseg *Segment
+	brange  *ByteRange
+	last    *ByteRange
+	dur     time.Duration
+}
+
+func (parser *mediaParser) line(line string) (err error) {
+	switch {
+	case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+		parser.media.TargetDuration = parseSeconds(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"))
+	case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+		seq := strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:")
+		parser.media.MediaSequence, err = strconv.ParseInt(seq, 10, 64)
+	case line == "#EXT-X-ENDLIST", line == "#EXT-X-PLAYLIST-TYPE:VOD":
+		parser.media.Ended = true
+	case strings.HasPrefix(line, "#EXTINF:"):
+		parser.dur = parseSeconds(strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)[0])
+	case strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
+		parser.brange, err = parseByteRange(strings.TrimPrefix(line, "#EXT-X-BYTERANGE:"), parser.last)
+	case strings.HasPrefix(line, "#EXT-X-KEY:"):
+		parser.key, err = parseKey(parser.base, line)
+	case strings.HasPrefix(line, "#EXT-X-MAP:"):
+		parser.initseg, err = parseMap(parser.base, line)
+	case strings.HasPrefix(line, "#"):
+	default:
+		seq := parser.media.MediaSequence + int64(len(parser.media.Segments))
+
+		seg := &Segment{
+			Map:       parser.initseg,
+			ByteRange: parser.brange,
+			URI:       resolve(parser.base, line),
+			Duration:  parser.dur,
+			Sequence:  seq,
+		}
+
+		if parser.key != nil {
+			seg.Key = &Key{
+				Method: parser.key.Method,
+				URI:    parser.key.URI,
+				IV:     segmentIV(parser.key, seq),
+			}
+		}
+
+		parser.media.Segments = append(parser.media.Segments, seg)
+		parser.last, parser.brange, parser.dur = parser.brange, nil, 0
+	}
+
+	return
+}
+
+// Parse parses either master or media playlist, resolving relative URIs against base
+func Parse(base *url.URL, data []byte) (master *MasterPlaylist, media *MediaPlaylist, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	if !scanner.Scan() || strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff")) != "#EXTM3U" {
+		return nil, nil, ErrInvalidPlaylist
+	}
+
+	master = &MasterPlaylist{}
+	parser := &mediaParser{
+		base:  base,
+		media: &MediaPlaylist{},
+	}
+
+	var variant *Variant
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			variant = parseVariant(line)
+		case strings.HasPrefix(line, "#EXT-X-MEDIA:"):
+			master.Renditions = append(master.Renditions, parseRendition(base, line))
+		case variant != nil && !strings.HasPrefix(line, "#"):
+			variant.URI = resolve(base, line)
+			master.Variants = append(master.Variants, variant)
+			variant = nil
+		default:
+			err = parser.line(line)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(master.Variants) > 0 {
+		return master, nil, nil
+	}
+
+	return nil, parser.media, nil
+}
@@ -0,0 +1,86 @@
+package mediaservice
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ResumedNote formats note about download resumed at given fraction, empty if download was not resumed
+func ResumedNote(done, total int64) string {
+	if done <= 0 || total <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("resumed at %2.1f%%", float64(done)/float64(total)*100)
+}
+
+// ReportProgress periodically submits download progress of file growing towards total size until context is done
+func ReportProgress(
+	ctx context.Context,
+	reporter Reporter,
+	f *os.File,
+	total int64,
+	note string,
+) {
+	t := time.NewTicker(time.Second)
+
+	defer t.Stop()
+
+	finfo, err := f.Stat()
+	if err != nil {
+		return
+	}
+
+	totalsize := HumanSizeFormat(float64(total))
+
+	for {
+		select {
+		case <-t.C:
+			old := finfo.Size()
+
+			finfo, err = f.Stat()
+			if err != nil {
+				return
+			}
+
+			diffsize := finfo.Size() - old
+
+			if diffsize == 0 {
+				continue
+			}
+
+			percent := float64(finfo.Size()) / float64(total) * 100
+
+			if percent > 100 {
+				percent = 100
+			}
+
+			speed := HumanSizeFormat(float64(diffsize))
+
+			rem := total - finfo.Size()
+
+			remain := rem / diffsize
+
+			minutes, seconds := remain/60, remain%60
+
+			msg := fmt.Sprintf(
+				"%2.1f%% of %s at %s/s ETA %02d:%02d",
+				percent,
+				totalsize,
+				speed,
+				minutes,
+				seconds,
+			)
+
+			if note != "" {
+				msg += " (" + note + ")"
+			}
+
+			reporter.Submit(msg, false)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package mediaservice
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+)
+
+// ErrUnsupportedURL is returned when no registered downloader matches given URL
+var ErrUnsupportedURL = errors.New("unsupported url")
+
+type registryEntry struct {
+	downloader Downloader
+	pattern    *regexp.Regexp
+	name       string
+}
+
+// Registry maps URL patterns to downloaders, first registered matching pattern wins
+type Registry struct {
+	entries []*registryEntry
+	m       sync.RWMutex
+}
+
+// NewRegistry provides new empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds downloader with given name handling URLs matching pattern
+func (registry *Registry) Register(name string, pattern *regexp.Regexp, downloader Downloader) {
+	registry.m.Lock()
+	defer registry.m.Unlock()
+
+	registry.entries = append(registry.entries, &registryEntry{
+		downloader: downloader,
+		pattern:    pattern,
+		name:       name,
+	})
+}
+
+// Match returns name and downloader handling given URL
+func (registry *Registry) Match(url string) (name string, downloader Downloader, err error) {
+	registry.m.RLock()
+	defer registry.m.RUnlock()
+
+	for _, e := range registry.entries {
+		if e.pattern.MatchString(url) {
+			return e.name, e.downloader, nil
+		}
+	}
+
+	return "", nil, ErrUnsupportedURL
+}
+
+// Get returns downloader registered with given name or nil
+func (registry *Registry) Get(name string) Downloader {
+	registry.m.RLock()
+	defer registry.m.RUnlock()
+
+	for _, e := range registry.entries {
+		if e.name == name {
+			return e.downloader
+		}
+	}
+
+	return nil
+}
+
+// Names returns names of registered downloaders in registration order
+func (registry *Registry) Names() (names []string) {
+	registry.m.RLock()
+	defer registry.m.RUnlock()
+
+	for _, e := range registry.entries {
+		names = append(names, e.name)
+	}
+
+	return
+}
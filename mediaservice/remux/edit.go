@@ -0,0 +1,660 @@
+package remux
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+var (
+	// ErrFragmented is returned when progressive MP4 is expected, fragmented MP4 could be converted using
+	// DefragmentMP4
+	ErrFragmented = errors.New("fragmented mp4 is not supported")
+	// ErrIncompatible is returned when concatenated files have different tracks or codec parameters
+	ErrIncompatible = errors.New("incompatible mp4 files")
+	// ErrEmptyRange is returned when trimmed range contains no samples
+	ErrEmptyRange = errors.New("empty time range")
+	// ErrTooLarge is returned when resulting file does not fit 32-bit chunk offsets
+	ErrTooLarge = errors.New("resulting file is too large")
+)
+
+// chunkDuration is the approximate duration of interleaved chunks of each track in written files
+const chunkDuration = time.Second
+
+type editSample struct {
+	offset int64
+	time   uint64
+	size   uint32
+	dur    uint32
+	cto    int32
+	src    int
+	sync   bool
+}
+
+type editTrack struct {
+	trak    *mp4.TrakBox
+	samples []editSample
+}
+
+func (track *editTrack) timescale() uint64 {
+	return uint64(track.trak.Mdia.Mdhd.Timescale)
+}
+
+func (track *editTrack) units(d time.Duration) uint64 {
+	if d <= 0 {
+		return 0
+	}
+
+	return uint64(d.Seconds() * float64(track.timescale()))
+}
+
+func (track *editTrack) duration(units uint64) time.Duration {
+	return time.Duration(float64(units) / float64(track.timescale()) * float64(time.Second))
+}
+
+// text reports whether track is a subtitle track, which samples may be shortened
+func (track *editTrack) text() bool {
+	switch track.trak.Mdia.Hdlr.HandlerType {
+	case "sbtl", "text", "subt":
+		return true
+	}
+
+	return false
+}
+
+func decodeProgressive(src *os.File) (in *mp4.File, err error) {
+	_, err = src.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err = mp4.DecodeFile(src, mp4.WithDecodeMode(mp4.DecModeLazyMdat))
+	if err != nil {
+		return nil, err
+	}
+
+	if in.IsFragmented() {
+		return nil, ErrFragmented
+	}
+
+	if in.Moov == nil {
+		return nil, fmt.Errorf("moov not found")
+	}
+
+	return in, nil
+}
+
+func chunkOffsets(stbl *mp4.StblBox) (offsets []uint64) {
+	switch {
+	case stbl.Stco != nil:
+		for _, o := range stbl.Stco.ChunkOffset {
+			offsets = append(offsets, uint64(o))
+		}
+	case stbl.Co64 != nil:
+		offsets = append(offsets, stbl.Co64.ChunkOffset...)
+	}
+
+	return
+}
+
+// readTrack lists samples of progressive MP4 track in decoding order
+func readTrack(trak *mp4.TrakBox, src int) (track *editTrack, err error) {
+	stbl := trak.Mdia.Minf.Stbl
+	if stbl == nil || stbl.Stts == nil || stbl.Stsc == nil || stbl.Stsz == nil {
+		return nil, fmt.Errorf("sample tables not found for trak %d", trak.Tkhd.TrackID)
+	}
+
+	track = &editTrack{
+		trak:    trak,
+		samples: make([]editSample, 0, stbl.Stsz.GetNrSamples()),
+	}
+
+	var decodeTime uint64
+
+	for i, count := range stbl.Stts.SampleCount {
+		for j := uint32(0); j < count; j++ {
+			nr := uint32(len(track.samples) + 1)
+
+			s := editSample{
+				time: decodeTime,
+				size: stbl.Stsz.SampleUniformSize,
+				dur:  stbl.Stts.SampleTimeDelta[i],
+				src:  src,
+				sync: stbl.Stss == nil || stbl.Stss.IsSyncSample(nr),
+			}
+
+			if len(stbl.Stsz.SampleSize) > 0 {
+				s.size = stbl.Stsz.SampleSize[nr-1]
+			}
+
+			if stbl.Ctts != nil {
+				s.cto = stbl.Ctts.GetCompositionTimeOffset(nr)
+			}
+
+			decodeTime += uint64(s.dur)
+			track.samples = append(track.samples, s)
+		}
+	}
+
+	var sample int
+
+	for chunkNr, offset := range chunkOffsets(stbl) {
+		chunk := stbl.Stsc.GetChunk(uint32(chunkNr + 1))
+
+		for j := uint32(0); j < chunk.NrSamples && sample < len(track.samples); j++ {
+			track.samples[sample].offset = int64(offset)
+			offset += uint64(track.samples[sample].size)
+			sample++
+		}
+	}
+
+	if sample != len(track.samples) {
+		return nil, fmt.Errorf("sample tables of trak %d are inconsistent", trak.Tkhd.TrackID)
+	}
+
+	return track, nil
+}
+
+func readTracks(in *mp4.File, src int) (tracks []*editTrack, err error) {
+	for _, trak := range in.Moov.Traks {
+		var track *editTrack
+
+		track, err = readTrack(trak, src)
+		if err != nil {
+			return nil, err
+		}
+
+		tracks = append(tracks, track)
+	}
+
+	return
+}
+
+// editWriter builds sample tables of written tracks and copy ranges of their samples
+type editWriter struct {
+	traks   []*mp4.TrakBox
+	chunkid []uint32
+	offset  uint64
+}
+
+// order is a single copy range of given source, in order of output
+type order struct {
+	copyrange
+	src int
+}
+
+func (w *editWriter) chunk(trackid int, samples []editSample, orders *[]order) error {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	stbl := w.traks[trackid].Mdia.Minf.Stbl
+
+	w.chunkid[trackid]++
+
+	count := uint32(len(samples))
+
+	if len(stbl.Stsc.Entries) == 0 || stbl.Stsc.Entries[len(stbl.Stsc.Entries)-1].SamplesPerChunk != count {
+		err := stbl.Stsc.AddEntry(w.chunkid[trackid], count, 1)
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.offset > math.MaxUint32 {
+		return ErrTooLarge
+	}
+
+	stbl.Stco.ChunkOffset = append(stbl.Stco.ChunkOffset, uint32(w.offset))
+
+	for _, s := range samples {
+		last := len(*orders) - 1
+
+		if last >= 0 && (*orders)[last].src == s.src &&
+			(*orders)[last].Offset+(*orders)[last].Length == s.offset {
+			(*orders)[last].Length += int64(s.size)
+		} else {
+			*orders = append(*orders, order{
+				copyrange: copyrange{
+					Offset: s.offset,
+					Length: int64(s.size),
+				},
+				src: s.src,
+			})
+		}
+
+		w.offset += uint64(s.size)
+	}
+
+	return nil
+}
+
+func (w *editWriter) tables(trackid int, samples []editSample) (err error) {
+	stbl := w.traks[trackid].Mdia.Minf.Stbl
+
+	for i, s := range samples {
+		idx := len(stbl.Stts.SampleTimeDelta) - 1
+
+		if idx < 0 || stbl.Stts.SampleTimeDelta[idx] != s.dur {
+			stbl.Stts.SampleCount = append(stbl.Stts.SampleCount, 1)
+			stbl.Stts.SampleTimeDelta = append(stbl.Stts.SampleTimeDelta, s.dur)
+		} else {
+			stbl.Stts.SampleCount[idx]++
+		}
+
+		if stbl.Ctts != nil {
+			err = stbl.Ctts.AddSampleCountsAndOffset([]uint32{1}, []int32{s.cto})
+			if err != nil {
+				return
+			}
+		}
+
+		if stbl.Stss != nil && s.sync {
+			stbl.Stss.SampleNumber = append(stbl.Stss.SampleNumber, uint32(i+1))
+		}
+
+		stbl.Stsz.SampleNumber++
+		stbl.Stsz.SampleSize = append(stbl.Stsz.SampleSize, s.size)
+	}
+
+	return
+}
+
+// interleave places samples of all tracks into chunks of about chunkDuration, returning copy ranges in
+// output order
+func (w *editWriter) interleave(tracks []*editTrack) (orders []order, err error) {
+	pos := make([]int, len(tracks))
+
+	for window := time.Duration(0); ; window += chunkDuration {
+		done := true
+
+		for trackid, track := range tracks {
+			limit := track.units(window + chunkDuration)
+			from := pos[trackid]
+
+			for pos[trackid] < len(track.samples) && track.samples[pos[trackid]].time < limit {
+				pos[trackid]++
+			}
+
+			err = w.chunk(trackid, track.samples[from:pos[trackid]], &orders)
+			if err != nil {
+				return nil, err
+			}
+
+			if pos[trackid] < len(track.samples) {
+				done = false
+			}
+		}
+
+		if done {
+			return orders, nil
+		}
+	}
+}
+
+// writeEdit writes progressive MP4 with moov placed before media data, containing given tracks which samples
+// are copied from srcs
+func writeEdit(dst *os.File, srcs []*os.File, in *mp4.File, tracks []*editTrack) (err error) {
+	moov := mp4.NewMoovBox()
+	moov.AddChild(in.Moov.Mvhd)
+
+	for _, track := range tracks {
+		sync := false
+
+		for _, s := range track.samples {
+			if !s.sync {
+				sync = true
+
+				break
+			}
+		}
+
+		var trak *mp4.TrakBox
+
+		trak, err = emptyTrak(track.trak, sync)
+		if err != nil {
+			return
+		}
+
+		moov.AddChild(trak)
+	}
+
+	for _, c := range in.Moov.Children {
+		switch c.Type() {
+		case "mvhd", "trak", "mvex":
+		default:
+			moov.AddChild(c)
+		}
+	}
+
+	w := &editWriter{
+		traks:   moov.Traks,
+		chunkid: make([]uint32, len(moov.Traks)),
+	}
+
+	moov.Mvhd.Duration = 0
+
+	for trackid, track := range tracks {
+		err = w.tables(trackid, track.samples)
+		if err != nil {
+			return
+		}
+
+		editDurations(moov, moov.Traks[trackid], track)
+	}
+
+	orders, err := w.interleave(tracks)
+	if err != nil {
+		return
+	}
+
+	ftyp := in.Ftyp
+	if ftyp == nil {
+		ftyp = mp4.NewFtyp("isom", 512, []string{"isom", "iso2", "avc1", "mp41"})
+	}
+
+	mdat := &mp4.MdatBox{}
+	mdat.SetLazyDataSize(w.offset)
+
+	mdatoffset := ftyp.Size() + moov.Size() + mdat.Size() - mdat.GetLazyDataSize()
+
+	if mdatoffset+w.offset > math.MaxUint32 {
+		return ErrTooLarge
+	}
+
+	for _, trak := range moov.Traks {
+		for idx := range trak.Mdia.Minf.Stbl.Stco.ChunkOffset {
+			trak.Mdia.Minf.Stbl.Stco.ChunkOffset[idx] += uint32(mdatoffset)
+		}
+	}
+
+	for _, b := range []mp4.Box{ftyp, moov, mdat} {
+		err = b.Encode(dst)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, o := range orders {
+		_, err = srcs[o.src].Seek(o.Offset, io.SeekStart)
+		if err != nil {
+			return
+		}
+
+		_, err = dst.ReadFrom(io.LimitReader(srcs[o.src], o.Length))
+		if err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+// editDurations updates durations of written trak and movie, keeping composition offset shift of edit list
+func editDurations(moov *mp4.MoovBox, trak *mp4.TrakBox, track *editTrack) {
+	var units uint64
+
+	for _, s := range track.samples {
+		units += uint64(s.dur)
+	}
+
+	trak.Mdia.Mdhd.Duration = units
+	trak.Tkhd.Duration = units * uint64(moov.Mvhd.Timescale) / track.timescale()
+
+	var mediaTime int64
+
+	for _, e := range trak.Edts.Elst[0].Entries {
+		if e.MediaTime >= 0 {
+			mediaTime = e.MediaTime
+
+			break
+		}
+	}
+
+	trak.Edts.Elst[0].Entries = []mp4.ElstEntry{
+		{
+			SegmentDuration:   trak.Tkhd.Duration,
+			MediaTime:         mediaTime,
+			MediaRateInteger:  1,
+			MediaRateFraction: 0,
+		},
+	}
+
+	if trak.Tkhd.Duration > moov.Mvhd.Duration {
+		moov.Mvhd.Duration = trak.Tkhd.Duration
+	}
+}
+
+// trimStart returns start time of keyframe at or before given time in reference track
+func trimStart(ref *editTrack, start time.Duration) (time.Duration, error) {
+	units := ref.units(start)
+	idx := -1
+
+	for i, s := range ref.samples {
+		if s.time > units {
+			break
+		}
+
+		if s.sync {
+			idx = i
+		}
+	}
+
+	if idx < 0 {
+		if len(ref.samples) == 0 {
+			return 0, ErrEmptyRange
+		}
+
+		idx = 0
+	}
+
+	return ref.duration(ref.samples[idx].time), nil
+}
+
+// trimTrack leaves only samples in given range, rebasing their decode times
+func trimTrack(track *editTrack, start, end time.Duration) {
+	from, to := track.units(start), uint64(math.MaxUint64)
+
+	if end > 0 {
+		to = track.units(end)
+	}
+
+	var samples []editSample
+
+	for _, s := range track.samples {
+		if s.time >= to {
+			break
+		}
+
+		if s.time < from {
+			// subtitle shown at the start of range is kept, shortened to the range
+			if track.text() && s.time+uint64(s.dur) > from {
+				s.dur -= uint32(from - s.time)
+				s.time = from
+				samples = append(samples, s)
+			}
+
+			continue
+		}
+
+		if len(samples) == 0 && !s.sync && !track.text() {
+			continue
+		}
+
+		samples = append(samples, s)
+	}
+
+	for i := range samples {
+		samples[i].time -= from
+	}
+
+	track.samples = samples
+}
+
+// Trim writes part of progressive MP4 in src between start and end to dst without re-encoding. Start is moved
+// back to the nearest preceding keyframe of the video track, zero end means end of file.
+func Trim(src, dst *os.File, start, end time.Duration) (err error) {
+	in, err := decodeProgressive(src)
+	if err != nil {
+		return
+	}
+
+	tracks, err := readTracks(in, 0)
+	if err != nil {
+		return
+	}
+
+	if len(tracks) == 0 {
+		return ErrEmptyRange
+	}
+
+	ref := tracks[0]
+
+	for _, track := range tracks {
+		if track.trak.Mdia.Hdlr.HandlerType == "vide" {
+			ref = track
+
+			break
+		}
+	}
+
+	start, err = trimStart(ref, start)
+	if err != nil {
+		return
+	}
+
+	if end > 0 && end <= start {
+		return ErrEmptyRange
+	}
+
+	for _, track := range tracks {
+		trimTrack(track, start, end)
+	}
+
+	if len(ref.samples) == 0 {
+		return ErrEmptyRange
+	}
+
+	return writeEdit(dst, []*os.File{src}, in, tracks)
+}
+
+func encodeBox(b mp4.Box) []byte {
+	buf := &bytes.Buffer{}
+
+	_ = b.Encode(buf)
+
+	return buf.Bytes()
+}
+
+// compatible checks that file tracks match tracks of the first file
+func compatible(first, other *mp4.File) error {
+	if len(first.Moov.Traks) != len(other.Moov.Traks) {
+		return fmt.Errorf("%w: %d tracks != %d tracks", ErrIncompatible, len(other.Moov.Traks), len(first.Moov.Traks))
+	}
+
+	for i, a := range first.Moov.Traks {
+		b := other.Moov.Traks[i]
+
+		if a.Mdia.Hdlr.HandlerType != b.Mdia.Hdlr.HandlerType {
+			return fmt.Errorf("%w: track %d is %s, not %s", ErrIncompatible, i+1, b.Mdia.Hdlr.HandlerType,
+				a.Mdia.Hdlr.HandlerType)
+		}
+
+		if a.Mdia.Mdhd.Timescale != b.Mdia.Mdhd.Timescale {
+			return fmt.Errorf("%w: track %d timescale differs", ErrIncompatible, i+1)
+		}
+
+		if !bytes.Equal(encodeBox(a.Mdia.Minf.Stbl.Stsd), encodeBox(b.Mdia.Minf.Stbl.Stsd)) {
+			return fmt.Errorf("%w: track %d codec parameters differ", ErrIncompatible, i+1)
+		}
+	}
+
+	return nil
+}
+
+// Concat writes progressive MP4 files in srcs one after another to dst without re-encoding, all files must have
+// the same tracks with the same codec parameters
+func Concat(dst *os.File, srcs ...*os.File) (err error) {
+	if len(srcs) == 0 {
+		return ErrEmptyRange
+	}
+
+	var (
+		first  *mp4.File
+		tracks []*editTrack
+	)
+
+	for i, src := range srcs {
+		var in *mp4.File
+
+		in, err = decodeProgressive(src)
+		if err != nil {
+			return fmt.Errorf("%s: %w", src.Name(), err)
+		}
+
+		var filetracks []*editTrack
+
+		filetracks, err = readTracks(in, i)
+		if err != nil {
+			return fmt.Errorf("%s: %w", src.Name(), err)
+		}
+
+		if first == nil {
+			first, tracks = in, filetracks
+
+			continue
+		}
+
+		err = compatible(first, in)
+		if err != nil {
+			return fmt.Errorf("%s: %w", src.Name(), err)
+		}
+
+		for trackid, track := range filetracks {
+			var base uint64
+
+			if n := len(tracks[trackid].samples); n > 0 {
+				last := tracks[trackid].samples[n-1]
+				base = last.time + uint64(last.dur)
+			}
+
+			for _, s := range track.samples {
+				s.time += base
+				tracks[trackid].samples = append(tracks[trackid].samples, s)
+			}
+		}
+	}
+
+	return writeEdit(dst, srcs, first, tracks)
+}
+
+// Faststart rewrites MP4 in src to dst with moov placed before media data, so it could be played while being
+// downloaded. Fragmented MP4 is defragmented.
+func Faststart(src, dst *os.File) (err error) {
+	_, err = src.Seek(0, io.SeekStart)
+	if err != nil {
+		return
+	}
+
+	in, err := mp4.DecodeFile(src, mp4.WithDecodeMode(mp4.DecModeLazyMdat))
+	if err != nil {
+		return
+	}
+
+	if in.IsFragmented() {
+		_, err = src.Seek(0, io.SeekStart)
+		if err != nil {
+			return
+		}
+
+		return DefragmentMP4(src, dst, nil)
+	}
+
+	return remuxMP4Subtitles(in, src, dst, nil)
+}
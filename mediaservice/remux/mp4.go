@@ -1,4 +1,5 @@
-package nicovideo
+// Package remux provides MP4 remuxing without re-encoding
+package remux
 
 import (
 	"fmt"
@@ -10,7 +11,8 @@ import (
 	"github.com/Eyevinn/mp4ff/mp4"
 )
 
-func combineInitSegments(files [][]byte, w io.Writer) (err error) {
+// CombineInitSegments combines single-track fMP4 init segments into multi-track init segment
+func CombineInitSegments(files [][]byte, w io.Writer) (err error) {
 	var combinedInit *mp4.InitSegment
 
 	for i, data := range files {
@@ -81,7 +83,8 @@ func combineMediaSegmentsUpdateSidx(seg *mp4.MediaSegment, frag *mp4.Fragment) {
 	}
 }
 
-func combineMediaSegments(files [][]byte, w io.WriteCloser) error {
+// CombineMediaSegments combines single-track fMP4 media segments into multi-track media segment
+func CombineMediaSegments(files [][]byte, w io.WriteCloser) error {
 	var idx []uint32
 
 	for i := range files {
@@ -252,76 +255,86 @@ func defragmentMP4Udat(out *mp4.MoovBox, metadata map[string]string) {
 	out.AddChild(udta)
 }
 
-func defragmentMP4Moov(in *mp4.MoovBox, metadata map[string]string) (out *mp4.MoovBox, err error) {
-	out = mp4.NewMoovBox()
-	out.AddChild(in.Mvhd)
+// emptyTrak returns copy of trak with empty sample tables, sync sample table is added if sync is set
+func emptyTrak(oldtrak *mp4.TrakBox, sync bool) (newtrak *mp4.TrakBox, err error) {
+	newtrak, err = defragmentMP4Trak(oldtrak)
+	if err != nil {
+		return
+	}
 
-	out.Mvhd.Timescale = 1000
+	for _, c := range oldtrak.Mdia.GetChildren() {
+		switch c.Type() {
+		case "minf":
+			newtrak.Mdia.AddChild(mp4.NewMinfBox())
+		default:
+			newtrak.Mdia.AddChild(c)
+		}
+	}
 
-	for i, oldtrak := range in.Traks {
-		var newtrak *mp4.TrakBox
+	if newtrak.Mdia.Mdhd == nil {
+		return nil, fmt.Errorf("mdhd not found for trak %d", oldtrak.Tkhd.TrackID)
+	}
 
-		newtrak, err = defragmentMP4Trak(oldtrak)
-		if err != nil {
-			return
-		}
+	if newtrak.Mdia.Minf == nil {
+		return nil, fmt.Errorf("minf not found for trak %d", oldtrak.Tkhd.TrackID)
+	}
 
-		for _, c := range oldtrak.Mdia.GetChildren() {
-			switch c.Type() {
-			case "minf":
-				newtrak.Mdia.AddChild(mp4.NewMinfBox())
-			default:
-				newtrak.Mdia.AddChild(c)
-			}
-		}
+	if newtrak.Mdia.Hdlr == nil {
+		return nil, fmt.Errorf("hdlr not found for trak %d", oldtrak.Tkhd.TrackID)
+	}
 
-		if newtrak.Mdia.Mdhd == nil {
-			return nil, fmt.Errorf("mdhd not found for trak %d", oldtrak.Tkhd.TrackID)
+	for _, c := range oldtrak.Mdia.Minf.GetChildren() {
+		switch c.Type() {
+		case "stbl":
+			newtrak.Mdia.Minf.AddChild(mp4.NewStblBox())
+		default:
+			newtrak.Mdia.Minf.AddChild(c)
 		}
+	}
 
-		if newtrak.Mdia.Minf == nil {
-			return nil, fmt.Errorf("minf not found for trak %d", oldtrak.Tkhd.TrackID)
-		}
+	stbl := newtrak.Mdia.Minf.Stbl
+	if stbl == nil {
+		return nil, fmt.Errorf("stbl not found for trak %d", oldtrak.Tkhd.TrackID)
+	}
 
-		if newtrak.Mdia.Hdlr == nil {
-			return nil, fmt.Errorf("hdlr not found for trak %d", oldtrak.Tkhd.TrackID)
-		}
+	stbl.AddChild(oldtrak.Mdia.Minf.Stbl.Stsd)
+	stbl.AddChild(&mp4.SttsBox{})
 
-		for _, c := range oldtrak.Mdia.Minf.GetChildren() {
-			switch c.Type() {
-			case "stbl":
-				newtrak.Mdia.Minf.AddChild(mp4.NewStblBox())
-			default:
-				newtrak.Mdia.Minf.AddChild(c)
-			}
-		}
+	if sync {
+		stbl.AddChild(&mp4.StssBox{})
+	}
 
-		stbl := newtrak.Mdia.Minf.Stbl
-		if stbl == nil {
-			return nil, fmt.Errorf("stbl not found for trak %d", oldtrak.Tkhd.TrackID)
-		}
+	if newtrak.Mdia.Hdlr.HandlerType == "vide" {
+		stbl.AddChild(&mp4.CttsBox{})
+	}
 
-		stbl.AddChild(oldtrak.Mdia.Minf.Stbl.Stsd)
-		stbl.AddChild(&mp4.SttsBox{})
+	stbl.AddChild(&mp4.StscBox{})
+	stbl.AddChild(&mp4.StszBox{})
+	stbl.AddChild(&mp4.StcoBox{})
 
-		if i == 0 {
-			stbl.AddChild(&mp4.StssBox{})
+	for _, c := range stbl.GetChildren() {
+		switch c.Type() {
+		case "stsd", "stts", "stss", "ctts", "stsc", "stsz", "stco":
+		default:
+			stbl.AddChild(c)
 		}
+	}
 
-		if newtrak.Mdia.Hdlr.HandlerType == "vide" {
-			stbl.AddChild(&mp4.CttsBox{})
-		}
+	return
+}
 
-		stbl.AddChild(&mp4.StscBox{})
-		stbl.AddChild(&mp4.StszBox{})
-		stbl.AddChild(&mp4.StcoBox{})
+func defragmentMP4Moov(in *mp4.MoovBox, metadata map[string]string) (out *mp4.MoovBox, err error) {
+	out = mp4.NewMoovBox()
+	out.AddChild(in.Mvhd)
 
-		for _, c := range stbl.GetChildren() {
-			switch c.Type() {
-			case "stsd", "stts", "stss", "ctts", "stsc", "stsz", "stco":
-			default:
-				stbl.AddChild(c)
-			}
+	out.Mvhd.Timescale = 1000
+
+	for i, oldtrak := range in.Traks {
+		var newtrak *mp4.TrakBox
+
+		newtrak, err = emptyTrak(oldtrak, i == 0)
+		if err != nil {
+			return
 		}
 
 		out.AddChild(newtrak)
@@ -560,7 +573,11 @@ func (defrag *defragmenter) next(tracksamples [][]sampleoffset, traks []*mp4.Tra
 	return
 }
 
-func defragmentMP4(in *mp4.File, metadata map[string]string) (out *mp4.File, target []copyrange, err error) {
+func defragmentMP4(
+	in *mp4.File,
+	metadata map[string]string,
+	subtitles []*SubtitleTrack,
+) (out *mp4.File, target []copyrange, tail []byte, err error) {
 	out = mp4.NewFile()
 	out.AddChild(mp4.NewFtyp("isom", 512, []string{"isom", "iso2", "avc1", "mp41"}), 0)
 
@@ -572,7 +589,9 @@ func defragmentMP4(in *mp4.File, metadata map[string]string) (out *mp4.File, tar
 	out.Moov = moov
 	out.Children = append(out.Children, moov)
 
-	out.AddChild(&mp4.MdatBox{}, 0)
+	mdat := &mp4.MdatBox{}
+
+	out.AddChild(mdat, 0)
 
 	defrag := defragmenter{
 		durations:  make([]time.Duration, len(moov.Traks)),
@@ -641,7 +660,13 @@ func defragmentMP4(in *mp4.File, metadata map[string]string) (out *mp4.File, tar
 		}
 	}
 
-	mdatoffset := uint32(out.Ftyp.Size() + moov.Size() + 8)
+	if len(subtitles) > 0 {
+		tail = addSubtitleTraks(moov, subtitles, defrag.offset)
+	}
+
+	mdat.SetLazyDataSize(uint64(defrag.offset) + uint64(len(tail)))
+
+	mdatoffset := uint32(out.Ftyp.Size() + moov.Size() + mdat.Size() - mdat.GetLazyDataSize())
 
 	for _, trak := range moov.Traks {
 		for idx := range trak.Mdia.Minf.Stbl.Stco.ChunkOffset {
@@ -649,16 +674,21 @@ func defragmentMP4(in *mp4.File, metadata map[string]string) (out *mp4.File, tar
 		}
 	}
 
-	return out, defrag.target, nil
+	return out, defrag.target, tail, nil
 }
 
-// DefragmentMP4 defragments MP4 in src writing resulting progressive MP4 to dst using native OS copy
-func DefragmentMP4(src, dst *os.File, metadata map[string]string) (err error) {
+// DefragmentMP4 defragments MP4 in src writing resulting progressive MP4 to dst using native OS copy,
+// given subtitles are muxed as timed text tracks
+func DefragmentMP4(src, dst *os.File, metadata map[string]string, subtitles ...*SubtitleTrack) (err error) {
 	in, err := mp4.DecodeFile(src, mp4.WithDecodeMode(mp4.DecModeLazyMdat))
 	if err != nil {
 		return
 	}
 
+	if !in.IsFragmented() && len(subtitles) > 0 {
+		return remuxMP4Subtitles(in, src, dst, subtitles)
+	}
+
 	if !in.IsFragmented() {
 		_, err = src.Seek(0, io.SeekStart)
 		if err != nil {
@@ -673,7 +703,7 @@ func DefragmentMP4(src, dst *os.File, metadata map[string]string) (err error) {
 		return
 	}
 
-	out, target, err := defragmentMP4(in, metadata)
+	out, target, tail, err := defragmentMP4(in, metadata, subtitles)
 	if err != nil {
 		return
 	}
@@ -695,5 +725,7 @@ func DefragmentMP4(src, dst *os.File, metadata map[string]string) (err error) {
 		}
 	}
 
-	return nil
+	_, err = dst.Write(tail)
+
+	return err
 }
@@ -0,0 +1,289 @@
+package remux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Eyevinn/mp4ff/bits"
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// tx3gFontName is the font requested by muxed subtitle tracks
+const tx3gFontName = "Sans"
+
+// SubtitleCue represents single timed text sample
+type SubtitleCue struct {
+	Text  string
+	Start time.Duration
+	End   time.Duration
+}
+
+// SubtitleTrack represents timed text track to be muxed into MP4, language is ISO 639-2/T code
+type SubtitleTrack struct {
+	Language string
+	Cues     []SubtitleCue
+}
+
+// tx3gBox is 3GPP timed text sample entry with centered bottom-aligned white text
+type tx3gBox struct{}
+
+func (b *tx3gBox) Type() string {
+	return "tx3g"
+}
+
+func (b *tx3gBox) Size() uint64 {
+	// header, sample entry, display flags, justification, background, text box, style record and font table
+	return 8 + 8 + 4 + 2 + 4 + 8 + 12 + 8 + 2 + 3 + uint64(len(tx3gFontName))
+}
+
+func (b *tx3gBox) Encode(w io.Writer) error {
+	sw := bits.NewFixedSliceWriter(int(b.Size()))
+
+	err := b.EncodeSW(sw)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(sw.Bytes())
+
+	return err
+}
+
+func (b *tx3gBox) EncodeSW(sw bits.SliceWriter) error {
+	err := mp4.EncodeHeaderSW(b, sw)
+	if err != nil {
+		return err
+	}
+
+	// sample entry: reserved bytes and data reference index
+	sw.WriteZeroBytes(6)
+	sw.WriteUint16(1)
+	// display flags, horizontal center and vertical bottom justification, transparent background
+	sw.WriteUint32(0)
+	sw.WriteUint8(1)
+	sw.WriteUint8(0xFF)
+	sw.WriteUint32(0)
+	// default text box
+	sw.WriteZeroBytes(8)
+	// style record: all characters, font 1, plain, 18pt, opaque white
+	sw.WriteUint16(0)
+	sw.WriteUint16(0)
+	sw.WriteUint16(1)
+	sw.WriteUint8(0)
+	sw.WriteUint8(18)
+	sw.WriteUint32(0xFFFFFFFF)
+	// font table
+	sw.WriteUint32(uint32(8 + 2 + 3 + len(tx3gFontName)))
+	sw.WriteString("ftab", false)
+	sw.WriteUint16(1)
+	sw.WriteUint16(1)
+	sw.WriteUint8(uint8(len(tx3gFontName)))
+	sw.WriteString(tx3gFontName, false)
+
+	return sw.AccError()
+}
+
+func (b *tx3gBox) Info(w io.Writer, specificBoxLevels, indent, indentStep string) error {
+	_, err := fmt.Fprintf(w, "%s[%s] size=%d\n", indent, b.Type(), b.Size())
+
+	return err
+}
+
+// subtitleTrak creates timed text trak with samples covering duration, returning trak with single chunk
+// at zero offset and its sample data
+func subtitleTrak(trackID uint32, track *SubtitleTrack, duration time.Duration) (trak *mp4.TrakBox, data []byte) {
+	lang := track.Language
+	if len(lang) != 3 {
+		lang = "und"
+	}
+
+	trak = mp4.CreateEmptyTrak(trackID, 1000, "sbtl", lang)
+	trak.Tkhd.Flags = 0x000003
+	trak.Mdia.Minf.Stbl.Stsd.AddChild(&tx3gBox{})
+
+	stbl := trak.Mdia.Minf.Stbl
+
+	var pos time.Duration
+
+	sample := func(text string, dur time.Duration) {
+		if dur <= 0 {
+			return
+		}
+
+		if len(text) > 0xFFFF {
+			text = strings.ToValidUTF8(text[:0xFFFF], "")
+		}
+
+		var size [2]byte
+
+		binary.BigEndian.PutUint16(size[:], uint16(len(text)))
+
+		data = append(data, size[:]...)
+		data = append(data, text...)
+
+		stbl.Stts.SampleCount = append(stbl.Stts.SampleCount, 1)
+		stbl.Stts.SampleTimeDelta = append(stbl.Stts.SampleTimeDelta, uint32(dur/time.Millisecond))
+		stbl.Stsz.SampleNumber++
+		stbl.Stsz.SampleSize = append(stbl.Stsz.SampleSize, uint32(2+len(text)))
+
+		pos += dur
+	}
+
+	for _, cue := range track.Cues {
+		start := cue.Start.Truncate(time.Millisecond)
+		end := cue.End.Truncate(time.Millisecond)
+
+		if start < pos {
+			start = pos
+		}
+
+		sample("", start-pos)
+		sample(cue.Text, end-start)
+	}
+
+	sample("", duration.Truncate(time.Millisecond)-pos)
+
+	if stbl.Stsz.SampleNumber > 0 {
+		_ = stbl.Stsc.AddEntry(1, stbl.Stsz.SampleNumber, 1)
+
+		stbl.Stco.ChunkOffset = append(stbl.Stco.ChunkOffset, 0)
+	}
+
+	trak.Tkhd.Duration = uint64(pos / time.Millisecond)
+	trak.Mdia.Mdhd.Duration = trak.Tkhd.Duration
+
+	return trak, data
+}
+
+// addSubtitleTraks appends subtitle traks to moov, placing their samples at given offset, returns sample data
+func addSubtitleTraks(moov *mp4.MoovBox, subtitles []*SubtitleTrack, offset uint32) (data []byte) {
+	duration := time.Duration(moov.Mvhd.Duration) * time.Second / time.Duration(moov.Mvhd.Timescale)
+
+	var nextID uint32
+
+	for _, trak := range moov.Traks {
+		if trak.Tkhd.TrackID > nextID {
+			nextID = trak.Tkhd.TrackID
+		}
+	}
+
+	for _, track := range subtitles {
+		nextID++
+
+		trak, bs := subtitleTrak(nextID, track, duration)
+
+		trak.Tkhd.Duration = trak.Tkhd.Duration * uint64(moov.Mvhd.Timescale) / 1000
+
+		for i := range trak.Mdia.Minf.Stbl.Stco.ChunkOffset {
+			trak.Mdia.Minf.Stbl.Stco.ChunkOffset[i] = offset + uint32(len(data))
+		}
+
+		data = append(data, bs...)
+
+		moov.AddChild(trak)
+	}
+
+	moov.Mvhd.NextTrackID = nextID + 1
+
+	return
+}
+
+// remuxMP4Subtitles rewrites progressive MP4 with moov placed before media data and given subtitle tracks added,
+// keeping media data intact
+func remuxMP4Subtitles(in *mp4.File, src, dst *os.File, subtitles []*SubtitleTrack) (err error) {
+	if in.Moov == nil || in.Mdat == nil || in.Ftyp == nil {
+		return fmt.Errorf("unsupported mp4 layout")
+	}
+
+	oldoffset := int64(in.Mdat.PayloadAbsoluteOffset())
+
+	payload := in.Mdat.GetLazyDataSize()
+	if payload == 0 {
+		payload = in.Mdat.DataLength()
+	}
+
+	data := addSubtitleTraks(in.Moov, subtitles, uint32(payload))
+
+	mdat := &mp4.MdatBox{}
+	mdat.SetLazyDataSize(payload + uint64(len(data)))
+
+	newoffset := int64(in.Ftyp.Size() + in.Moov.Size() + mdat.Size() - mdat.GetLazyDataSize())
+	delta := newoffset - oldoffset
+
+	for i, trak := range in.Moov.Traks {
+		stbl := trak.Mdia.Minf.Stbl
+
+		if i >= len(in.Moov.Traks)-len(subtitles) {
+			delta = newoffset
+		}
+
+		if stbl.Stco != nil {
+			for idx := range stbl.Stco.ChunkOffset {
+				stbl.Stco.ChunkOffset[idx] = uint32(int64(stbl.Stco.ChunkOffset[idx]) + delta)
+			}
+		}
+
+		if stbl.Co64 != nil {
+			for idx := range stbl.Co64.ChunkOffset {
+				stbl.Co64.ChunkOffset[idx] = uint64(int64(stbl.Co64.ChunkOffset[idx]) + delta)
+			}
+		}
+	}
+
+	for _, b := range []mp4.Box{in.Ftyp, in.Moov, mdat} {
+		err = b.Encode(dst)
+		if err != nil {
+			return
+		}
+	}
+
+	_, err = src.Seek(oldoffset, io.SeekStart)
+	if err != nil {
+		return
+	}
+
+	_, err = dst.ReadFrom(io.LimitReader(src, int64(payload)))
+	if err != nil {
+		return
+	}
+
+	_, err = dst.Write(data)
+
+	return
+}
+
+// MuxSubtitles adds subtitle tracks to progressive or fragmented MP4 file in place
+func MuxSubtitles(path string, subtitles []*SubtitleTrack) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = src.Close()
+	}()
+
+	dst, err := os.OpenFile(path+".mux", os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = DefragmentMP4(src, dst, nil, subtitles...)
+
+	cerr := dst.Close()
+	if err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		_ = os.Remove(dst.Name())
+
+		return err
+	}
+
+	return os.Rename(dst.Name(), path)
+}
@@ -4,6 +4,8 @@ package nicopost
 import (
 	"bytes"
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"math"
 	"net/url"
@@ -121,14 +123,23 @@ func SaveFilepath(savedir, uri, format string) string {
 		fmn = FilenameSanitize(format)
 	}
 
-	var id string
+	return filepath.Join(savedir, MediaID(uri)+"-"+fmn+".mp4")
+}
+
+// MediaID returns file name friendly id of media at given URL: video id for nicovideo, host and URL hash otherwise
+func MediaID(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return FilenameSanitize(path.Base(uri))
+	}
 
-	u, _ := url.Parse(uri)
-	if u != nil {
-		id = path.Base(u.Path)
+	if nicovideo.URLPattern.MatchString(uri) {
+		return path.Base(u.Path)
 	}
 
-	return filepath.Join(savedir, id+"-"+fmn+".mp4")
+	sum := sha1.Sum([]byte(uri))
+
+	return FilenameSanitize(u.Hostname()) + "-" + hex.EncodeToString(sum[:])[:12]
 }
 
 // GlobFind tries to find existing file with provided parent dir and file format id
@@ -147,6 +158,20 @@ func GlobFind(dir, fileFormatID string) (string, error) {
 	return "", nil
 }
 
+// GlobFindPartial tries to find partially downloaded file with provided parent dir and file format id
+func GlobFindPartial(dir, fileFormatID string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, fileFormatID) + "*.part")
+	if err != nil {
+		return "", err
+	}
+
+	if len(matches) == 0 {
+		return "", nil
+	}
+
+	return matches[0], nil
+}
+
 // FormatFileID formats video file id joined with media format id
 func FormatFileID(fileID, format string) string {
 	if format == "" {
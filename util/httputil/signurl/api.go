@@ -0,0 +1,69 @@
+// Package signurl provides HMAC-signed expiring URLs
+package signurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Query parameters of signed URLs
+const (
+	ParamExpires   = "expires"
+	ParamSignature = "signature"
+)
+
+var (
+	// ErrInvalidSignature is returned when URL signature is missing or does not match
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrExpired is returned when signed URL is past its expiration time
+	ErrExpired = errors.New("link expired")
+)
+
+// Signer signs and verifies URL paths with expiration time
+type Signer struct {
+	Key []byte
+}
+
+func (s *Signer) mac(path string, expires int64) []byte {
+	h := hmac.New(sha256.New, s.Key)
+
+	_, _ = h.Write([]byte(path))
+	_, _ = h.Write([]byte{'\n'})
+	_, _ = h.Write([]byte(strconv.FormatInt(expires, 10)))
+
+	return h.Sum(nil)
+}
+
+// Sign returns query string signing path until expiration time
+func (s *Signer) Sign(path string, expires time.Time) string {
+	exp := expires.Unix()
+
+	return url.Values{
+		ParamExpires:   []string{strconv.FormatInt(exp, 10)},
+		ParamSignature: []string{hex.EncodeToString(s.mac(path, exp))},
+	}.Encode()
+}
+
+// Verify checks signature of path in query, returns ErrExpired for validly signed, but expired URLs
+func (s *Signer) Verify(path string, query url.Values, now time.Time) error {
+	exp, err := strconv.ParseInt(query.Get(ParamExpires), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	sig, err := hex.DecodeString(query.Get(ParamSignature))
+	if err != nil || !hmac.Equal(sig, s.mac(path, exp)) {
+		return ErrInvalidSignature
+	}
+
+	if now.Unix() >= exp {
+		return ErrExpired
+	}
+
+	return nil
+}
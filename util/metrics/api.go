@@ -0,0 +1,313 @@
+// Package metrics provides minimal prometheus-compatible metrics registry
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets provides histogram buckets suitable for durations in seconds, from milliseconds to half an hour
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300, 1800}
+
+// Default registry used by package-level constructors
+var Default = NewRegistry()
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+type series struct {
+	values []string
+	counts []uint64
+	value  float64
+	count  uint64
+}
+
+type family struct {
+	series  map[string]*series
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	m       sync.Mutex
+}
+
+// Registry keeps registered metrics and renders them in prometheus text exposition format
+type Registry struct {
+	families []*family
+	m        sync.Mutex
+}
+
+// NewRegistry provides new empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (registry *Registry) register(name, help, kind string, buckets []float64, labels []string) *family {
+	registry.m.Lock()
+	defer registry.m.Unlock()
+
+	for _, f := range registry.families {
+		if f.name == name {
+			return f
+		}
+	}
+
+	f := &family{
+		series:  make(map[string]*series),
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+	}
+
+	registry.families = append(registry.families, f)
+
+	return f
+}
+
+// with returns series for given label values, must be called with lock held
+func (f *family) with(values []string) *series {
+	key := strings.Join(values, "\xff")
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{
+			values: append([]string(nil), values...),
+		}
+
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+
+		f.series[key] = s
+	}
+
+	return s
+}
+
+func (f *family) add(v float64, values []string) {
+	f.m.Lock()
+	f.with(values).value += v
+	f.m.Unlock()
+}
+
+func (f *family) set(v float64, values []string) {
+	f.m.Lock()
+	f.with(values).value = v
+	f.m.Unlock()
+}
+
+func (f *family) observe(v float64, values []string) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	s := f.with(values)
+	s.value += v
+	s.count++
+
+	for i, b := range f.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+}
+
+// CounterVec provides monotonically increasing counters partitioned by labels
+type CounterVec struct {
+	f *family
+}
+
+// NewCounter registers counter with given label names
+func (registry *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{f: registry.register(name, help, kindCounter, nil, labels)}
+}
+
+// Inc increments counter with given label values by one
+func (c *CounterVec) Inc(values ...string) {
+	c.f.add(1, values)
+}
+
+// Add increments counter with given label values by v
+func (c *CounterVec) Add(v float64, values ...string) {
+	c.f.add(v, values)
+}
+
+// GaugeVec provides arbitrary values partitioned by labels
+type GaugeVec struct {
+	f *family
+}
+
+// NewGauge registers gauge with given label names
+func (registry *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{f: registry.register(name, help, kindGauge, nil, labels)}
+}
+
+// Set sets gauge with given label values to v
+func (g *GaugeVec) Set(v float64, values ...string) {
+	g.f.set(v, values)
+}
+
+// Add adds v to gauge with given label values
+func (g *GaugeVec) Add(v float64, values ...string) {
+	g.f.add(v, values)
+}
+
+// HistogramVec provides distributions of observed values partitioned by labels
+type HistogramVec struct {
+	f *family
+}
+
+// NewHistogram registers histogram with given upper bounds of buckets and label names
+func (registry *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{f: registry.register(name, help, kindHistogram, buckets, labels)}
+}
+
+// Observe records value v for given label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.f.observe(v, values)
+}
+
+// Since records seconds passed since start for given label values
+func (h *HistogramVec) Since(start time.Time, values ...string) {
+	h.f.observe(time.Since(start).Seconds(), values)
+}
+
+// NewCounter registers counter in default registry
+func NewCounter(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// NewGauge registers gauge in default registry
+func NewGauge(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGauge(name, help, labels...)
+}
+
+// NewHistogram registers histogram in default registry
+func NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	sb := &strings.Builder{}
+
+	_, _ = sb.WriteString("{")
+
+	for i, n := range names {
+		var v string
+
+		if i < len(values) {
+			v = values[i]
+		}
+
+		if i > 0 {
+			_, _ = sb.WriteString(",")
+		}
+
+		_, _ = sb.WriteString(n + `="` + labelEscaper.Replace(v) + `"`)
+	}
+
+	for i := 0; i+1 < len(extra); i += 2 {
+		if len(names) > 0 || i > 0 {
+			_, _ = sb.WriteString(",")
+		}
+
+		_, _ = sb.WriteString(extra[i] + `="` + extra[i+1] + `"`)
+	}
+
+	_, _ = sb.WriteString("}")
+
+	return sb.String()
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	_, _ = w.WriteString("# HELP " + f.name + " " + f.help + "\n")
+	_, _ = w.WriteString("# TYPE " + f.name + " " + f.kind + "\n")
+
+	keys := make([]string, 0, len(f.series))
+
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := f.series[k]
+
+		if f.kind != kindHistogram {
+			_, _ = w.WriteString(f.name + formatLabels(f.labels, s.values) + " " + formatFloat(s.value) + "\n")
+
+			continue
+		}
+
+		for i, b := range f.buckets {
+			labels := formatLabels(f.labels, s.values, "le", formatFloat(b))
+			_, _ = w.WriteString(f.name + "_bucket" + labels + " " + strconv.FormatUint(s.counts[i], 10) + "\n")
+		}
+
+		labels := formatLabels(f.labels, s.values)
+
+		_, _ = w.WriteString(f.name + "_bucket" + formatLabels(f.labels, s.values, "le", "+Inf") + " " +
+			strconv.FormatUint(s.count, 10) + "\n")
+		_, _ = w.WriteString(f.name + "_sum" + labels + " " + formatFloat(s.value) + "\n")
+		_, _ = w.WriteString(f.name + "_count" + labels + " " + strconv.FormatUint(s.count, 10) + "\n")
+	}
+}
+
+// Write renders all registered metrics in prometheus text exposition format
+func (registry *Registry) Write(writer io.Writer) error {
+	registry.m.Lock()
+	families := append([]*family(nil), registry.families...)
+	registry.m.Unlock()
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	w := bufio.NewWriter(writer)
+
+	for _, f := range families {
+		f.write(w)
+	}
+
+	return w.Flush()
+}
+
+// ServeHTTP provides http.Handler serving metrics
+func (registry *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	_ = registry.Write(w)
+}